@@ -16,6 +16,8 @@ if [ ! -h ${DIR}/gopath/src/${REPO_PATH} ]; then
 fi
 
 export GO15VENDOREXPERIMENT=1
+# the sources are built in the GOPATH, with their vendor directory
+export GO111MODULE=off
 export GOBIN=${DIR}/bin
 export GOPATH=${DIR}/gopath
 export GOOS GOARCH
@@ -32,6 +32,32 @@ const (
 	GzipCompression
 )
 
+// ResourcePolicy says what to do with the resource settings of an image, like
+// its memory limit.
+type ResourcePolicy int
+
+const (
+	// ResourcesAnnotate only keeps them in annotations of the ACI.
+	ResourcesAnnotate ResourcePolicy = iota
+	// ResourcesHonor also turns them into isolators of the app.
+	ResourcesHonor
+	// ResourcesDrop discards them.
+	ResourcesDrop
+)
+
+// LintPolicy says whether the converted images are linted, and what to do
+// with the problems found.
+type LintPolicy int
+
+const (
+	// LintNone doesn't lint the images.
+	LintNone LintPolicy = iota
+	// LintWarn reports the problems as warnings.
+	LintWarn
+	// LintFail also fails the conversion if there are errors.
+	LintFail
+)
+
 var (
 	validId = regexp.MustCompile(`^(\w+:)?([A-Fa-f0-9]+)$`)
 )
@@ -50,9 +76,42 @@ const (
 	AppcDockerParentImageID = "appc.io/docker/parentimageid"
 	AppcDockerEntrypoint    = "appc.io/docker/entrypoint"
 	AppcDockerCmd           = "appc.io/docker/cmd"
+	AppcDockerUser          = "appc.io/docker/user"
+	AppcDockerMemory        = "appc.io/docker/memory"
+	AppcDockerMemorySwap    = "appc.io/docker/memoryswap"
+	AppcDockerCPUShares     = "appc.io/docker/cpushares"
+	AppcDockerCpuset        = "appc.io/docker/cpuset"
 	AppcDockerManifestHash  = "appc.io/docker/manifesthash"
+
+	// AppcDockerHealthcheck is the HEALTHCHECK of the image, a Healthcheck
+	// in JSON.
+	AppcDockerHealthcheck = "appc.io/docker/healthcheck"
+	// AppcDockerStopSignal is the STOPSIGNAL of the image, a signal name
+	// like "SIGQUIT" or number.
+	AppcDockerStopSignal = "appc.io/docker/stopsignal"
+	// AppcDockerShell is the SHELL of the image, a JSON array.
+	AppcDockerShell = "appc.io/docker/shell"
+	// AppcDockerOnBuild is the list of ONBUILD instructions of the image, a
+	// JSON array of strings like "RUN make".
+	AppcDockerOnBuild = "appc.io/docker/onbuild"
 )
 
+// Healthcheck is the format of the AppcDockerHealthcheck annotation.
+type Healthcheck struct {
+	// Test is the test to perform: empty to inherit it, ["NONE"] to disable
+	// it, ["CMD", args...] to exec args or ["CMD-SHELL", command] to run
+	// command with the shell of the image.
+	Test []string `json:"test,omitempty"`
+	// Interval, Timeout and StartPeriod are durations like "1m30s". Empty
+	// means Docker's default.
+	Interval    string `json:"interval,omitempty"`    // time between checks
+	Timeout     string `json:"timeout,omitempty"`     // time to wait before a check is considered hung
+	StartPeriod string `json:"startPeriod,omitempty"` // time for the app to start before failures are counted
+	// Retries is the number of consecutive failures needed to be
+	// unhealthy. Zero means Docker's default.
+	Retries int `json:"retries,omitempty"`
+}
+
 const defaultTag = "latest"
 
 // ParsedDockerURL represents a parsed Docker URL.
@@ -64,6 +123,13 @@ type ParsedDockerURL struct {
 	Digest       string
 }
 
+// LayerInfo describes a layer of an image as stored in its source.
+type LayerInfo struct {
+	Digest    string `json:"digest"`              // digest or ID of the layer
+	Size      int64  `json:"size"`                // size in bytes, or -1 if unknown
+	MediaType string `json:"mediaType,omitempty"` // media type of the layer blob
+}
+
 type ErrSeveralImages struct {
 	Msg    string
 	Images []string
@@ -75,10 +141,28 @@ type InsecureConfig struct {
 	AllowHTTP  bool
 }
 
+// TrustConfig represents the Docker Content Trust options
+type TrustConfig struct {
+	Enabled bool   // resolve tags to digests through the Notary server
+	Server  string // Notary server URL, if empty it's derived from the registry
+	Dir     string // trust directory containing the cached TUF root metadata
+}
+
 func (e *ErrSeveralImages) Error() string {
 	return e.Msg
 }
 
+// ErrSeveralPlatforms is returned when an image is available for several
+// platforms and none was selected.
+type ErrSeveralPlatforms struct {
+	Msg       string
+	Platforms []string
+}
+
+func (e *ErrSeveralPlatforms) Error() string {
+	return e.Msg
+}
+
 // ParseDockerURL takes a Docker URL and returns a ParsedDockerURL with its
 // index URL, image name, and tag.
 func ParseDockerURL(arg string) (*ParsedDockerURL, error) {
@@ -130,7 +214,12 @@ const (
 	MediaTypeDockerV22Config       = "application/vnd.docker.container.image.v1+json"
 	MediaTypeDockerV22RootFS       = "application/vnd.docker.image.rootfs.diff.tar.gzip"
 
+	// MediaTypeDockerSavedLayer is the type of the uncompressed layers
+	// "docker save" writes.
+	MediaTypeDockerSavedLayer = "application/vnd.docker.image.rootfs.diff.tar"
+
 	MediaTypeOCIV1Manifest     = spec.MediaTypeImageManifest
+	MediaTypeOCIV1Index        = "application/vnd.oci.image.index.v1+json"
 	MediaTypeOCIV1ManifestList = spec.MediaTypeImageManifestList
 	MediaTypeOCIV1Config       = spec.MediaTypeImageConfig
 	MediaTypeOCIV1Layer        = spec.MediaTypeImageLayer
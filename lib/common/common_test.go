@@ -200,3 +200,135 @@ func TestParseDockerURL(t *testing.T) {
 		}
 	}
 }
+
+func TestMetadataMappingValidate(t *testing.T) {
+	tests := []struct {
+		rule  MetadataRule
+		valid bool
+	}{
+		{MetadataRule{Match: "userlabel:com.example.team", Action: MetadataCopy, To: "label:team"}, true},
+		{MetadataRule{Match: "userlabel:com.example.*", Action: MetadataDrop}, true},
+		{MetadataRule{Match: "annotation:appc.io/docker/*", Action: MetadataRename, To: "example.com/docker/*"}, true},
+		{MetadataRule{Action: MetadataSet, To: "annotation:example.com/source", Value: "{{.Registry}}/{{.Repository}}:{{.Tag}}"}, true},
+		{MetadataRule{Match: "com.example.team", Action: MetadataDrop}, false},
+		{MetadataRule{Match: "userlabel:com.*.team", Action: MetadataDrop}, false},
+		{MetadataRule{Match: "userlabel:team", Action: MetadataRename}, false},
+		{MetadataRule{Match: "userlabel:team", Action: MetadataRename, To: "label:*"}, false},
+		{MetadataRule{Match: "userlabel:team", Action: MetadataDrop, To: "label:team"}, false},
+		{MetadataRule{Action: MetadataSet, To: "team", Value: "foo"}, false},
+		{MetadataRule{Action: MetadataSet, To: "label:team", Value: "{{.Registry"}, false},
+		{MetadataRule{Match: "label:os", Action: "promote", To: "label:system"}, false},
+	}
+
+	for i, tt := range tests {
+		m := MetadataMapping{Rules: []MetadataRule{tt.rule}}
+		if err := m.Validate(); (err == nil) != tt.valid {
+			t.Errorf("#%d: expected valid %v, got error %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestCleanOutputPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{"linux/amd64/nginx-1.13.aci", "linux/amd64/nginx-1.13.aci", true},
+		{"./nginx//latest.aci", "nginx/latest.aci", true},
+		{"a/../nginx.aci", "nginx.aci", true},
+		{"", "", false},
+		{"/tmp/nginx.aci", "", false},
+		{"../nginx.aci", "", false},
+		{"a/../..", "", false},
+		{".", "", false},
+	}
+
+	for _, tt := range tests {
+		p, err := CleanOutputPath(tt.path)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got error %v", tt.path, tt.valid, err)
+		}
+		if p != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.path, tt.expected, p)
+		}
+	}
+}
+
+func TestDockerCompatValidate(t *testing.T) {
+	tests := []struct {
+		links map[string]string
+		valid bool
+	}{
+		{nil, true},
+		{map[string]string{"/dev/stdout": "/proc/self/fd/1", "/dev/fd": "/proc/self/fd"}, true},
+		{map[string]string{"/dev/tty": "/dev/console"}, false},
+		{map[string]string{"/dev/stdout": ""}, false},
+	}
+
+	for i, tt := range tests {
+		d := DockerCompat{StdioSymlinks: tt.links}
+		if err := d.Validate(); (err == nil) != tt.valid {
+			t.Errorf("#%d: expected valid %v, got error %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	f := PathFilter{
+		Exclude: []string{"/usr/share/doc", "/usr/share/locale/*", "/var/cache/apt/*.bin"},
+		Include: []string{"/usr/share/locale/en*", "/usr/share/doc/*/copyright"},
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		excluded bool
+	}{
+		{"/usr/share", false},
+		{"/usr/share/doc", true},
+		{"/usr/share/doc/bash/README", true},
+		{"/usr/share/doc/bash/copyright", false},
+		{"/usr/share/documents", false},
+		{"/usr/share/locale", false},
+		{"/usr/share/locale/fr/LC_MESSAGES/bash.mo", true},
+		{"/usr/share/locale/en_GB/LC_MESSAGES/bash.mo", false},
+		{"/var/cache/apt/pkgcache.bin", true},
+		{"/var/cache/apt/archives", false},
+		{"usr/share/doc/", true},
+	}
+	for _, tt := range tests {
+		if excluded := f.Excluded(tt.path); excluded != tt.excluded {
+			t.Errorf("%s: expected excluded %v, got %v", tt.path, tt.excluded, excluded)
+		}
+	}
+
+	encloses := []struct {
+		path     string
+		encloses bool
+	}{
+		{"/usr/share/doc", true},
+		{"/usr/share/doc/bash", true},
+		{"/usr/share/doc/bash/copyright", false},
+		{"/usr/share/locale", true},
+		{"/usr/share/locale/fr", false},
+		{"/var/cache/apt", false},
+	}
+	for _, tt := range encloses {
+		if encloses := f.Encloses(tt.path); encloses != tt.encloses {
+			t.Errorf("%s: expected encloses %v, got %v", tt.path, tt.encloses, encloses)
+		}
+	}
+
+	var empty *PathFilter
+	if empty.Excluded("/usr") {
+		t.Errorf("expected a nil filter to keep everything")
+	}
+	for _, invalid := range []PathFilter{{Exclude: []string{"usr/share"}}, {Include: []string{"/usr/[a"}}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
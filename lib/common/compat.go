@@ -0,0 +1,61 @@
+// Copyright 2016 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StdioLinks are the symlinks to the standard streams written in every
+// converted image.
+var StdioLinks = []string{"/dev/stdin", "/dev/stdout", "/dev/stderr", "/dev/fd"}
+
+// DockerCompat is a conversion profile emulating in the converted image what
+// Docker sets up in the containers it runs: a default PATH, the working
+// directory, and the /etc/hosts, /etc/resolv.conf and /etc/hostname files
+// mounted over by the runtime.
+type DockerCompat struct {
+	// StdioSymlinks overrides the targets of the symlinks of StdioLinks,
+	// by link.
+	StdioSymlinks map[string]string
+}
+
+// Validate checks that the stdio symlinks of d are known and have a target.
+func (d *DockerCompat) Validate() error {
+	for _, link := range d.StdioLinkNames() {
+		known := false
+		for _, l := range StdioLinks {
+			known = known || l == link
+		}
+		if !known {
+			return fmt.Errorf("unknown stdio symlink %q, expected one of %v", link, StdioLinks)
+		}
+		if d.StdioSymlinks[link] == "" {
+			return fmt.Errorf("stdio symlink %q has no target", link)
+		}
+	}
+	return nil
+}
+
+// StdioLinkNames returns the links overridden by d, sorted.
+func (d *DockerCompat) StdioLinkNames() []string {
+	var links []string
+	for link := range d.StdioSymlinks {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathFilter selects the files of the layers written in the converted
+// images. Patterns are absolute paths with the syntax of path.Match, like
+// "/usr/share/doc" or "/usr/share/locale/*", and apply to the matching paths
+// and everything below them.
+type PathFilter struct {
+	Exclude []string // patterns of the paths left out of the converted images
+	Include []string // patterns of paths kept below an excluded path
+}
+
+// Validate checks that the patterns of f are absolute and well formed.
+func (f *PathFilter) Validate() error {
+	for _, patterns := range [][]string{f.Exclude, f.Include} {
+		for _, p := range patterns {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("invalid path pattern %q: not absolute", p)
+			}
+			if _, err := path.Match(p, "/"); err != nil {
+				return fmt.Errorf("invalid path pattern %q: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Empty says whether f excludes nothing.
+func (f *PathFilter) Empty() bool {
+	return f == nil || len(f.Exclude) == 0
+}
+
+// Excluded says whether the absolute path p is left out by f: the deepest of
+// p and its parents matching a pattern must match an Exclude pattern and no
+// Include pattern.
+func (f *PathFilter) Excluded(p string) bool {
+	if f.Empty() {
+		return false
+	}
+	p = path.Clean("/" + p)
+	for {
+		if matchAny(f.Include, p) {
+			return false
+		}
+		if matchAny(f.Exclude, p) {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+// Encloses says whether the directory p may have paths kept by an Include
+// pattern below it. Such directories are kept even if they're excluded, so
+// that the paths kept below them have their parent directories.
+func (f *PathFilter) Encloses(p string) bool {
+	if f.Empty() {
+		return false
+	}
+	p = path.Clean("/" + p)
+	for _, pattern := range f.Include {
+		// wildcards don't match separators, so only the parent pattern
+		// with as many components as p can match it
+		for parent := path.Dir(path.Clean(pattern)); parent != "/"; parent = path.Dir(parent) {
+			if ok, _ := path.Match(parent, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated, so errors are mismatches
+		if ok, _ := path.Match(path.Clean(pattern), p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+// Copyright 2016 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// Kinds of metadata a MetadataRule applies to, prefixing its names.
+const (
+	MetadataLabel      = "label"      // labels of the ACI
+	MetadataAnnotation = "annotation" // annotations of the ACI
+	MetadataUserLabel  = "userlabel"  // labels of the app, from the Docker labels
+)
+
+// Actions of a MetadataRule.
+const (
+	MetadataRename = "rename" // move the matching entries to To
+	MetadataCopy   = "copy"   // copy the matching entries to To, like a Docker label to a label
+	MetadataDrop   = "drop"   // remove the matching entries
+	MetadataSet    = "set"    // set To to Value
+)
+
+// MetadataMapping controls how the metadata of a Docker image lands in the
+// ACI. Its rules are applied in order to the labels and annotations
+// generated for the image.
+type MetadataMapping struct {
+	Rules []MetadataRule `json:"rules"`
+}
+
+// MetadataRule is a rule of a MetadataMapping.
+//
+// Match and To are names prefixed by their kind, like
+// "userlabel:com.example.team". A Match name ending with "*" matches all the
+// names with that prefix, and a "*" in To is replaced by the rest of the
+// matching name. To defaults to the kind of Match.
+//
+// Value is a text/template of the value, "{{.Value}}" by default, executed
+// with a MetadataValues.
+type MetadataRule struct {
+	Match  string `json:"match,omitempty"`
+	Action string `json:"action"`
+	To     string `json:"to,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// MetadataValues are the values available to the templates of a
+// MetadataRule.
+type MetadataValues struct {
+	Name         string // name of the matching entry, without its kind
+	Value        string // value of the matching entry
+	OriginalName string // name the image was referenced by
+	Registry     string
+	Repository   string
+	Tag          string
+	Digest       string
+}
+
+// LoadMetadataMapping reads a MetadataMapping from a JSON file.
+func LoadMetadataMapping(path string) (*MetadataMapping, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata mapping: %v", err)
+	}
+	var m MetadataMapping
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata mapping: %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks that the rules of m are well-formed.
+func (m *MetadataMapping) Validate() error {
+	for i, r := range m.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid metadata rule %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *MetadataRule) validate() error {
+	switch r.Action {
+	case MetadataRename, MetadataCopy:
+		if r.To == "" {
+			return fmt.Errorf("%s needs a target", r.Action)
+		}
+	case MetadataDrop:
+		if r.To != "" || r.Value != "" {
+			return fmt.Errorf("drop takes no target or value")
+		}
+	case MetadataSet:
+		if r.Match != "" {
+			return fmt.Errorf("set takes no match")
+		}
+		if r.To == "" || strings.Contains(r.To, "*") {
+			return fmt.Errorf("set needs a target without wildcard")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Action != MetadataSet {
+		kind, name := SplitMetadataName(r.Match)
+		if err := validateMetadataKind(kind); err != nil {
+			return err
+		}
+		if name == "" || strings.Contains(strings.TrimSuffix(name, "*"), "*") {
+			return fmt.Errorf("invalid match %q", r.Match)
+		}
+		if r.To != "" && strings.Contains(r.To, "*") && !strings.HasSuffix(name, "*") {
+			return fmt.Errorf("target %q has a wildcard but match %q doesn't", r.To, r.Match)
+		}
+	}
+	if r.To != "" {
+		kind, name := SplitMetadataName(r.To)
+		if kind != "" {
+			if err := validateMetadataKind(kind); err != nil {
+				return err
+			}
+		} else if r.Action == MetadataSet {
+			return fmt.Errorf("target %q needs a kind", r.To)
+		}
+		if name == "" || strings.Count(name, "*") > 1 {
+			return fmt.Errorf("invalid target %q", r.To)
+		}
+	}
+	if _, err := r.Template(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Template parses the value template of r.
+func (r *MetadataRule) Template() (*template.Template, error) {
+	value := r.Value
+	if value == "" {
+		value = "{{.Value}}"
+	}
+	t, err := template.New("value").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value template: %v", err)
+	}
+	return t, nil
+}
+
+// SplitMetadataName splits a name of a MetadataRule into its kind and the
+// name of the entry. The kind is empty if there's none.
+func SplitMetadataName(n string) (kind, name string) {
+	parts := strings.SplitN(n, ":", 2)
+	if len(parts) == 1 {
+		return "", n
+	}
+	switch parts[0] {
+	case MetadataLabel, MetadataAnnotation, MetadataUserLabel:
+		return parts[0], parts[1]
+	}
+	// Docker labels may contain colons
+	return "", n
+}
+
+func validateMetadataKind(kind string) error {
+	switch kind {
+	case MetadataLabel, MetadataAnnotation, MetadataUserLabel:
+		return nil
+	case "":
+		return fmt.Errorf("missing kind, one of %s, %s or %s", MetadataLabel, MetadataAnnotation, MetadataUserLabel)
+	}
+	return fmt.Errorf("unknown kind %q", kind)
+}
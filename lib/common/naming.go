@@ -0,0 +1,129 @@
+// Copyright 2016 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// NamingTemplates are text/templates of the names of the generated ACIs,
+// executed with NamingValues. An empty template keeps the default.
+type NamingTemplates struct {
+	Name    string            // name of the ACI
+	Version string            // value of the version label
+	Labels  map[string]string // values of extra labels, by label name
+	Path    string            // path of the ACI file, relative to the output directory
+}
+
+// NamingValues are the values available to NamingTemplates.
+type NamingValues struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+	OS         string
+	Arch       string
+	Layer      string // index of the layer from the base one, empty for a squashed image
+	LayerID    string // ID of the layer, empty for a squashed image
+	Top        bool   // whether the ACI has the app, true for a squashed image
+	Name       string // name of the ACI, for the Path template
+}
+
+// Validate checks that the templates of n parse.
+func (n *NamingTemplates) Validate() error {
+	templates := map[string]string{
+		"name":    n.Name,
+		"version": n.Version,
+		"path":    n.Path,
+	}
+	for label, t := range n.Labels {
+		if label == "" {
+			return fmt.Errorf("empty label name")
+		}
+		templates["label "+label] = t
+	}
+	for what, t := range templates {
+		if _, err := parseNamingTemplate(what, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LabelNames returns the names of the extra labels of n, sorted.
+func (n *NamingTemplates) LabelNames() []string {
+	var names []string
+	for name := range n.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute executes the template t, "name", "version", "path" or the name
+// of an extra label, with v. It returns an empty string if the template is
+// empty.
+func (n *NamingTemplates) Execute(t string, v NamingValues) (string, error) {
+	var text string
+	switch t {
+	case "name":
+		text = n.Name
+	case "version":
+		text = n.Version
+	case "path":
+		text = n.Path
+	default:
+		text = n.Labels[t]
+		t = "label " + t
+	}
+	tmpl, err := parseNamingTemplate(t, text)
+	if err != nil || tmpl == nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, v); err != nil {
+		return "", fmt.Errorf("error executing %s template: %v", t, err)
+	}
+	return b.String(), nil
+}
+
+// CleanOutputPath checks that p, generated by a path template, is a
+// relative path within the output directory and returns it cleaned.
+func CleanOutputPath(p string) (string, error) {
+	if p == "" || filepath.IsAbs(p) {
+		return "", fmt.Errorf("output path %q isn't relative", p)
+	}
+	p = filepath.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output path %q is outside of the output directory", p)
+	}
+	return p, nil
+}
+
+func parseNamingTemplate(what, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(what).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", what, err)
+	}
+	return t, nil
+}
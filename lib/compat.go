@@ -0,0 +1,143 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/schema"
+)
+
+// runtimeMountTargets are the files Docker mounts over in its containers.
+var runtimeMountTargets = []string{"/etc/hosts", "/etc/resolv.conf", "/etc/hostname"}
+
+// applyDockerCompat applies the Docker compatibility profile compat to
+// manifest, the manifest of the upper layer of the image with the files fs.
+// It returns the entries to add to the rootfs of the upper layer.
+func applyDockerCompat(manifest *schema.ImageManifest, compat *common.DockerCompat, fs *flattenedRootfs, reporter progress.Reporter) ([]*tar.Header, error) {
+	if compat == nil {
+		return nil, nil
+	}
+
+	var entries []*tar.Header
+	created := make(map[string]struct{})
+	addDirs := func(dir string) (bool, error) {
+		dirs, err := missingDirs(fs, dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, d := range dirs {
+			if _, ok := created[d]; ok {
+				continue
+			}
+			created[d] = struct{}{}
+			entries = append(entries, compatHeader(d, tar.TypeDir, 0755, ""))
+		}
+		return true, nil
+	}
+
+	applyDockerCompatPath(manifest, compat)
+	if manifest.App != nil {
+		if wd := manifest.App.WorkingDirectory; wd != "" {
+			ok, err := addDirs(wd)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				progress.Warnf(reporter, "can't create working directory %q: a parent isn't a directory", wd)
+			}
+		}
+	}
+
+	for _, p := range runtimeMountTargets {
+		if _, err := fs.lstat(p); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		ok, err := addDirs(path.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			progress.Warnf(reporter, "can't create %q: a parent isn't a directory", p)
+			continue
+		}
+		entries = append(entries, compatHeader(p, tar.TypeReg, 0644, ""))
+	}
+
+	for _, link := range compat.StdioLinkNames() {
+		entries = append(entries, compatHeader(link, tar.TypeSymlink, 0777, compat.StdioSymlinks[link]))
+	}
+
+	return entries, nil
+}
+
+// applyDockerCompatPath sets the PATH of the app of manifest to Docker's
+// default one if the image sets none, for compat.
+func applyDockerCompatPath(manifest *schema.ImageManifest, compat *common.DockerCompat) {
+	if compat == nil || manifest.App == nil {
+		return
+	}
+	if _, ok := manifest.App.Environment.Get("PATH"); !ok {
+		manifest.App.Environment.Set("PATH", defaultPath)
+	}
+}
+
+// missingDirs returns the directories to create, parents first, for dir to
+// exist in fs. Existing parents are followed if they are symlinks, and an
+// error satisfying os.IsNotExist is returned if one isn't a directory.
+func missingDirs(fs *flattenedRootfs, dir string) ([]string, error) {
+	var missing []string
+	for d := path.Clean("/" + dir); d != "/"; d = path.Dir(d) {
+		resolved, hdr, err := fs.stat(d)
+		if err == nil {
+			if hdr.Typeflag != tar.TypeDir {
+				return nil, &os.PathError{Op: "mkdir", Path: d, Err: os.ErrNotExist}
+			}
+			for i := range missing {
+				missing[i] = path.Join(resolved, strings.TrimPrefix(missing[i], d))
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		missing = append([]string{d}, missing...)
+	}
+	return missing, nil
+}
+
+func compatHeader(name string, typeflag byte, mode int64, linkname string) *tar.Header {
+	return &tar.Header{
+		Name:       name,
+		Typeflag:   typeflag,
+		Mode:       mode,
+		Linkname:   linkname,
+		Uname:      "0",
+		Gname:      "0",
+		ModTime:    time.Unix(0, 0),
+		ChangeTime: time.Unix(0, 0),
+	}
+}
@@ -22,17 +22,23 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/appc/docker2aci/lib/common"
 	"github.com/appc/docker2aci/lib/internal"
+	"github.com/appc/docker2aci/lib/internal/backend/daemon"
 	"github.com/appc/docker2aci/lib/internal/backend/file"
 	"github.com/appc/docker2aci/lib/internal/backend/repository"
 	"github.com/appc/docker2aci/lib/internal/docker"
 	"github.com/appc/docker2aci/lib/internal/tarball"
 	"github.com/appc/docker2aci/lib/internal/util"
 	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
 	"github.com/appc/spec/pkg/acirenderer"
 	"github.com/appc/spec/schema"
 	appctypes "github.com/appc/spec/schema/types"
@@ -42,14 +48,23 @@ import (
 // CommonConfig represents the shared configuration options for converting
 // Docker images.
 type CommonConfig struct {
-	Squash                bool               // squash the layers in one file
-	OutputDir             string             // where to put the resulting ACI
-	TmpDir                string             // directory to use for temporary files
-	Compression           common.Compression // which compression to use for the resulting file(s)
-	CurrentManifestHashes []string           // any manifest hashes the caller already has
-
-	Info  log.Logger
-	Debug log.Logger
+	Squash                bool                    // squash the layers in one file
+	OutputDir             string                  // where to put the resulting ACI
+	TmpDir                string                  // directory to use for temporary files
+	Compression           common.Compression      // which compression to use for the resulting file(s)
+	CurrentManifestHashes []string                // any manifest hashes the caller already has
+	Resources             common.ResourcePolicy   // what to do with the resource settings of the image
+	MetadataMapping       *common.MetadataMapping // rules applied to the labels and annotations of the image
+	Naming                *common.NamingTemplates // templates of the names of the generated ACIs
+	DockerCompat          *common.DockerCompat    // emulate the defaults of Docker containers, if set
+	PathFilter            *common.PathFilter      // paths left out of the converted images
+	Lint                  common.LintPolicy       // whether to lint the converted images, and to fail on errors
+	Reproducible          bool                    // give the same ACI bytes for the same image
+	SourceDateEpoch       time.Time               // with Reproducible, clamp the timestamps to it if not zero
+
+	Info     log.Logger
+	Debug    log.Logger
+	Progress progress.Reporter // receives the conversion events, progress bars on stderr by default
 }
 
 func (c *CommonConfig) initLogger() {
@@ -60,6 +75,10 @@ func (c *CommonConfig) initLogger() {
 	if c.Debug == nil {
 		c.Debug = log.NewNopLogger()
 	}
+
+	if c.Progress == nil {
+		c.Progress = progress.NewBarReporter(os.Stderr)
+	}
 }
 
 // RemoteConfig represents the remote repository specific configuration for
@@ -69,6 +88,7 @@ type RemoteConfig struct {
 	Username        string                // username to use if the image to convert needs authentication
 	Password        string                // password to use if the image to convert needs authentication
 	Insecure        common.InsecureConfig // Insecure options
+	Trust           common.TrustConfig    // Docker Content Trust options
 	MediaTypes      common.MediaTypeSet
 	RegistryOptions common.RegistryOptionSet
 }
@@ -78,6 +98,16 @@ type RemoteConfig struct {
 type FileConfig struct {
 	CommonConfig
 	DockerURL string // select an image if there are several images/tags in the file, Syntax: "{docker registry URL}/{image name}:{tag}"
+	Platform  string // select an image by platform in OCI image indexes, Syntax: "{os}/{arch}[/{variant}]"
+
+	ImageFilter []string // patterns, as in path.Match, selecting the images converted by ConvertAllSavedFile, all by default
+}
+
+// DaemonConfig represents the Docker daemon specific configuration for
+// converting Docker images.
+type DaemonConfig struct {
+	CommonConfig
+	Host string // address of the Docker daemon, like "unix:///var/run/docker.sock" or "tcp://{host}:{port}", $DOCKER_HOST by default
 }
 
 // ConvertRemoteRepo generates ACI images from docker registry URLs.  It takes
@@ -92,22 +122,30 @@ func ConvertRemoteRepo(dockerURL string, config RemoteConfig) ([]string, error)
 	config.initLogger()
 
 	return (&converter{
-		backend: repository.NewRepositoryBackend(
-			config.Username,
-			config.Password,
-			config.Insecure,
-			config.Debug,
-			config.MediaTypes,
-			config.RegistryOptions,
-		),
+		backend:   newRepositoryBackend(config),
 		dockerURL: dockerURL,
 		config:    config.CommonConfig,
 	}).convert()
 }
 
+func newRepositoryBackend(config RemoteConfig) *repository.RepositoryBackend {
+	return repository.NewRepositoryBackend(
+		config.Username,
+		config.Password,
+		config.Insecure,
+		config.Trust,
+		config.Debug,
+		config.Progress,
+		config.MediaTypes,
+		config.RegistryOptions,
+	)
+}
+
 // ConvertSavedFile generates ACI images from a file generated with "docker
-// save".  If there are several images/tags in the file, a particular image can
-// be chosen via FileConfig.DockerURL.
+// save" or from an OCI image layout, either a tarball, possibly compressed, or
+// a directory. If there are several images/tags in the file, a particular
+// image can be chosen via FileConfig.DockerURL, and via FileConfig.Platform if
+// an image is available for several platforms.
 //
 // It returns the list of generated ACI paths.
 func ConvertSavedFile(dockerSavedFile string, config FileConfig) ([]string, error) {
@@ -119,13 +157,199 @@ func ConvertSavedFile(dockerSavedFile string, config FileConfig) ([]string, erro
 	}
 	defer f.Close()
 
+	backend, err := file.NewFileBackend(f, config.TmpDir, config.Platform, config.Debug, config.Info, config.Progress)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
+	return (&converter{
+		backend:   backend,
+		dockerURL: config.DockerURL,
+		config:    config.CommonConfig,
+	}).convert()
+}
+
+// ConvertSavedReader is like ConvertSavedFile, but it reads a tarball from r,
+// like the standard input. Tarballs compressed with gzip, bzip2, xz or zstd
+// are detected and decompressed. Unless r is a seekable file, it is read once
+// and the files of the tarball that don't fit in memory, like layers, are
+// spooled to CommonConfig.TmpDir.
+//
+// It returns the list of generated ACI paths.
+func ConvertSavedReader(r io.Reader, config FileConfig) ([]string, error) {
+	config.initLogger()
+
+	backend, err := newFileBackendFromReader(r, config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
 	return (&converter{
-		backend:   file.NewFileBackend(f, config.Debug, config.Info),
+		backend:   backend,
 		dockerURL: config.DockerURL,
 		config:    config.CommonConfig,
 	}).convert()
 }
 
+// readerImageName names untagged images read from a reader that isn't a
+// file.
+const readerImageName = "image"
+
+func newFileBackendFromReader(r io.Reader, config FileConfig) (*file.FileBackend, error) {
+	if f, ok := r.(*os.File); ok {
+		return file.NewFileBackend(f, config.TmpDir, config.Platform, config.Debug, config.Info, config.Progress)
+	}
+	return file.NewFileBackendFromReader(r, readerImageName, config.TmpDir, config.Platform, config.Debug, config.Info, config.Progress)
+}
+
+// ConvertAllSavedFile generates ACI images from every image in a file
+// generated with "docker save" or in an OCI image layout, or from the images
+// matching FileConfig.ImageFilter. Images are named by their repository tags
+// or ref names, like "{image name}:{tag}". The file is read once, and each
+// image is converted once: the ACIs of an image with several tags are those
+// of its first tag, and the layers shared by several images are converted
+// for the first of them and reused by the others.
+//
+// It returns the list of generated ACI paths of each image, by name. The only
+// image of a file that doesn't name it has an empty name.
+func ConvertAllSavedFile(dockerSavedFile string, config FileConfig) (map[string][]string, error) {
+	config.initLogger()
+
+	f, err := os.Open(dockerSavedFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	backend, err := file.NewFileBackend(f, config.TmpDir, config.Platform, config.Debug, config.Info, config.Progress)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
+	return convertAll(backend, config)
+}
+
+// ConvertAllSavedReader is like ConvertAllSavedFile, but it reads a tarball
+// from r, like ConvertSavedReader.
+func ConvertAllSavedReader(r io.Reader, config FileConfig) (map[string][]string, error) {
+	config.initLogger()
+
+	backend, err := newFileBackendFromReader(r, config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
+	return convertAll(backend, config)
+}
+
+func convertAll(backend *file.FileBackend, config FileConfig) (map[string][]string, error) {
+	images, err := backend.ListImages()
+	if err != nil {
+		return nil, fmt.Errorf("error listing images: %v", err)
+	}
+
+	layers := internal.NewLayerCache()
+	backend.SetLayerCache(layers)
+	var layersDir string
+	if config.Squash {
+		layersDir, err = ioutil.TempDir(config.TmpDir, "docker2aci-")
+		if err != nil {
+			return nil, fmt.Errorf("error creating dir: %v", err)
+		}
+		defer os.RemoveAll(layersDir)
+	}
+
+	acis := make(map[string][]string)
+	// ACIs by image ID
+	converted := make(map[string][]string)
+	matched := false
+	for _, image := range images {
+		match, err := matchImageFilter(image, config.ImageFilter)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			config.Debug.Printf("Skipping image %q", image)
+			continue
+		}
+		matched = true
+
+		_, manhash, _, err := backend.GetImageInfo(image)
+		if err != nil {
+			return nil, fmt.Errorf("error converting image %q: %v", image, err)
+		}
+		aciLayerPaths, ok := converted[manhash]
+		if ok {
+			config.Debug.Printf("Image %q already converted", image)
+		} else {
+			config.Debug.Printf("Converting image %q", image)
+			aciLayerPaths, err = (&converter{
+				backend:   backend,
+				dockerURL: image,
+				config:    config.CommonConfig,
+				layers:    layers,
+				layersDir: layersDir,
+			}).convert()
+			if err != nil {
+				return nil, fmt.Errorf("error converting image %q: %v", image, err)
+			}
+			converted[manhash] = aciLayerPaths
+		}
+		if aciLayerPaths != nil {
+			acis[image] = aciLayerPaths
+		}
+	}
+	if !matched && len(config.ImageFilter) > 0 {
+		return nil, fmt.Errorf("no images matching %s", strings.Join(config.ImageFilter, ", "))
+	}
+
+	return acis, nil
+}
+
+// matchImageFilter returns whether image matches one of the patterns, or
+// whether there are no patterns.
+func matchImageFilter(image string, patterns []string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, p := range patterns {
+		match, err := path.Match(p, image)
+		if err != nil {
+			return false, fmt.Errorf("invalid image filter %q: %v", p, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ConvertDaemonImage generates ACI images from an image stored in a Docker
+// daemon, given by name, like "{image name}:{tag}", or by ID. The image is
+// exported through the Docker Engine API and read as it's received, its
+// layers being spooled to CommonConfig.TmpDir.
+//
+// It returns the list of generated ACI paths.
+func ConvertDaemonImage(image string, config DaemonConfig) ([]string, error) {
+	config.initLogger()
+
+	backend, err := daemon.NewDaemonBackend(config.Host, config.TmpDir, config.Debug, config.Info, config.Progress)
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	return (&converter{
+		backend:   backend,
+		dockerURL: image,
+		config:    config.CommonConfig,
+	}).convert()
+}
+
 // GetIndexName returns the docker index server from a docker URL.
 func GetIndexName(dockerURL string) string {
 	index, _ := docker.SplitReposName(dockerURL)
@@ -138,10 +362,22 @@ func GetDockercfgAuth(indexServer string) (string, string, error) {
 	return docker.GetAuthInfo(indexServer)
 }
 
+// GetDefaultTrustDir returns the directory where Docker caches content trust
+// metadata, ~/.docker/trust.
+func GetDefaultTrustDir() string {
+	return docker.TrustDir()
+}
+
 type converter struct {
 	backend   internal.Docker2ACIBackend
 	dockerURL string
 	config    CommonConfig
+
+	// layers are the layer ACIs shared with the other images converted,
+	// if any, and layersDir the directory of the layers of squashed
+	// images instead of a temporary one
+	layers    *internal.LayerCache
+	layersDir string
 }
 
 func (c *converter) convert() ([]string, error) {
@@ -160,7 +396,9 @@ func (c *converter) convert() ([]string, error) {
 	}
 
 	layersOutputDir := c.config.OutputDir
-	if c.config.Squash {
+	if c.config.Squash && c.layersDir != "" {
+		layersOutputDir = c.layersDir
+	} else if c.config.Squash {
 		layersOutputDir, err = ioutil.TempDir(c.config.TmpDir, "docker2aci-")
 		if err != nil {
 			return nil, fmt.Errorf("error creating dir: %v", err)
@@ -170,16 +408,31 @@ func (c *converter) convert() ([]string, error) {
 
 	conversionStore := newConversionStore()
 
-	// only compress individual layers if we're not squashing
+	// only compress individual layers if we're not squashing, and only once
+	// they're normalized in reproducible mode
 	layerCompression := c.config.Compression
-	if c.config.Squash {
+	outputCompression := c.config.Compression
+	if c.config.Reproducible {
+		outputCompression = common.NoCompression
+	}
+	if c.config.Squash || c.config.Reproducible {
 		layerCompression = common.NoCompression
 	}
 
-	aciLayerPaths, aciManifests, err := c.backend.BuildACI(ancestry, manhash, parsedDockerURL, layersOutputDir, c.config.TmpDir, layerCompression)
+	filter := internal.NewPathFilter(c.config.PathFilter)
+	aciLayerPaths, aciManifests, err := c.backend.BuildACI(ancestry, manhash, parsedDockerURL, layersOutputDir, c.config.TmpDir, layerCompression, filter)
 	if err != nil {
 		return nil, err
 	}
+	if !c.config.PathFilter.Empty() {
+		c.config.Progress.Report(progress.Event{Type: progress.FilesExcluded, Time: time.Now(), Removed: filter.Removed()})
+	}
+	// the layers reused from another image are already complete
+	generatedPaths := append([]string(nil), aciLayerPaths...)
+	reused := make([]bool, len(aciLayerPaths))
+	for i, p := range aciLayerPaths {
+		reused[i] = c.layers.Reused(p)
+	}
 
 	var images acirenderer.Images
 	for i, aciLayerPath := range aciLayerPaths {
@@ -193,20 +446,116 @@ func (c *converter) convert() ([]string, error) {
 
 	// acirenderer expects images in order from upper to base layer
 	images = util.ReverseImages(images)
+
+	if err := c.completeManifest(images, conversionStore, aciLayerPaths[len(aciLayerPaths)-1], layerCompression, *parsedDockerURL); err != nil {
+		return nil, err
+	}
+
 	if c.config.Squash {
-		squashedImagePath, err := squashLayers(images, conversionStore, *parsedDockerURL, c.config.OutputDir, c.config.Compression, c.config.Debug)
+		c.config.Progress.Report(progress.Event{Type: progress.SquashStarted, Time: time.Now()})
+		squashedImagePath, err := squashLayers(images, conversionStore, *parsedDockerURL, c.config.OutputDir, outputCompression, c.config.Naming, c.config.Debug)
 		if err != nil {
 			return nil, fmt.Errorf("error squashing image: %v", err)
 		}
+		c.config.Progress.Report(progress.Event{Type: progress.SquashDone, Time: time.Now(), Path: squashedImagePath})
+		for i, p := range aciLayerPaths {
+			c.layers.Complete(p, p, aciManifests[i])
+		}
 		aciLayerPaths = []string{squashedImagePath}
+		reused = []bool{false}
+	} else {
+		aciManifests[len(aciManifests)-1] = images[0].Im
+		aciLayerPaths, err = c.nameLayers(aciLayerPaths, aciManifests, reused, layerCompression, *parsedDockerURL)
+		if err != nil {
+			return nil, fmt.Errorf("error naming layers: %v", err)
+		}
+		for i, p := range generatedPaths {
+			c.layers.Complete(p, aciLayerPaths[i], aciManifests[i])
+		}
+	}
+
+	if c.config.Reproducible {
+		for i, p := range aciLayerPaths {
+			if reused[i] {
+				continue
+			}
+			if err := internal.NormalizeACI(p, c.config.Compression, c.config.SourceDateEpoch); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := c.lint(aciLayerPaths); err != nil {
+		return nil, err
 	}
 
 	return aciLayerPaths, nil
 }
 
+// completeManifest completes the manifest of the upper layer, at aciPath,
+// with what can only be known from the files of the image, like the ids of
+// the user or the path of the executable, applies the metadata mapping and
+// rewrites the manifest if needed.
+func (c *converter) completeManifest(images acirenderer.Images, conversionStore *conversionStore, aciPath string, compression common.Compression, dockerURL common.ParsedDockerURL) error {
+	manifest := *images[0].Im
+	manifest.Labels = append(appctypes.Labels(nil), manifest.Labels...)
+	manifest.Annotations = append(appctypes.Annotations(nil), manifest.Annotations...)
+	manifest.PathWhitelist = append([]string(nil), manifest.PathWhitelist...)
+	fs := newFlattenedRootfs(images, conversionStore)
+	if manifest.App != nil {
+		app := *manifest.App
+		app.Exec = append(appctypes.Exec(nil), app.Exec...)
+		app.Environment = append(appctypes.Environment(nil), app.Environment...)
+		app.Isolators = append(appctypes.Isolators(nil), app.Isolators...)
+		app.SupplementaryGIDs = append([]int(nil), app.SupplementaryGIDs...)
+		manifest.App = &app
+
+		dockerUser, _ := manifest.Annotations.Get(common.AppcDockerUser)
+		if err := resolveUser(&app, dockerUser, fs, c.config.Progress); err != nil {
+			return fmt.Errorf("error resolving user: %v", err)
+		}
+		if err := resolveExec(&app, fs, c.config.Progress); err != nil {
+			return fmt.Errorf("error resolving executable: %v", err)
+		}
+		if err := applyResources(&manifest, c.config.Resources, c.config.Progress); err != nil {
+			return fmt.Errorf("error applying resource settings: %v", err)
+		}
+	}
+	entries, err := applyDockerCompat(&manifest, c.config.DockerCompat, fs, c.config.Progress)
+	if err != nil {
+		return fmt.Errorf("error applying Docker compatibility profile: %v", err)
+	}
+	if len(manifest.PathWhitelist) > 0 {
+		for _, hdr := range entries {
+			if !util.In(manifest.PathWhitelist, hdr.Name) {
+				manifest.PathWhitelist = append(manifest.PathWhitelist, hdr.Name)
+			}
+		}
+		sort.Strings(manifest.PathWhitelist)
+	}
+	if err := applyMapping(&manifest, c.config.MetadataMapping, dockerURL); err != nil {
+		return err
+	}
+
+	if len(entries) == 0 && reflect.DeepEqual(manifest, *images[0].Im) {
+		return nil
+	}
+	if err := internal.RewriteACI(aciPath, manifest, entries, compression); err != nil {
+		return fmt.Errorf("error rewriting ACI: %v", err)
+	}
+	key, err := conversionStore.WriteACI(aciPath)
+	if err != nil {
+		return fmt.Errorf("error inserting in the conversion store: %v", err)
+	}
+	delete(conversionStore.acis, images[0].Key)
+	images[0].Key = key
+	images[0].Im = &manifest
+	return nil
+}
+
 // squashLayers receives a list of ACI layer file names ordered from base image
 // to application image and squashes them into one ACI
-func squashLayers(images []acirenderer.Image, aciRegistry acirenderer.ACIRegistry, parsedDockerURL common.ParsedDockerURL, outputDir string, compression common.Compression, debug log.Logger) (path string, err error) {
+func squashLayers(images []acirenderer.Image, aciRegistry acirenderer.ACIRegistry, parsedDockerURL common.ParsedDockerURL, outputDir string, compression common.Compression, naming *common.NamingTemplates, debug log.Logger) (path string, err error) {
 	debug.Println("Squashing layers...")
 	debug.Println("Rendering ACI...")
 	renderedACI, err := acirenderer.GetRenderedACIFromList(images, aciRegistry)
@@ -218,8 +567,20 @@ func squashLayers(images []acirenderer.Image, aciRegistry acirenderer.ACIRegistr
 		return "", fmt.Errorf("error getting manifests: %v", err)
 	}
 
+	finalManifest := mergeManifests(manifests)
+	v := namingValues(&manifests[0], parsedDockerURL)
+	if err := applyNaming(&finalManifest, naming, v); err != nil {
+		return "", fmt.Errorf("error naming squashed image: %v", err)
+	}
+
 	squashedFilename := getSquashedFilename(parsedDockerURL)
-	squashedImagePath := filepath.Join(outputDir, squashedFilename)
+	squashedImagePath, err := namingPath(naming, v, finalManifest.Name.String(), outputDir, filepath.Join(outputDir, squashedFilename))
+	if err != nil {
+		return "", fmt.Errorf("error naming squashed image: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(squashedImagePath), 0755); err != nil {
+		return "", err
+	}
 
 	squashedTempFile, err := ioutil.TempFile(outputDir, "docker2aci-squashedFile-")
 	if err != nil {
@@ -236,7 +597,7 @@ func squashLayers(images []acirenderer.Image, aciRegistry acirenderer.ACIRegistr
 	}()
 
 	debug.Println("Writing squashed ACI...")
-	if err := writeSquashedImage(squashedTempFile, renderedACI, aciRegistry, manifests, compression); err != nil {
+	if err := writeSquashedImage(squashedTempFile, renderedACI, aciRegistry, finalManifest, compression); err != nil {
 		return "", fmt.Errorf("error writing squashed image: %v", err)
 	}
 
@@ -277,7 +638,7 @@ func getManifests(renderedACI acirenderer.RenderedACI, aciRegistry acirenderer.A
 	return manifests, nil
 }
 
-func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI, aciProvider acirenderer.ACIProvider, manifests []schema.ImageManifest, compression common.Compression) error {
+func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI, aciProvider acirenderer.ACIProvider, finalManifest schema.ImageManifest, compression common.Compression) error {
 	var tarWriterTarget io.WriteCloser = outputFile
 
 	switch compression {
@@ -289,11 +650,10 @@ func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI
 		return fmt.Errorf("unexpected compression enum value: %d", compression)
 	}
 
-	outputWriter := tar.NewWriter(tarWriterTarget)
+	// the data of the sparse files is spooled next to the output
+	outputWriter := internal.NewTarWriter(tarWriterTarget, filepath.Dir(outputFile.Name()))
 	defer outputWriter.Close()
 
-	finalManifest := mergeManifests(manifests)
-
 	if err := internal.WriteManifest(outputWriter, finalManifest); err != nil {
 		return err
 	}
@@ -366,11 +726,8 @@ func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI
 					return fmt.Errorf("logic error: should we keep file %q?", cleanName)
 				}
 				if keep {
-					if err := outputWriter.WriteHeader(t.Header); err != nil {
-						return fmt.Errorf("error writing header: %v", err)
-					}
-					if _, err := io.Copy(outputWriter, t.TarStream); err != nil {
-						return fmt.Errorf("error copying file into the tar out: %v", err)
+					if err := outputWriter.WriteEntry(t.Header, t.TarStream, nil); err != nil {
+						return fmt.Errorf("error writing file into the tar out: %v", err)
 					}
 				} else {
 					// The current file does not remain but there is a hard link pointing to
@@ -380,12 +737,14 @@ func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI
 					link.firstLinkHeader.Size = t.Header.Size
 					link.firstLinkHeader.Typeflag = t.Header.Typeflag
 					link.firstLinkHeader.Linkname = ""
-
-					if err := outputWriter.WriteHeader(&link.firstLinkHeader); err != nil {
-						return fmt.Errorf("error writing header: %v", err)
-					}
-					if _, err := io.Copy(outputWriter, t.TarStream); err != nil {
-						return fmt.Errorf("error copying file into the tar out: %v", err)
+					// the metadata of the file, like its capabilities, are
+					// on the original entry
+					link.firstLinkHeader.PAXRecords = t.Header.PAXRecords
+					link.firstLinkHeader.Xattrs = t.Header.Xattrs
+					link.firstLinkHeader.Format = t.Header.Format
+
+					if err := outputWriter.WriteEntry(&link.firstLinkHeader, t.TarStream, nil); err != nil {
+						return fmt.Errorf("error writing file into the tar out: %v", err)
 					}
 				}
 			} else if keep {
@@ -406,11 +765,8 @@ func writeSquashedImage(outputFile *os.File, renderedACI acirenderer.RenderedACI
 				}
 
 				if !alreadyWritten {
-					if err := outputWriter.WriteHeader(t.Header); err != nil {
-						return fmt.Errorf("error writing header: %v", err)
-					}
-					if _, err := io.Copy(outputWriter, t.TarStream); err != nil {
-						return fmt.Errorf("error copying file into the tar out: %v", err)
+					if err := outputWriter.WriteEntry(t.Header, t.TarStream, nil); err != nil {
+						return fmt.Errorf("error writing file into the tar out: %v", err)
 					}
 				}
 			}
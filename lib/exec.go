@@ -0,0 +1,79 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"archive/tar"
+	"path"
+	"strings"
+
+	"github.com/appc/docker2aci/pkg/progress"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+// defaultPath is the PATH Docker uses for images which don't set one.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// resolveExec makes the executable of app absolute, as appc requires, looking
+// it up in the image like Docker does: names are searched in the directories
+// of the PATH of the app, and relative paths are relative to its working
+// directory. Executables that can't be found are kept as is, with a warning.
+func resolveExec(app *appctypes.App, fs *flattenedRootfs, reporter progress.Reporter) error {
+	if len(app.Exec) == 0 || path.IsAbs(app.Exec[0]) {
+		return nil
+	}
+	name := app.Exec[0]
+
+	var candidates []string
+	if strings.Contains(name, "/") {
+		dir := app.WorkingDirectory
+		if dir == "" {
+			dir = "/"
+		}
+		candidates = []string{path.Join(dir, name)}
+	} else {
+		pathEnv, ok := app.Environment.Get("PATH")
+		if !ok {
+			pathEnv = defaultPath
+		}
+		for _, dir := range strings.Split(pathEnv, ":") {
+			// empty and relative directories depend on the working
+			// directory at run time, which isn't known here
+			if !path.IsAbs(dir) {
+				continue
+			}
+			candidates = append(candidates, path.Join(dir, name))
+		}
+	}
+
+	if err := fs.index(); err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		if _, hdr, err := fs.stat(c); err == nil && isExecutable(hdr) {
+			app.Exec[0] = c
+			return nil
+		}
+	}
+	progress.Warnf(reporter, "executable %q not found in the image, keeping it as is", name)
+	return nil
+}
+
+func isExecutable(hdr *tar.Header) bool {
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return false
+	}
+	return hdr.Mode&0111 != 0
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/internal/tarball"
+	"github.com/appc/spec/pkg/acirenderer"
+)
+
+// maxSymlinks limits the symlinks followed when resolving a path.
+const maxSymlinks = 40
+
+// flattenedRootfs is a read-only view of the rootfs of an image, as its
+// converted layers render it. Paths are absolute paths in the rootfs.
+type flattenedRootfs struct {
+	images   acirenderer.Images
+	registry acirenderer.ACIRegistry
+
+	// entries maps the paths of the rootfs to the layer providing them,
+	// indexed on first use
+	entries map[string]flatEntry
+}
+
+type flatEntry struct {
+	key string
+	hdr *tar.Header
+}
+
+// newFlattenedRootfs returns the rootfs rendered by images, ordered from upper
+// to base layer. Layers are only read when a file is first looked up.
+func newFlattenedRootfs(images acirenderer.Images, registry acirenderer.ACIRegistry) *flattenedRootfs {
+	return &flattenedRootfs{images: images, registry: registry}
+}
+
+// index renders the layers and walks each of them once to record the files
+// it provides.
+func (fs *flattenedRootfs) index() error {
+	if fs.entries != nil {
+		return nil
+	}
+	rendered, err := acirenderer.GetRenderedACIFromList(fs.images, fs.registry)
+	if err != nil {
+		return fmt.Errorf("error rendering image: %v", err)
+	}
+	entries := make(map[string]flatEntry)
+	for _, aciFile := range rendered {
+		key := aciFile.Key
+		fileMap := aciFile.FileMap
+		err := fs.walk(key, func(t *tarball.TarFile) error {
+			name := path.Clean(t.Name())
+			if _, ok := fileMap[name]; !ok || !strings.HasPrefix(name, "rootfs/") {
+				return nil
+			}
+			entries[strings.TrimPrefix(name, "rootfs")] = flatEntry{key: key, hdr: t.Header}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// layers don't always have entries for the parents of their files
+	for p := range entries {
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			if _, ok := entries[dir]; ok {
+				break
+			}
+			entries[dir] = flatEntry{hdr: &tar.Header{Name: dir, Typeflag: tar.TypeDir}}
+		}
+	}
+	fs.entries = entries
+	return nil
+}
+
+func (fs *flattenedRootfs) walk(key string, walkFunc tarball.WalkFunc) error {
+	rs, err := fs.registry.ReadStream(key)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	tr := tar.NewReader(rs)
+	return tarball.Walk(*tr, walkFunc)
+}
+
+// lstat returns the header of the file at p, without following symlinks.
+func (fs *flattenedRootfs) lstat(p string) (*tar.Header, error) {
+	if err := fs.index(); err != nil {
+		return nil, err
+	}
+	e, ok := fs.entries[path.Clean("/"+p)]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: os.ErrNotExist}
+	}
+	return e.hdr, nil
+}
+
+// resolve follows the symlinks in p, relative to the rootfs, and returns the
+// path of the file it designates and its header.
+func (fs *flattenedRootfs) resolve(p string) (string, *tar.Header, error) {
+	links := 0
+	resolved := "/"
+	rest := splitPath(p)
+	for len(rest) > 0 {
+		cur := path.Join(resolved, rest[0])
+		rest = rest[1:]
+		hdr, err := fs.lstat(cur)
+		if err != nil {
+			return "", nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			resolved = cur
+			if len(rest) == 0 {
+				return resolved, hdr, nil
+			}
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", nil, fmt.Errorf("too many levels of symbolic links in %q", p)
+		}
+		target := hdr.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		// cleaning an absolute path removes the ".." above the root
+		rest = append(splitPath(target), rest...)
+		resolved = "/"
+	}
+	hdr, err := fs.lstat(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolved, hdr, nil
+}
+
+// stat returns the path and header of the file at p, following symlinks and
+// hard links.
+func (fs *flattenedRootfs) stat(p string) (string, *tar.Header, error) {
+	resolved, hdr, err := fs.resolve(p)
+	if err != nil {
+		return "", nil, err
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		// hard links point to a path of the layer, in its rootfs
+		resolved = strings.TrimPrefix(path.Clean(hdr.Linkname), "rootfs")
+		if hdr, err = fs.lstat(resolved); err != nil {
+			return "", nil, err
+		}
+	}
+	return resolved, hdr, nil
+}
+
+// readFile reads the regular file at p, following symlinks.
+func (fs *flattenedRootfs) readFile(p string) ([]byte, error) {
+	return fs.readFileHead(p, -1)
+}
+
+// readFileHead reads the first n bytes of the regular file at p, following
+// symlinks, or all of it if n is negative.
+func (fs *flattenedRootfs) readFileHead(p string, n int64) ([]byte, error) {
+	resolved, hdr, err := fs.stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return nil, fmt.Errorf("%q is not a regular file", p)
+	}
+
+	var data []byte
+	key := fs.entries[resolved].key
+	name := path.Join("rootfs", resolved)
+	err = fs.walk(key, func(t *tarball.TarFile) error {
+		if path.Clean(t.Name()) != name {
+			return nil
+		}
+		var r io.Reader = t.TarStream
+		if n >= 0 {
+			r = io.LimitReader(r, n)
+		}
+		var err error
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%q not found in its layer", p)
+	}
+	return data, nil
+}
+
+// splitPath returns the components of the absolute path p.
+func splitPath(p string) []string {
+	clean := strings.Trim(path.Clean("/"+p), "/")
+	if clean == "" {
+		return nil
+	}
+	return strings.Split(clean, "/")
+}
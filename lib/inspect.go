@@ -0,0 +1,148 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/backend/file"
+	"github.com/appc/spec/schema"
+)
+
+// ImageInfo is the result of inspecting a Docker image without converting it.
+type ImageInfo struct {
+	// Manifest is the ImageManifest the conversion would generate: the
+	// squashed one if CommonConfig.Squash is set, the one of the top layer
+	// otherwise. Its PathWhitelist is left empty since computing it requires
+	// the layers' contents.
+	Manifest *schema.ImageManifest `json:"manifest"`
+	// Layers describes the Docker layers, from the base layer to the top
+	// one.
+	Layers []common.LayerInfo `json:"layers"`
+}
+
+// InspectRemoteRepo fetches the manifest and configuration of an image from a
+// docker registry, but none of its layers, and returns what converting it
+// would produce. dockerURL has the same format as in ConvertRemoteRepo.
+func InspectRemoteRepo(dockerURL string, config RemoteConfig) (*ImageInfo, error) {
+	config.initLogger()
+
+	return (&converter{
+		backend:   newRepositoryBackend(config),
+		dockerURL: dockerURL,
+		config:    config.CommonConfig,
+	}).inspect()
+}
+
+// InspectSavedFile reads the metadata of an image in a file generated with
+// "docker save", without extracting its layers, and returns what converting it
+// would produce.
+func InspectSavedFile(dockerSavedFile string, config FileConfig) (*ImageInfo, error) {
+	config.initLogger()
+
+	f, err := os.Open(dockerSavedFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	backend, err := file.NewFileBackend(f, config.TmpDir, config.Platform, config.Debug, config.Info, config.Progress)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
+	return (&converter{
+		backend:   backend,
+		dockerURL: config.DockerURL,
+		config:    config.CommonConfig,
+	}).inspect()
+}
+
+// InspectSavedReader is like InspectSavedFile, but it reads a tarball from r,
+// like ConvertSavedReader.
+func InspectSavedReader(r io.Reader, config FileConfig) (*ImageInfo, error) {
+	config.initLogger()
+
+	backend, err := newFileBackendFromReader(r, config)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	defer backend.Close()
+
+	return (&converter{
+		backend:   backend,
+		dockerURL: config.DockerURL,
+		config:    config.CommonConfig,
+	}).inspect()
+}
+
+func (c *converter) inspect() (*ImageInfo, error) {
+	c.config.Debug.Println("Getting image info...")
+	ancestry, manhash, parsedDockerURL, err := c.backend.GetImageInfo(c.dockerURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(ancestry) == 0 {
+		return nil, fmt.Errorf("backend image had no useful layers")
+	}
+
+	c.config.Debug.Println("Getting image metadata...")
+	manifest, layers, err := c.backend.GetImageMetadata(ancestry, manhash, parsedDockerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// what needs the files of the image, like the ids of the user, is left
+	// out
+	if err := applyResources(manifest, c.config.Resources, c.config.Progress); err != nil {
+		return nil, fmt.Errorf("error applying resource settings: %v", err)
+	}
+	applyDockerCompatPath(manifest, c.config.DockerCompat)
+	if err := applyMapping(manifest, c.config.MetadataMapping, *parsedDockerURL); err != nil {
+		return nil, err
+	}
+
+	v := namingValues(manifest, *parsedDockerURL)
+	if c.config.Squash {
+		squashed := mergeManifests([]schema.ImageManifest{*manifest})
+		manifest = &squashed
+	} else {
+		v = layerValues(v, len(layers)-1, manifest, true)
+	}
+	if err := applyNaming(manifest, c.config.Naming, v); err != nil {
+		return nil, fmt.Errorf("error naming image: %v", err)
+	}
+
+	// round-trip the manifest to fill in the defaults it would get once
+	// written in an ACI and read back
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling manifest: %v", err)
+	}
+	var normalized schema.ImageManifest
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest: %v", err)
+	}
+
+	return &ImageInfo{
+		Manifest: &normalized,
+		Layers:   layers,
+	}, nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package daemon is an implementation of Docker2ACIBackend for images stored
+// in a Docker daemon, exported through the Docker Engine API.
+//
+// Note: this package is an implementation detail and shouldn't be used outside
+// of docker2aci.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal"
+	"github.com/appc/docker2aci/lib/internal/backend/file"
+	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/schema"
+)
+
+// DefaultHost is the address of the Docker daemon used when DOCKER_HOST isn't
+// set.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// exportedImageName names the untagged images exported by ID.
+const exportedImageName = "image"
+
+var imageIDRegexp = regexp.MustCompile(`^(sha256:)?[a-f0-9]{12,64}$`)
+
+// imageInspect is the subset of the response of /images/{name}/json used
+// here.
+type imageInspect struct {
+	ID       string   `json:"Id"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+type DaemonBackend struct {
+	client  *http.Client
+	baseURL string
+	tmpDir  string
+
+	debug, info log.Logger
+	progress    progress.Reporter
+
+	// export reads the image exported by GetImageInfo
+	export *file.FileBackend
+}
+
+// NewDaemonBackend returns a backend exporting images from the Docker daemon
+// listening at host, like "unix:///var/run/docker.sock" or
+// "tcp://127.0.0.1:2375". If host is empty, DOCKER_HOST is used, and then
+// DefaultHost. Exported images are read once, their layers being spooled to
+// tmpDir.
+//
+// The backend must be closed after use.
+func NewDaemonBackend(host string, tmpDir string, debug, info log.Logger, progress progress.Reporter) (*DaemonBackend, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+	client, baseURL, err := newClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return &DaemonBackend{
+		client:   client,
+		baseURL:  baseURL,
+		tmpDir:   tmpDir,
+		debug:    debug,
+		info:     info,
+		progress: progress,
+	}, nil
+}
+
+// newClient returns an HTTP client connecting to the Docker daemon at host and
+// the base URL of its API.
+func newClient(host string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing Docker host %q: %v", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		// the host is ignored when dialing the socket
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid Docker host %q", host)
+		}
+		return &http.Client{}, "http://" + u.Host, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported Docker host %q, expected unix://PATH or tcp://HOST:PORT", host)
+	}
+}
+
+// get requests path from the Engine API and returns the response body.
+func (db *DaemonBackend) get(path string) (io.ReadCloser, error) {
+	db.debug.Printf("Requesting %s from the Docker daemon", path)
+	res, err := db.client.Get(db.baseURL + path)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the Docker daemon: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		if err := json.Unmarshal(b, &apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return nil, &httpStatusErr{StatusCode: res.StatusCode, Path: path, Message: apiErr.Message}
+	}
+	return res.Body, nil
+}
+
+type httpStatusErr struct {
+	StatusCode int
+	Path       string
+	Message    string
+}
+
+func (e *httpStatusErr) Error() string {
+	return fmt.Sprintf("Unexpected HTTP code from the Docker daemon: %d, path: %s, message: %s", e.StatusCode, e.Path, e.Message)
+}
+
+func (db *DaemonBackend) inspectImage(name string) (*imageInspect, error) {
+	body, err := db.get("/images/" + name + "/json")
+	if httperr, ok := err.(*httpStatusErr); ok && httperr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("image %q not found in the Docker daemon", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var inspect imageInspect
+	if err := json.NewDecoder(body).Decode(&inspect); err != nil {
+		return nil, fmt.Errorf("error decoding image %q: %v", name, err)
+	}
+	return &inspect, nil
+}
+
+// GetImageInfo exports the image named dockerURL, a name or an image ID, from
+// the Docker daemon and reads it as a saved image.
+func (db *DaemonBackend) GetImageInfo(dockerURL string) ([]string, string, *common.ParsedDockerURL, error) {
+	if dockerURL == "" {
+		return nil, "", nil, fmt.Errorf("no image given")
+	}
+	if db.export != nil {
+		return nil, "", nil, fmt.Errorf("an image was already exported")
+	}
+
+	inspect, err := db.inspectImage(dockerURL)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	db.debug.Printf("Exporting image %s", inspect.ID)
+
+	body, err := db.get("/images/" + dockerURL + "/get")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error exporting image %q: %v", dockerURL, err)
+	}
+	defer body.Close()
+
+	db.export, err = file.NewFileBackendFromReader(body, exportedImageName, db.tmpDir, "", db.debug, db.info, db.progress)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error reading exported image %q: %v", dockerURL, err)
+	}
+
+	// the export only contains the requested image, named by its tag
+	// unless dockerURL is an image ID
+	ancestry, manhash, parsedDockerURL, err := db.export.GetImageInfo("")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if !imageIDRegexp.MatchString(dockerURL) {
+		if parsedDockerURL, err = common.ParseDockerURL(dockerURL); err != nil {
+			return nil, "", nil, fmt.Errorf("image provided could not be parsed: %v", err)
+		}
+	}
+	return ancestry, manhash, parsedDockerURL, nil
+}
+
+func (db *DaemonBackend) GetImageMetadata(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	if db.export == nil {
+		return nil, nil, fmt.Errorf("no image exported")
+	}
+	return db.export.GetImageMetadata(layerIDs, manhash, dockerURL)
+}
+
+func (db *DaemonBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
+	if db.export == nil {
+		return nil, nil, fmt.Errorf("no image exported")
+	}
+	return db.export.BuildACI(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
+}
+
+// Close removes the files spooled when reading the exported image.
+func (db *DaemonBackend) Close() error {
+	if db.export == nil {
+		return nil
+	}
+	return db.export.Close()
+}
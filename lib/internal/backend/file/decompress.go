@@ -0,0 +1,99 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// archiveCompression is the compression of a saved image tarball.
+type archiveCompression string
+
+const (
+	noCompression    archiveCompression = ""
+	gzipCompression  archiveCompression = "gzip"
+	bzip2Compression archiveCompression = "bzip2"
+	xzCompression    archiveCompression = "xz"
+	zstdCompression  archiveCompression = "zstd"
+)
+
+var magics = []struct {
+	magic       []byte
+	compression archiveCompression
+}{
+	{[]byte{0x1f, 0x8b}, gzipCompression},
+	{[]byte{0x42, 0x5a, 0x68}, bzip2Compression},
+	{[]byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}, xzCompression},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, zstdCompression},
+}
+
+// maxMagicLen is the length of the longest magic number.
+const maxMagicLen = 6
+
+// detectCompression detects the compression of a tarball from its first
+// bytes.
+func detectCompression(header []byte) archiveCompression {
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.compression
+		}
+	}
+	return noCompression
+}
+
+// decompress returns a reader decompressing r according to the compression
+// detected from its first bytes, or reading it as is if it isn't compressed.
+// The reader must be closed after use.
+func decompress(r io.Reader) (io.ReadCloser, archiveCompression, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(maxMagicLen)
+	if err != nil && err != io.EOF {
+		return nil, noCompression, err
+	}
+
+	compression := detectCompression(header)
+	var dr io.ReadCloser
+	switch compression {
+	case gzipCompression:
+		dr, err = gzip.NewReader(br)
+	case bzip2Compression:
+		dr = ioutil.NopCloser(bzip2.NewReader(br))
+	case xzCompression:
+		var xr *xz.Reader
+		xr, err = xz.NewReader(br)
+		dr = ioutil.NopCloser(xr)
+	case zstdCompression:
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(br)
+		if err == nil {
+			dr = zr.IOReadCloser()
+		}
+	default:
+		dr = ioutil.NopCloser(br)
+	}
+	if err != nil {
+		return nil, noCompression, fmt.Errorf("error decompressing %s archive: %v", compression, err)
+	}
+	return dr, compression, nil
+}
@@ -13,46 +13,107 @@
 // limitations under the License.
 
 // Package file is an implementation of Docker2ACIBackend for files saved via
-// "docker save".
+// "docker save" and OCI image layouts, either as tarballs or directories.
 //
 // Note: this package is an implementation detail and shouldn't be used outside
 // of docker2aci.
 package file
 
 import (
-	"archive/tar"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/appc/docker2aci/lib/common"
 	"github.com/appc/docker2aci/lib/internal"
-	"github.com/appc/docker2aci/lib/internal/tarball"
 	"github.com/appc/docker2aci/lib/internal/types"
 	"github.com/appc/docker2aci/lib/internal/typesV2"
 	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
 	"github.com/appc/spec/schema"
+	godigest "github.com/opencontainers/go-digest"
 	spec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type FileBackend struct {
-	file        *os.File
+	src         source
+	platform    string
 	debug, info log.Logger
+	progress    progress.Reporter
+
+	// blobs locates the config and layers of the image found by
+	// GetImageInfo when they aren't in the blobs directory of an OCI image
+	// layout
+	blobs map[string]savedBlob
+	// annotations are the annotations of the manifest of the image found
+	// by GetImageInfo in an OCI image layout
+	annotations map[string]string
+	// layers are the layer ACIs shared by the images converted
+	layers *internal.LayerCache
 }
 
-func NewFileBackend(file *os.File, debug, info log.Logger) *FileBackend {
+// savedBlob is the location and media type of a config or a layer in a saved
+// image.
+type savedBlob struct {
+	path      string
+	mediaType string
+}
+
+// NewFileBackend returns a backend reading the image saved in file, which can
+// be a tarball or a directory. Tarballs are indexed in a single pass, or
+// spooled to tmpDir if they are compressed or can't be seeked. platform
+// selects an image by platform, as "os/arch[/variant]", in OCI image indexes.
+//
+// The backend must be closed after use.
+func NewFileBackend(file *os.File, tmpDir string, platform string, debug, info log.Logger, progress progress.Reporter) (*FileBackend, error) {
+	src, err := newSource(file, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	return newFileBackend(src, platform, debug, info, progress), nil
+}
+
+// NewFileBackendFromReader returns a backend reading the image saved in a
+// tarball read from r, possibly compressed. r is read once, the files of the
+// tarball that don't fit in memory being spooled to tmpDir. name is the name
+// of the tarball, used to name untagged images.
+//
+// The backend must be closed after use.
+func NewFileBackendFromReader(r io.Reader, name string, tmpDir string, platform string, debug, info log.Logger, progress progress.Reporter) (*FileBackend, error) {
+	src, err := newStreamSource(name, r, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	return newFileBackend(src, platform, debug, info, progress), nil
+}
+
+func newFileBackend(src source, platform string, debug, info log.Logger, progress progress.Reporter) *FileBackend {
 	return &FileBackend{
-		file:  file,
-		debug: debug,
-		info:  info,
+		src:      src,
+		platform: platform,
+		debug:    debug,
+		info:     info,
+		progress: progress,
 	}
 }
 
+// SetLayerCache makes BuildACI reuse the layer ACIs of layers, and add the
+// ones it converts to it.
+func (lb *FileBackend) SetLayerCache(layers *internal.LayerCache) {
+	lb.layers = layers
+}
+
+// Close removes the files spooled when reading the saved image.
+func (lb *FileBackend) Close() error {
+	return lb.src.Close()
+}
+
 // GetImageInfo, given the url for a docker image, will return the
 // following:
 // - []string: an ordered list of all layer hashes
@@ -72,15 +133,22 @@ func (lb *FileBackend) GetImageInfo(dockerURL string) ([]string, string, *common
 	}
 
 	var ancestry []string
-	// default file name is the tar name stripped
-	name := strings.Split(filepath.Base(lb.file.Name()), ".")[0]
-	appImageID, ancestry, parsedDockerURL, err := getImageID(lb.file, parsedDockerURL, name, lb.debug)
+	var appImageID string
+	var err error
+	name := lb.imageName()
+	if hasSavedManifest(lb.src) {
+		appImageID, ancestry, parsedDockerURL, lb.blobs, err = getImageIDSavedManifest(lb.src, parsedDockerURL, name, lb.debug)
+	} else if isOCILayout(lb.src) {
+		appImageID, ancestry, parsedDockerURL, lb.annotations, err = getImageIDOCI(lb.src, parsedDockerURL, lb.platform, name, lb.debug)
+	} else {
+		appImageID, ancestry, parsedDockerURL, err = getImageID(lb.src, parsedDockerURL, name, lb.debug)
+	}
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	if len(ancestry) == 0 {
-		ancestry, err = getAncestry(lb.file, appImageID, lb.debug)
+		ancestry, err = getAncestry(lb.src, appImageID, lb.debug)
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("error getting ancestry: %v", err)
 		}
@@ -92,25 +160,140 @@ func (lb *FileBackend) GetImageInfo(dockerURL string) ([]string, string, *common
 	return ancestry, appImageID, parsedDockerURL, nil
 }
 
-func (lb *FileBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+// ListImages lists the images in the saved image, as docker URLs accepted by
+// GetImageInfo. An image without a name or tag is only listed, as "", if it's
+// the only image.
+func (lb *FileBackend) ListImages() ([]string, error) {
+	if hasSavedManifest(lb.src) {
+		return listSavedManifestImages(lb.src, lb.progress)
+	}
+	if isOCILayout(lb.src) {
+		return listOCIImages(lb.src, lb.imageName(), lb.progress)
+	}
+
+	repob, err := readFile(lb.src, "repositories")
+	if os.IsNotExist(err) {
+		// images are only listed in the repositories file
+		return []string{""}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading repositories file: %v", err)
+	}
+	return listRepositoriesImages(repob)
+}
+
+// imageName returns the name of the images without one in the saved image,
+// the file name stripped of its extensions.
+func (lb *FileBackend) imageName() string {
+	return strings.Split(filepath.Base(lb.src.Name()), ".")[0]
+}
+
+func (lb *FileBackend) GetImageMetadata(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	if len(layerIDs) == 0 {
+		return nil, nil, fmt.Errorf("image has no layers")
+	}
 	if strings.Contains(layerIDs[0], ":") {
-		return lb.BuildACIV22(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression)
+		return lb.getImageMetadataV22(layerIDs, manhash, dockerURL)
 	}
-	var aciLayerPaths []string
-	var aciManifests []*schema.ImageManifest
-	var curPwl []string
 
-	tmpDir, err := ioutil.TempDir(tmpBaseDir, "docker2aci-")
+	var layerTarPaths []string
+	for _, id := range layerIDs {
+		if err := common.ValidateLayerId(id); err != nil {
+			return nil, nil, err
+		}
+		layerTarPaths = append(layerTarPaths, path.Join(id, "layer.tar"))
+	}
+	sizes, err := getFileSizes(lb.src, layerTarPaths)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating dir: %v", err)
+		return nil, nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+
+	var layers []common.LayerInfo
+	for i := len(layerIDs) - 1; i >= 0; i-- {
+		layers = append(layers, common.LayerInfo{
+			Digest:    layerIDs[i],
+			Size:      sizes[layerTarPaths[i]],
+			MediaType: common.MediaTypeDockerSavedLayer,
+		})
+	}
+
+	j, err := getJson(lb.src, layerIDs[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting layer json: %v", err)
+	}
+	layerData := types.DockerImageData{}
+	if err := json.Unmarshal(j, &layerData); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling layer data: %v", err)
+	}
+	manifest, err := internal.GenerateManifest(layerData, manhash, dockerURL, lb.debug)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+	}
+
+	return manifest, layers, nil
+}
+
+func (lb *FileBackend) getImageMetadataV22(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	if len(layerIDs) < 2 {
+		return nil, nil, fmt.Errorf("insufficient layers for oci image")
+	}
+	imageID := layerIDs[0]
+	layerIDs = layerIDs[1:]
+
+	j, err := lb.readBlob(imageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting layer from file: %v", err)
+	}
+	imageConfig := typesV2.ImageConfig{}
+	if err := json.Unmarshal(j, &imageConfig); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling image data: %v", err)
+	}
+
+	var layerTarPaths []string
+	for _, id := range layerIDs {
+		p, err := lb.blobPath(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		layerTarPaths = append(layerTarPaths, p)
+	}
+	sizes, err := getFileSizes(lb.src, layerTarPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var layers []common.LayerInfo
+	var digests []string
+	for i := len(layerIDs) - 1; i >= 0; i-- {
+		layers = append(layers, common.LayerInfo{
+			Digest:    layerIDs[i],
+			Size:      sizes[layerTarPaths[i]],
+			MediaType: lb.layerMediaType(layerIDs[i]),
+		})
+		digests = append(digests, strings.Split(layerIDs[i], ":")[1])
+	}
+
+	manifests, err := internal.GenerateManifestsV22(dockerURL, manhash, &imageConfig, lb.annotations, digests, lb.debug)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+	}
+
+	return manifests[len(manifests)-1], layers, nil
+}
+
+func (lb *FileBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
+	if strings.Contains(layerIDs[0], ":") {
+		return lb.BuildACIV22(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
+	}
+	var aciLayerPaths []string
+	var aciManifests []*schema.ImageManifest
+	var curPwl []string
 
 	for i := len(layerIDs) - 1; i >= 0; i-- {
 		if err := common.ValidateLayerId(layerIDs[i]); err != nil {
 			return nil, nil, err
 		}
-		j, err := getJson(lb.file, layerIDs[i])
+		j, err := getJson(lb.src, layerIDs[i])
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting layer json: %v", err)
 		}
@@ -120,22 +303,21 @@ func (lb *FileBackend) BuildACI(layerIDs []string, manhash string, dockerURL *co
 			return nil, nil, fmt.Errorf("error unmarshaling layer data: %v", err)
 		}
 
-		tmpLayerPath := path.Join(tmpDir, layerIDs[i])
-		tmpLayerPath += ".tar"
-
 		layerTarPath := path.Join(layerIDs[i], "layer.tar")
-		layerFile, err := extractEmbeddedLayer(lb.file, layerTarPath, tmpLayerPath, lb.info)
+		layerFile, err := openEmbeddedLayer(lb.src, layerTarPath, lb.info)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting layer from file: %v", err)
 		}
 		defer layerFile.Close()
 
 		lb.debug.Println("Generating layer ACI...")
-		aciPath, manifest, err := internal.GenerateACI(i, manhash, layerData, dockerURL, outputDir, layerFile, curPwl, compression, lb.debug)
+		aciPath, manifest, err := internal.GenerateACI(i, manhash, layerData, dockerURL, outputDir, layerFile, curPwl, compression, filter, lb.debug)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 		}
 
+		lb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+
 		aciLayerPaths = append(aciLayerPaths, aciPath)
 		aciManifests = append(aciManifests, manifest)
 		curPwl = manifest.PathWhitelist
@@ -144,7 +326,7 @@ func (lb *FileBackend) BuildACI(layerIDs []string, manhash string, dockerURL *co
 	return aciLayerPaths, aciManifests, nil
 }
 
-func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
 	if len(layerIDs) < 2 {
 		return nil, nil, fmt.Errorf("insufficient layers for oci image")
 	}
@@ -155,7 +337,7 @@ func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL
 	imageID := layerIDs[0]
 	layerIDs = layerIDs[1:]
 
-	j, err := getJsonV22(lb.file, imageID)
+	j, err := lb.readBlob(imageID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error getting layer from file: %v", err)
 	}
@@ -164,17 +346,24 @@ func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL
 		return nil, nil, fmt.Errorf("error unmarshaling image data: %v", err)
 	}
 
-	tmpDir, err := ioutil.TempDir(tmpBaseDir, "docker2aci-")
-	if err != nil {
-		return nil, nil, fmt.Errorf("error creating dir: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
 	for i := len(layerIDs) - 1; i >= 0; i-- {
+		// the top layer carries the configuration of the image, even
+		// when it's a lower layer of another image
+		key := internal.LayerKey(layerIDs[i:])
+		if aciPath, manifest, ok := lb.layers.Get(key); ok && i != 0 {
+			lb.debug.Printf("Reusing layer ACI %s", aciPath)
+			aciLayerPaths = append(aciLayerPaths, aciPath)
+			aciManifests = append(aciManifests, manifest)
+			curPwl = manifest.PathWhitelist
+			continue
+		}
+
 		parts := strings.Split(layerIDs[i], ":")
-		tmpLayerPath := path.Join(tmpDir, parts[1])
-		tmpLayerPath += ".tar"
-		layerTarPath := path.Join(append([]string{"blobs"}, parts...)...)
-		layerFile, err := extractEmbeddedLayer(lb.file, layerTarPath, tmpLayerPath, lb.info)
+		layerTarPath, err := lb.blobPath(layerIDs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		layerFile, err := openEmbeddedLayer(lb.src, layerTarPath, lb.info)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting layer from file: %v", err)
 		}
@@ -183,14 +372,19 @@ func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL
 		var aciPath string
 		var manifest *schema.ImageManifest
 		if i != 0 {
-			aciPath, manifest, err = internal.GenerateACI22LowerLayer(dockerURL, parts[1], outputDir, layerFile, curPwl, compression)
+			aciPath, manifest, err = internal.GenerateACI22LowerLayer(dockerURL, parts[1], outputDir, layerFile, curPwl, compression, filter)
 		} else {
-			aciPath, manifest, err = internal.GenerateACI22TopLayer(dockerURL, manhash, &imageConfig, parts[1], outputDir, layerFile, curPwl, compression, aciManifests, lb.debug)
+			aciPath, manifest, err = internal.GenerateACI22TopLayer(dockerURL, manhash, &imageConfig, lb.annotations, parts[1], outputDir, layerFile, curPwl, compression, filter, aciManifests, lb.debug)
 		}
 		if err != nil {
 			return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 		}
 
+		lb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+		if i != 0 {
+			lb.layers.Add(key, aciPath, manifest)
+		}
+
 		aciLayerPaths = append(aciLayerPaths, aciPath)
 		aciManifests = append(aciManifests, manifest)
 		curPwl = manifest.PathWhitelist
@@ -199,264 +393,246 @@ func (lb *FileBackend) BuildACIV22(layerIDs []string, manhash string, dockerURL
 	return aciLayerPaths, aciManifests, nil
 }
 
-func getImageID(file *os.File, dockerURL *common.ParsedDockerURL, name string, debug log.Logger) (string, []string, *common.ParsedDockerURL, error) {
+func getImageID(src source, dockerURL *common.ParsedDockerURL, name string, debug log.Logger) (string, []string, *common.ParsedDockerURL, error) {
 	debug.Println("getting image id...")
-	type tags map[string]string
-	type apps map[string]tags
-
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("error seeking file: %v", err)
-	}
 
 	tag := "latest"
 	if dockerURL != nil {
 		tag = dockerURL.Tag
 	}
 
-	var imageID string
-	var ancestry []string
-	var appName string
-	reposWalker := func(t *tarball.TarFile) error {
-		clean := filepath.Clean(t.Name())
-		if clean == "repositories" {
-			repob, err := ioutil.ReadAll(t.TarStream)
-			if err != nil {
-				return fmt.Errorf("error reading repositories file: %v", err)
-			}
+	repob, err := readFile(src, "repositories")
+	if err == nil {
+		return getImageIDFromRepositories(repob, dockerURL, tag)
+	}
+	if !os.IsNotExist(err) {
+		return "", nil, nil, fmt.Errorf("error reading repositories file: %v", err)
+	}
 
-			var unparsedRepositories apps
-			if err := json.Unmarshal(repob, &unparsedRepositories); err != nil {
-				return fmt.Errorf("error unmarshaling repositories file")
-			}
+	refb, err := readFile(src, "refs/"+tag)
+	if os.IsNotExist(err) {
+		return "", nil, nil, fmt.Errorf("Could not find image")
+	}
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error reading ref descriptor for tag %s: %v", tag, err)
+	}
 
-			repositories := make(apps, 0)
-			// Normalize repository keys since the image potentially passed in is
-			// normalized
-			for key, val := range unparsedRepositories {
-				parsed, err := common.ParseDockerURL(key)
-				if err != nil {
-					return fmt.Errorf("error parsing key %q in repositories: %v", key, err)
-				}
-				repositories[parsed.ImageName] = val
-			}
+	if dockerURL == nil {
+		dockerURL = &common.ParsedDockerURL{
+			IndexURL:  "",
+			Tag:       tag,
+			ImageName: name,
+		}
+	}
 
-			if dockerURL == nil {
-				n := len(repositories)
-				switch {
-				case n == 1:
-					for key, _ := range repositories {
-						appName = key
-					}
-				case n > 1:
-					var appNames []string
-					for key, _ := range repositories {
-						appNames = append(appNames, key)
-					}
-					return &common.ErrSeveralImages{
-						Msg:    "several images found",
-						Images: appNames,
-					}
-				default:
-					return fmt.Errorf("no images found")
-				}
-			} else {
-				appName = dockerURL.ImageName
-			}
+	var ref spec.Descriptor
+	if err := json.Unmarshal(refb, &ref); err != nil {
+		return "", nil, nil, fmt.Errorf("error unmarshaling ref descriptor for tag %s", tag)
+	}
+	imageID, ancestry, _, err := getDataFromManifest(src, ref.Digest)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if imageID == "" {
+		return "", nil, nil, fmt.Errorf("Could not find image")
+	}
 
-			app, ok := repositories[appName]
-			if !ok {
-				return fmt.Errorf("app %q not found", appName)
-			}
+	return imageID, ancestry, dockerURL, nil
+}
 
-			_, ok = app[tag]
-			if !ok {
-				if len(app) == 1 {
-					for key, _ := range app {
-						tag = key
-					}
-				} else {
-					return fmt.Errorf("tag %q not found", tag)
-				}
-			}
+func getImageIDFromRepositories(repob []byte, dockerURL *common.ParsedDockerURL, tag string) (string, []string, *common.ParsedDockerURL, error) {
+	type tags map[string]string
+	type apps map[string]tags
 
-			if dockerURL == nil {
-				dockerURL = &common.ParsedDockerURL{
-					OriginalName: "",
-					IndexURL:     "",
-					Tag:          tag,
-					ImageName:    appName,
-				}
-			}
+	var unparsedRepositories apps
+	if err := json.Unmarshal(repob, &unparsedRepositories); err != nil {
+		return "", nil, nil, fmt.Errorf("error unmarshaling repositories file")
+	}
 
-			imageID = string(app[tag])
+	repositories := make(apps, 0)
+	// Normalize repository keys since the image potentially passed in is
+	// normalized
+	for key, val := range unparsedRepositories {
+		parsed, err := common.ParseDockerURL(key)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("error parsing key %q in repositories: %v", key, err)
 		}
+		repositories[parsed.ImageName] = val
+	}
 
-		if clean == "refs/"+tag {
-			refb, err := ioutil.ReadAll(t.TarStream)
-			if err != nil {
-				return fmt.Errorf("error reading ref descriptor for tag %s: %v", tag, err)
+	var appName string
+	if dockerURL == nil {
+		n := len(repositories)
+		switch {
+		case n == 1:
+			for key, _ := range repositories {
+				appName = key
 			}
-
-			if dockerURL == nil {
-				dockerURL = &common.ParsedDockerURL{
-					IndexURL:  "",
-					Tag:       tag,
-					ImageName: name,
-				}
+		case n > 1:
+			var appNames []string
+			for key, _ := range repositories {
+				appNames = append(appNames, key)
 			}
-
-			var ref spec.Descriptor
-			if err := json.Unmarshal(refb, &ref); err != nil {
-				return fmt.Errorf("error unmarshaling ref descriptor for tag %s", tag)
+			return "", nil, nil, &common.ErrSeveralImages{
+				Msg:    "several images found",
+				Images: appNames,
 			}
-			imageID, ancestry, err = getDataFromManifest(file, ref.Digest)
-			if err != nil {
-				return err
+		default:
+			return "", nil, nil, fmt.Errorf("no images found")
+		}
+	} else {
+		appName = dockerURL.ImageName
+	}
+
+	app, ok := repositories[appName]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("app %q not found", appName)
+	}
+
+	_, ok = app[tag]
+	if !ok {
+		if len(app) == 1 {
+			for key, _ := range app {
+				tag = key
 			}
-			return io.EOF
+		} else {
+			return "", nil, nil, fmt.Errorf("tag %q not found", tag)
 		}
-		return nil
 	}
 
-	tr := tar.NewReader(file)
-	if err := tarball.Walk(*tr, reposWalker); err != nil && err != io.EOF {
-		return "", nil, nil, err
+	if dockerURL == nil {
+		dockerURL = &common.ParsedDockerURL{
+			OriginalName: "",
+			IndexURL:     "",
+			Tag:          tag,
+			ImageName:    appName,
+		}
 	}
 
+	imageID := string(app[tag])
 	if imageID == "" {
 		return "", nil, nil, fmt.Errorf("Could not find image")
 	}
 
-	return imageID, ancestry, dockerURL, nil
+	return imageID, nil, dockerURL, nil
 }
 
-func getDataFromManifest(file *os.File, manifestID string) (string, []string, error) {
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return "", nil, fmt.Errorf("error seeking file: %v", err)
+func listRepositoriesImages(repob []byte) ([]string, error) {
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(repob, &repositories); err != nil {
+		return nil, fmt.Errorf("error unmarshaling repositories file")
 	}
 
-	parts := append([]string{"blobs"}, strings.Split(manifestID, ":")...)
-	jsonPath := path.Join(parts...)
-
-	var imageID string
-	var ancestry []string
-	reposWalker := func(t *tarball.TarFile) error {
-		clean := filepath.Clean(t.Name())
-		if clean == jsonPath {
-
-			manb, err := ioutil.ReadAll(t.TarStream)
-			if err != nil {
-				return fmt.Errorf("error reading image manifest: %v", err)
-			}
-
-			var manifest typesV2.ImageManifest
-			if err := json.Unmarshal(manb, &manifest); err != nil {
-				return fmt.Errorf("error unmarshaling image manifest")
-			}
-			if manifest.Config == nil {
-				return fmt.Errorf("manifest does not contain a config")
-			}
-			imageID = manifest.Config.Digest
-			// put them in reverse order
-			for i := len(manifest.Layers) - 1; i >= 0; i-- {
-				ancestry = append(ancestry, manifest.Layers[i].Digest)
-			}
+	var images []string
+	for name, tags := range repositories {
+		for tag := range tags {
+			images = append(images, name+":"+tag)
 		}
-		return nil
 	}
+	sort.Strings(images)
+	return images, nil
+}
 
-	tr := tar.NewReader(file)
-	if err := tarball.Walk(*tr, reposWalker); err != nil {
-		return "", nil, err
+// getDataFromManifest returns the config digest, the layers, from the top one
+// to the base one, and the annotations of an image manifest.
+func getDataFromManifest(src source, manifestID string) (string, []string, map[string]string, error) {
+	manb, err := readBlob(src, manifestID)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error reading image manifest: %v", err)
 	}
 
-	return imageID, ancestry, nil
+	var manifest typesV2.ImageManifest
+	if err := json.Unmarshal(manb, &manifest); err != nil {
+		return "", nil, nil, fmt.Errorf("error unmarshaling image manifest")
+	}
+	if manifest.Config == nil {
+		return "", nil, nil, fmt.Errorf("manifest does not contain a config")
+	}
+	var ancestry []string
+	// put them in reverse order
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		ancestry = append(ancestry, manifest.Layers[i].Digest)
+	}
+
+	return manifest.Config.Digest, ancestry, manifest.Annotations, nil
 }
 
-func getJson(file *os.File, layerID string) ([]byte, error) {
+func getJson(src source, layerID string) ([]byte, error) {
 	jsonPath := path.Join(layerID, "json")
-	return getTarFileBytes(file, jsonPath)
+	return readFile(src, jsonPath)
 }
 
-func getJsonV22(file *os.File, layerID string) ([]byte, error) {
-	parts := append([]string{"blobs"}, strings.Split(layerID, ":")...)
-	jsonPath := path.Join(parts...)
-	return getTarFileBytes(file, jsonPath)
+// blobPath returns the path of the config or layer id of the image found by
+// GetImageInfo.
+func (lb *FileBackend) blobPath(id string) (string, error) {
+	if b, ok := lb.blobs[id]; ok {
+		return b.path, nil
+	}
+	return blobPath(id)
 }
 
-func getTarFileBytes(file *os.File, path string) ([]byte, error) {
-	_, err := file.Seek(0, 0)
+func (lb *FileBackend) readBlob(id string) ([]byte, error) {
+	p, err := lb.blobPath(id)
 	if err != nil {
-		return nil, fmt.Errorf("error seeking file: %v", err)
-	}
-
-	var fileBytes []byte
-	fileWalker := func(t *tarball.TarFile) error {
-		if filepath.Clean(t.Name()) == path {
-			fileBytes, err = ioutil.ReadAll(t.TarStream)
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
-	}
-
-	tr := tar.NewReader(file)
-	if err := tarball.Walk(*tr, fileWalker); err != nil {
 		return nil, err
 	}
+	return readFile(lb.src, p)
+}
 
-	if fileBytes == nil {
-		return nil, fmt.Errorf("file %q not found", path)
+func (lb *FileBackend) layerMediaType(id string) string {
+	if b, ok := lb.blobs[id]; ok && b.mediaType != "" {
+		return b.mediaType
 	}
-
-	return fileBytes, nil
+	return common.MediaTypeOCIV1Layer
 }
 
-func extractEmbeddedLayer(file *os.File, layerTarPath string, outputPath string, info log.Logger) (*os.File, error) {
-	info.Println("Extracting ", layerTarPath)
-	_, err := file.Seek(0, 0)
+// blobPath returns the path of a blob in the blobs directory of an OCI image
+// layout.
+func blobPath(digest string) (string, error) {
+	d, err := godigest.Parse(digest)
 	if err != nil {
-		return nil, fmt.Errorf("error seeking file: %v", err)
+		return "", fmt.Errorf("invalid digest %q: %v", digest, err)
 	}
+	return path.Join("blobs", d.Algorithm().String(), d.Hex()), nil
+}
 
-	var layerFile *os.File
-	fileWalker := func(t *tarball.TarFile) error {
-		if filepath.Clean(t.Name()) == layerTarPath {
-			layerFile, err = os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("error creating layer: %v", err)
-			}
-
-			_, err = io.Copy(layerFile, t.TarStream)
-			if err != nil {
-				return fmt.Errorf("error getting layer: %v", err)
-			}
+// getFileSizes returns the sizes of the given files in the saved image.
+func getFileSizes(src source, paths []string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, p := range paths {
+		size, err := src.Size(p)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("file %q not found", p)
 		}
-
-		return nil
+		if err != nil {
+			return nil, err
+		}
+		sizes[p] = size
 	}
 
-	tr := tar.NewReader(file)
-	if err := tarball.Walk(*tr, fileWalker); err != nil {
-		return nil, err
-	}
+	return sizes, nil
+}
+
+// openEmbeddedLayer opens a layer of the saved image, which is read in place
+// rather than extracted.
+func openEmbeddedLayer(src source, layerTarPath string, info log.Logger) (sourceFile, error) {
+	info.Println("Reading ", layerTarPath)
 
-	if layerFile == nil {
+	r, err := src.Open(layerTarPath)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("file %q not found", layerTarPath)
 	}
-
-	return layerFile, nil
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // getAncestry computes an image ancestry, returning an ordered list
 // of dependencies starting from the topmost image to the base.
 // It checks for dependency loops via duplicate detection in the image
 // chain and errors out in such cases.
-func getAncestry(file *os.File, imgID string, debug log.Logger) ([]string, error) {
+func getAncestry(src source, imgID string, debug log.Logger) ([]string, error) {
 	var ancestry []string
 	deps := make(map[string]bool)
 
@@ -470,7 +646,7 @@ func getAncestry(file *os.File, imgID string, debug log.Logger) ([]string, error
 		deps[curImgID] = true
 		ancestry = append(ancestry, curImgID)
 		debug.Printf("Getting ancestry for layer %q", curImgID)
-		curImgID, err = getParent(file, curImgID, debug)
+		curImgID, err = getParent(src, curImgID, debug)
 		if err != nil {
 			return nil, err
 		}
@@ -478,38 +654,21 @@ func getAncestry(file *os.File, imgID string, debug log.Logger) ([]string, error
 	return ancestry, nil
 }
 
-func getParent(file *os.File, imgID string, debug log.Logger) (string, error) {
-	var parent string
-
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return "", fmt.Errorf("error seeking file: %v", err)
-	}
-
-	jsonPath := filepath.Join(imgID, "json")
-	parentWalker := func(t *tarball.TarFile) error {
-		if filepath.Clean(t.Name()) == jsonPath {
-			jsonb, err := ioutil.ReadAll(t.TarStream)
-			if err != nil {
-				return fmt.Errorf("error reading layer json: %v", err)
-			}
-
-			var dockerData types.DockerImageData
-			if err := json.Unmarshal(jsonb, &dockerData); err != nil {
-				return fmt.Errorf("error unmarshaling layer data: %v", err)
-			}
-
-			parent = dockerData.Parent
+func getParent(src source, imgID string, debug log.Logger) (string, error) {
+	var dockerData types.DockerImageData
+
+	jsonb, err := getJson(src, imgID)
+	switch {
+	case os.IsNotExist(err):
+		// no json, no parent
+	case err != nil:
+		return "", fmt.Errorf("error reading layer json: %v", err)
+	default:
+		if err := json.Unmarshal(jsonb, &dockerData); err != nil {
+			return "", fmt.Errorf("error unmarshaling layer data: %v", err)
 		}
-
-		return nil
-	}
-
-	tr := tar.NewReader(file)
-	if err := tarball.Walk(*tr, parentWalker); err != nil {
-		return "", err
 	}
 
-	debug.Printf("Layer %q depends on layer %q", imgID, parent)
-	return parent, nil
+	debug.Printf("Layer %q depends on layer %q", imgID, dockerData.Parent)
+	return dockerData.Parent, nil
 }
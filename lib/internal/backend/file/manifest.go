@@ -0,0 +1,174 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// savedManifestFile is the manifest written by "docker save" since Docker
+// 1.10.
+const savedManifestFile = "manifest.json"
+
+// hasSavedManifest returns whether src was generated by a "docker save"
+// writing a manifest.json file.
+func hasSavedManifest(src source) bool {
+	_, err := src.Size(savedManifestFile)
+	return err == nil
+}
+
+// getImageIDSavedManifest selects an image in the manifest.json file of a
+// "docker save" archive by its repository tags. It returns the digest of its
+// config, its layers from the top one to the base one identified by their
+// diff IDs, and where to find them in the archive.
+func getImageIDSavedManifest(src source, dockerURL *common.ParsedDockerURL, name string, debug log.Logger) (string, []string, *common.ParsedDockerURL, map[string]savedBlob, error) {
+	debug.Println("getting image id from manifest.json...")
+
+	manb, err := readFile(src, savedManifestFile)
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("error reading %s: %v", savedManifestFile, err)
+	}
+	var manifests []typesV2.SavedImageManifest
+	if err := json.Unmarshal(manb, &manifests); err != nil {
+		return "", nil, nil, nil, fmt.Errorf("error unmarshaling %s: %v", savedManifestFile, err)
+	}
+
+	manifest, dockerURL, err := selectSavedManifest(manifests, dockerURL, name)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	configb, err := readFile(src, manifest.Config)
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("error reading image config: %v", err)
+	}
+	var config typesV2.ImageConfig
+	if err := json.Unmarshal(configb, &config); err != nil {
+		return "", nil, nil, nil, fmt.Errorf("error unmarshaling image config: %v", err)
+	}
+	if config.RootFS == nil || len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return "", nil, nil, nil, fmt.Errorf("the image config doesn't match the layers in %s", savedManifestFile)
+	}
+
+	imageID := godigest.FromBytes(configb).String()
+	blobs := map[string]savedBlob{
+		imageID: {path: manifest.Config},
+	}
+	var ancestry []string
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		diffID := config.RootFS.DiffIDs[i]
+		if _, err := godigest.Parse(diffID); err != nil {
+			return "", nil, nil, nil, fmt.Errorf("invalid diff ID %q: %v", diffID, err)
+		}
+		blobs[diffID] = savedBlob{
+			path:      manifest.Layers[i],
+			mediaType: common.MediaTypeDockerSavedLayer,
+		}
+		ancestry = append(ancestry, diffID)
+	}
+
+	return imageID, ancestry, dockerURL, blobs, nil
+}
+
+// listSavedManifestImages lists the images in the manifest.json file of a
+// "docker save" archive by their repository tags. An untagged image is only
+// listed, as "", if it's the only image.
+func listSavedManifestImages(src source, reporter progress.Reporter) ([]string, error) {
+	manb, err := readFile(src, savedManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", savedManifestFile, err)
+	}
+	var manifests []typesV2.SavedImageManifest
+	if err := json.Unmarshal(manb, &manifests); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %v", savedManifestFile, err)
+	}
+	if len(manifests) == 1 && len(manifests[0].RepoTags) == 0 {
+		return []string{""}, nil
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	for _, m := range manifests {
+		if len(m.RepoTags) == 0 {
+			progress.Warnf(reporter, "skipping untagged image %s", m.Config)
+		}
+		for _, t := range m.RepoTags {
+			if !seen[t] {
+				seen[t] = true
+				images = append(images, t)
+			}
+		}
+	}
+	return images, nil
+}
+
+// selectSavedManifest selects the image tagged as dockerURL, or the only image
+// if dockerURL is nil, and returns it with its docker URL.
+func selectSavedManifest(manifests []typesV2.SavedImageManifest, dockerURL *common.ParsedDockerURL, name string) (*typesV2.SavedImageManifest, *common.ParsedDockerURL, error) {
+	if dockerURL != nil {
+		for i, m := range manifests {
+			for _, t := range m.RepoTags {
+				parsed, err := common.ParseDockerURL(t)
+				if err != nil {
+					continue
+				}
+				if parsed.IndexURL == dockerURL.IndexURL &&
+					parsed.ImageName == dockerURL.ImageName &&
+					parsed.Tag == dockerURL.Tag {
+					return &manifests[i], dockerURL, nil
+				}
+			}
+		}
+		return nil, nil, fmt.Errorf("image %q not found", dockerURL.OriginalName)
+	}
+
+	switch len(manifests) {
+	case 0:
+		return nil, nil, fmt.Errorf("no images found")
+	case 1:
+	default:
+		var images []string
+		for _, m := range manifests {
+			if len(m.RepoTags) == 0 {
+				images = append(images, m.Config)
+			}
+			images = append(images, m.RepoTags...)
+		}
+		return nil, nil, &common.ErrSeveralImages{
+			Msg:    "several images found",
+			Images: images,
+		}
+	}
+
+	m := &manifests[0]
+	for _, t := range m.RepoTags {
+		if parsed, err := common.ParseDockerURL(t); err == nil {
+			return m, parsed, nil
+		}
+	}
+	// untagged image
+	return m, &common.ParsedDockerURL{
+		IndexURL:  "",
+		Tag:       "latest",
+		ImageName: name,
+	}, nil
+}
@@ -0,0 +1,350 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	ociLayoutFile = "oci-layout"
+	ociIndexFile  = "index.json"
+
+	// ociRefNameAnnotation names the manifests of an OCI image layout.
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	// maxIndexDepth limits the nesting of image indexes.
+	maxIndexDepth = 8
+)
+
+// ociManifest is a manifest found in the index of an OCI image layout.
+type ociManifest struct {
+	ref      string // ref name, possibly inherited from an enclosing index
+	platform *typesV2.ImagePlatform
+	digest   string
+}
+
+// isOCILayout returns whether src is an OCI image layout, as opposed to the
+// older layouts handled by getImageID.
+func isOCILayout(src source) bool {
+	if _, err := src.Size(ociLayoutFile); err != nil {
+		return false
+	}
+	_, err := src.Size(ociIndexFile)
+	return err == nil
+}
+
+// getImageIDOCI selects an image in the index of an OCI image layout by ref
+// name and platform and returns its config digest, its layers, from the top
+// one to the base one, and the annotations of its manifest.
+func getImageIDOCI(src source, dockerURL *common.ParsedDockerURL, platform string, name string, debug log.Logger) (string, []string, *common.ParsedDockerURL, map[string]string, error) {
+	debug.Println("getting image id from OCI image layout...")
+
+	manifests, err := readOCIIndex(src)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	m, err := selectOCIManifest(manifests, dockerURL, platform)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	debug.Printf("selected manifest %s", m.digest)
+
+	if dockerURL == nil {
+		dockerURL = ociDockerURL(m.ref, name)
+	}
+
+	imageID, ancestry, annotations, err := getDataFromManifest(src, m.digest)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	return imageID, ancestry, dockerURL, annotations, nil
+}
+
+// readOCIIndex checks the version of an OCI image layout and lists the
+// manifests in its index.
+func readOCIIndex(src source) ([]ociManifest, error) {
+	layoutb, err := readFile(src, ociLayoutFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", ociLayoutFile, err)
+	}
+	var layout struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err := json.Unmarshal(layoutb, &layout); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %v", ociLayoutFile, err)
+	}
+	if !strings.HasPrefix(layout.Version, "1.") {
+		return nil, fmt.Errorf("unsupported OCI image layout version %q", layout.Version)
+	}
+
+	indexb, err := readFile(src, ociIndexFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", ociIndexFile, err)
+	}
+	var index typesV2.ImageIndex
+	if err := json.Unmarshal(indexb, &index); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %v", ociIndexFile, err)
+	}
+
+	return getOCIManifests(src, &index, "", 0)
+}
+
+// listOCIImages lists the images of an OCI image layout by ref name. Images
+// with several platforms are listed once. An unnamed image is only listed,
+// as "", if it's the only image.
+func listOCIImages(src source, name string, reporter progress.Reporter) ([]string, error) {
+	manifests, err := readOCIIndex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	seen := make(map[string]bool)
+	for _, m := range manifests {
+		if !seen[m.ref] {
+			seen[m.ref] = true
+			refs = append(refs, m.ref)
+		}
+	}
+	if len(refs) == 1 && refs[0] == "" {
+		return refs, nil
+	}
+
+	var images []string
+	for _, ref := range refs {
+		switch {
+		case ref == "":
+			progress.Warnf(reporter, "skipping image without a %s annotation", ociRefNameAnnotation)
+		case strings.ContainsAny(ref, "/:@"):
+			images = append(images, ref)
+		default:
+			images = append(images, name+":"+ref)
+		}
+	}
+	return images, nil
+}
+
+// getOCIManifests lists the manifests referenced by index and its nested
+// indexes.
+func getOCIManifests(src source, index *typesV2.ImageIndex, ref string, depth int) ([]ociManifest, error) {
+	if depth > maxIndexDepth {
+		return nil, fmt.Errorf("too many nested image indexes")
+	}
+
+	var manifests []ociManifest
+	for _, d := range index.Manifests {
+		r := ref
+		if name, ok := d.Annotations[ociRefNameAnnotation]; ok {
+			r = name
+		}
+
+		switch d.MediaType {
+		case common.MediaTypeOCIV1Index, common.MediaTypeDockerV22ManifestList:
+			b, err := readBlob(src, d.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("error reading image index: %v", err)
+			}
+			var nested typesV2.ImageIndex
+			if err := json.Unmarshal(b, &nested); err != nil {
+				return nil, fmt.Errorf("error unmarshaling image index %s: %v", d.Digest, err)
+			}
+			m, err := getOCIManifests(src, &nested, r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, m...)
+		case common.MediaTypeOCIV1Manifest, common.MediaTypeDockerV22Manifest:
+			manifests = append(manifests, ociManifest{
+				ref:      r,
+				platform: d.Platform,
+				digest:   d.Digest,
+			})
+		default:
+			// not an image, e.g. a signature or an attestation
+		}
+	}
+
+	return manifests, nil
+}
+
+// selectOCIManifest selects the manifest matching dockerURL, if not nil, and
+// platform, if not empty. Without a platform, the image for the current
+// architecture is preferred.
+func selectOCIManifest(manifests []ociManifest, dockerURL *common.ParsedDockerURL, platform string) (*ociManifest, error) {
+	var candidates []ociManifest
+	if dockerURL != nil {
+		for _, m := range manifests {
+			if matchOCIRef(m.ref, dockerURL) {
+				candidates = append(candidates, m)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("image %q not found", dockerURL.OriginalName)
+		}
+	} else {
+		refs := make(map[string]bool)
+		for _, m := range manifests {
+			refs[m.ref] = true
+		}
+		if len(refs) > 1 {
+			var images []string
+			for r := range refs {
+				images = append(images, r)
+			}
+			sort.Strings(images)
+			return nil, &common.ErrSeveralImages{
+				Msg:    "several images found",
+				Images: images,
+			}
+		}
+		candidates = manifests
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no images found")
+	}
+
+	if platform != "" {
+		p, err := parsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range candidates {
+			if matchPlatform(m.platform, p) {
+				return &m, nil
+			}
+		}
+		return nil, fmt.Errorf("no image found for platform %q", platform)
+	}
+
+	if len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+	current := &typesV2.ImagePlatform{OS: "linux", Architecture: runtime.GOARCH}
+	for _, m := range candidates {
+		if matchPlatform(m.platform, current) {
+			return &m, nil
+		}
+	}
+	var platforms []string
+	for _, m := range candidates {
+		platforms = append(platforms, formatPlatform(m.platform))
+	}
+	return nil, &common.ErrSeveralPlatforms{
+		Msg:       "several platforms found",
+		Platforms: platforms,
+	}
+}
+
+// matchOCIRef returns whether a ref name designates the image in dockerURL.
+// Ref names are either full image references or just tags.
+func matchOCIRef(ref string, dockerURL *common.ParsedDockerURL) bool {
+	if ref == "" {
+		return false
+	}
+	if !strings.ContainsAny(ref, "/:@") {
+		return ref == dockerURL.Tag
+	}
+	parsed, err := common.ParseDockerURL(ref)
+	if err != nil {
+		return false
+	}
+	return parsed.IndexURL == dockerURL.IndexURL &&
+		parsed.ImageName == dockerURL.ImageName &&
+		parsed.Tag == dockerURL.Tag &&
+		parsed.Digest == dockerURL.Digest
+}
+
+// ociDockerURL returns the docker URL of an image named ref in an OCI image
+// layout. Ref names that are just tags are relative to the layout's name.
+func ociDockerURL(ref, name string) *common.ParsedDockerURL {
+	if strings.ContainsAny(ref, "/:@") {
+		if parsed, err := common.ParseDockerURL(ref); err == nil {
+			return parsed
+		}
+	}
+	tag := ref
+	if tag == "" {
+		tag = "latest"
+	}
+	return &common.ParsedDockerURL{
+		IndexURL:  "",
+		Tag:       tag,
+		ImageName: name,
+	}
+}
+
+func parsePlatform(platform string) (*typesV2.ImagePlatform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+	p := &typesV2.ImagePlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// matchPlatform returns whether platform matches wanted. The variant is only
+// compared if wanted has one.
+func matchPlatform(platform, wanted *typesV2.ImagePlatform) bool {
+	if platform == nil {
+		return false
+	}
+	return platform.OS == wanted.OS &&
+		platform.Architecture == wanted.Architecture &&
+		(wanted.Variant == "" || platform.Variant == wanted.Variant)
+}
+
+func formatPlatform(platform *typesV2.ImagePlatform) string {
+	if platform == nil {
+		return "unknown"
+	}
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	return s
+}
+
+// readBlob reads a blob of an OCI image layout and verifies its digest.
+func readBlob(src source, digest string) ([]byte, error) {
+	p, err := blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readFile(src, p)
+	if err != nil {
+		return nil, err
+	}
+	verifier := godigest.Digest(digest).Verifier()
+	verifier.Write(b)
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("blob %s doesn't match its digest", digest)
+	}
+	return b, nil
+}
@@ -0,0 +1,386 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks limits the symlinks followed when looking up a file in a
+// tarball.
+const maxSymlinks = 8
+
+// maxMemEntry is the size above which the files of a saved image read from a
+// stream are spooled to disk rather than kept in memory.
+const maxMemEntry = 1 << 20
+
+// source gives access to the files of a saved image, be it a tarball or an
+// unpacked directory. Paths are slash-separated and relative to the root of
+// the saved image.
+type source interface {
+	// Name returns the name of the tarball or directory.
+	Name() string
+	// Open opens a file. The error satisfies os.IsNotExist if there's no
+	// such file.
+	Open(path string) (sourceFile, error)
+	// Size returns the size of a file.
+	Size(path string) (int64, error)
+	// Close releases the resources used by the source, but not the file
+	// it was created from.
+	Close() error
+}
+
+// sourceFile is a file opened in a source.
+type sourceFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// newSource returns a source for a saved image in file. Compressed tarballs
+// and tarballs that can't be seeked, like pipes, are read once and their files
+// that don't fit in memory are spooled to a temporary directory in tmpDir.
+func newSource(file *os.File, tmpDir string) (source, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirSource{root: file.Name()}, nil
+	}
+	if _, err := file.Seek(0, io.SeekCurrent); err != nil || !fi.Mode().IsRegular() {
+		return newStreamSource(file.Name(), file, tmpDir)
+	}
+
+	header := make([]byte, maxMagicLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking file: %v", err)
+	}
+	if detectCompression(header[:n]) != noCompression {
+		return newStreamSource(file.Name(), file, tmpDir)
+	}
+	return newTarSource(file)
+}
+
+func notFound(p string) error {
+	return &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+}
+
+// cleanPath cleans p and makes sure it doesn't point outside of the saved
+// image.
+func cleanPath(p string) (string, error) {
+	clean := path.Clean(p)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return clean, nil
+}
+
+// tarIndex maps the clean paths of the entries of a tarball to their headers,
+// following symlinks, as "docker save" links identical layers together.
+type tarIndex map[string]*tar.Header
+
+// lookup returns the header of the regular file at p.
+func (idx tarIndex) lookup(p string) (*tar.Header, string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := 0; i <= maxSymlinks; i++ {
+		hdr, ok := idx[clean]
+		if !ok {
+			return nil, "", notFound(p)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			return hdr, clean, nil
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(clean), target)
+			}
+			if clean, err = cleanPath(strings.TrimPrefix(target, "/")); err != nil {
+				return nil, "", err
+			}
+		case tar.TypeLink:
+			if clean, err = cleanPath(hdr.Linkname); err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", fmt.Errorf("%q is not a regular file", p)
+		}
+	}
+	return nil, "", fmt.Errorf("too many levels of symbolic links in %q", p)
+}
+
+// add records hdr, unless it's a directory or an entry that can't be read
+// directly.
+func (idx tarIndex) add(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return false
+	}
+	clean, err := cleanPath(hdr.Name)
+	if err != nil {
+		return false
+	}
+	idx[clean] = hdr
+	return true
+}
+
+// tarSource is a saved image in a tarball, indexed in a single pass so that
+// each file can then be read directly.
+type tarSource struct {
+	file    *os.File
+	index   tarIndex
+	offsets map[string]int64
+}
+
+func newTarSource(file *os.File) (*tarSource, error) {
+	s := &tarSource{
+		file:    file,
+		index:   make(tarIndex),
+		offsets: make(map[string]int64),
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking file: %v", err)
+	}
+	// tar.Reader doesn't read ahead, so the file offset after reading a
+	// header is the offset of its data. It seeks over the data of the
+	// entries.
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar entry: %v", err)
+		}
+		if !s.index.add(hdr) {
+			continue
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("error seeking file: %v", err)
+		}
+		s.offsets[path.Clean(hdr.Name)] = offset
+	}
+	return s, nil
+}
+
+func (s *tarSource) Name() string {
+	return s.file.Name()
+}
+
+func (s *tarSource) Open(p string) (sourceFile, error) {
+	hdr, clean, err := s.index.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{io.NewSectionReader(s.file, s.offsets[clean], hdr.Size)}, nil
+}
+
+func (s *tarSource) Size(p string) (int64, error) {
+	hdr, _, err := s.index.lookup(p)
+	if err != nil {
+		return 0, err
+	}
+	return hdr.Size, nil
+}
+
+func (s *tarSource) Close() error {
+	return nil
+}
+
+// streamSource is a saved image read from a tarball that can't be seeked,
+// possibly compressed. Small files are kept in memory and the others, mostly layers, are spooled
+// to a temporary directory as the tarball is read, in a single pass.
+type streamSource struct {
+	name     string
+	index    tarIndex
+	data     map[string][]byte
+	spoolDir string
+	spooled  map[string]string
+}
+
+func newStreamSource(name string, r io.Reader, tmpDir string) (*streamSource, error) {
+	s := &streamSource{
+		name:    name,
+		index:   make(tarIndex),
+		data:    make(map[string][]byte),
+		spooled: make(map[string]string),
+	}
+	if err := s.read(r, tmpDir); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *streamSource) read(r io.Reader, tmpDir string) error {
+	dr, _, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar entry: %v", err)
+		}
+		if !s.index.add(hdr) || hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+		clean := path.Clean(hdr.Name)
+
+		if hdr.Size <= maxMemEntry {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("error reading %q: %v", hdr.Name, err)
+			}
+			s.data[clean] = b
+			delete(s.spooled, clean)
+			continue
+		}
+
+		if s.spoolDir == "" {
+			if s.spoolDir, err = ioutil.TempDir(tmpDir, "docker2aci-"); err != nil {
+				return fmt.Errorf("error creating dir: %v", err)
+			}
+		}
+		if err := s.spool(clean, tr); err != nil {
+			return fmt.Errorf("error spooling %q: %v", hdr.Name, err)
+		}
+	}
+}
+
+func (s *streamSource) spool(clean string, r io.Reader) error {
+	f, err := ioutil.TempFile(s.spoolDir, "entry-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s.spooled[clean] = f.Name()
+	delete(s.data, clean)
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *streamSource) Name() string {
+	return s.name
+}
+
+func (s *streamSource) Open(p string) (sourceFile, error) {
+	_, clean, err := s.index.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := s.data[clean]; ok {
+		return nopCloser{bytes.NewReader(b)}, nil
+	}
+	return os.Open(s.spooled[clean])
+}
+
+func (s *streamSource) Size(p string) (int64, error) {
+	hdr, _, err := s.index.lookup(p)
+	if err != nil {
+		return 0, err
+	}
+	return hdr.Size, nil
+}
+
+func (s *streamSource) Close() error {
+	if s.spoolDir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.spoolDir)
+}
+
+// dirSource is a saved image unpacked in a directory.
+type dirSource struct {
+	root string
+}
+
+func (s *dirSource) Name() string {
+	return s.root
+}
+
+func (s *dirSource) path(p string) (string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+func (s *dirSource) Open(p string) (sourceFile, error) {
+	fp, err := s.path(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fp)
+}
+
+func (s *dirSource) Size(p string) (int64, error) {
+	fp, err := s.path(p)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+// readFile reads a whole file of a saved image.
+func readFile(src source, p string) ([]byte, error) {
+	r, err := src.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// genSavedImage returns a tarball with a small file, a file spooled to disk
+// when streamed, and links to them.
+func genSavedImage(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr  tar.Header
+		data []byte
+	}{
+		{tar.Header{Name: "layer1/", Typeflag: tar.TypeDir, Mode: 0755}, nil},
+		{tar.Header{Name: "layer1/json", Typeflag: tar.TypeReg, Mode: 0644}, []byte(`{"id":"layer1"}`)},
+		{tar.Header{Name: "./layer1/layer.tar", Typeflag: tar.TypeReg, Mode: 0644}, bytes.Repeat([]byte("x"), maxMemEntry+1)},
+		{tar.Header{Name: "layer2/", Typeflag: tar.TypeDir, Mode: 0755}, nil},
+		{tar.Header{Name: "layer2/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../layer1/layer.tar"}, nil},
+		{tar.Header{Name: "layer2/json", Typeflag: tar.TypeLink, Linkname: "layer1/json"}, nil},
+		{tar.Header{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"}, nil},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return buf.Bytes()
+}
+
+func testSource(t *testing.T, src source) {
+	tests := []struct {
+		path string
+		size int64
+	}{
+		{"layer1/json", 15},
+		{"layer2/json", 15},
+		{"layer1/layer.tar", maxMemEntry + 1},
+		{"layer2/layer.tar", maxMemEntry + 1},
+	}
+	for _, tt := range tests {
+		size, err := src.Size(tt.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if size != tt.size {
+			t.Errorf("%s: expected size %d, got %d", tt.path, tt.size, size)
+		}
+		// read files twice to make sure they're not consumed
+		for i := 0; i < 2; i++ {
+			b, err := readFile(src, tt.path)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.path, err)
+				break
+			}
+			if int64(len(b)) != tt.size {
+				t.Errorf("%s: expected %d bytes, read %d", tt.path, tt.size, len(b))
+			}
+		}
+	}
+
+	if _, err := src.Open("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	for _, p := range []string{"layer1", "loop", "../layer1/json"} {
+		if _, err := src.Open(p); err == nil {
+			t.Errorf("%s: expected an error", p)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	saved := genSavedImage(t)
+	tarPath := filepath.Join(tmpDir, "saved.tar")
+	if err := ioutil.WriteFile(tarPath, saved, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	src, err := newSource(f, tmpDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := src.(*tarSource); !ok {
+		t.Fatalf("expected a tarSource, got %T", src)
+	}
+	testSource(t, src)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(saved)
+		w.Close()
+	}()
+	spoolDir := filepath.Join(tmpDir, "spool")
+	if err := os.Mkdir(spoolDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	src, err = newSource(r, spoolDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := src.(*streamSource); !ok {
+		t.Fatalf("expected a streamSource, got %T", src)
+	}
+	testSource(t, src)
+	if err := src.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if spooled, _ := ioutil.ReadDir(spoolDir); len(spooled) != 0 {
+		t.Errorf("expected spooled files to be removed, found %d", len(spooled))
+	}
+}
@@ -25,9 +25,11 @@ import (
 	"net/url"
 
 	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal"
 	"github.com/appc/docker2aci/lib/internal/typesV2"
 	"github.com/appc/docker2aci/lib/internal/util"
 	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
 	"github.com/appc/spec/schema"
 )
 
@@ -59,6 +61,7 @@ type RepositoryBackend struct {
 	username          string
 	password          string
 	insecure          common.InsecureConfig
+	trust             common.TrustConfig
 	hostsV1fallback   bool
 	hostsV2Support    map[string]bool
 	hostsV2AuthTokens map[string]map[string]string
@@ -66,28 +69,33 @@ type RepositoryBackend struct {
 	imageManifests    map[common.ParsedDockerURL]v2Manifest
 	imageV2Manifests  map[common.ParsedDockerURL]*typesV2.ImageManifest
 	imageConfigs      map[common.ParsedDockerURL]*typesV2.ImageConfig
+	trustedLengths    map[string]int64 // of the manifests, by trusted digest
 	layersIndex       map[string]int
 	mediaTypes        common.MediaTypeSet
 	registryOptions   common.RegistryOptionSet
 
-	debug log.Logger
+	debug    log.Logger
+	progress progress.Reporter
 }
 
-func NewRepositoryBackend(username, password string, insecure common.InsecureConfig, debug log.Logger, mediaTypes common.MediaTypeSet, registryOptions common.RegistryOptionSet) *RepositoryBackend {
+func NewRepositoryBackend(username, password string, insecure common.InsecureConfig, trust common.TrustConfig, debug log.Logger, progress progress.Reporter, mediaTypes common.MediaTypeSet, registryOptions common.RegistryOptionSet) *RepositoryBackend {
 	return &RepositoryBackend{
 		username:          username,
 		password:          password,
 		insecure:          insecure,
+		trust:             trust,
 		hostsV1fallback:   false,
 		hostsV2Support:    make(map[string]bool),
 		hostsV2AuthTokens: make(map[string]map[string]string),
 		imageManifests:    make(map[common.ParsedDockerURL]v2Manifest),
 		imageV2Manifests:  make(map[common.ParsedDockerURL]*typesV2.ImageManifest),
 		imageConfigs:      make(map[common.ParsedDockerURL]*typesV2.ImageConfig),
+		trustedLengths:    make(map[string]int64),
 		layersIndex:       make(map[string]int),
 		mediaTypes:        mediaTypes,
 		registryOptions:   registryOptions,
 		debug:             debug,
+		progress:          progress,
 	}
 }
 
@@ -116,6 +124,23 @@ func (rb *RepositoryBackend) GetImageInfo(url string) ([]string, string, *common
 		rb.hostsV2Support[dockerURL.IndexURL] = supportsV2
 	}
 
+	if rb.trust.Enabled {
+		if !supportsV2 || !rb.registryOptions.AllowsV2() {
+			return nil, "", nil, fmt.Errorf("content trust requires a registry supporting API v2")
+		}
+		if err := rb.resolveTrustedDigest(dockerURL); err != nil {
+			return nil, "", nil, fmt.Errorf("content trust: %v", err)
+		}
+		layers, manhash, dockerURL, err := rb.getImageInfoV2(dockerURL)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if manhash != dockerURL.Digest {
+			return nil, "", nil, fmt.Errorf("content trust: manifest digest %s doesn't match trusted digest %s", manhash, dockerURL.Digest)
+		}
+		return layers, manhash, dockerURL, nil
+	}
+
 	// try v2
 	if supportsV2 && rb.registryOptions.AllowsV2() {
 		layers, manhash, dockerURL, err := rb.getImageInfoV2(dockerURL)
@@ -128,6 +153,7 @@ func (rb *RepositoryBackend) GetImageInfo(url string) ([]string, string, *common
 		if !rb.registryOptions.AllowsV1() {
 			return nil, "", nil, err
 		}
+		progress.Warnf(rb.progress, "image %s not found with registry API v2, falling back to v1", dockerURL.OriginalName)
 	}
 
 	if !rb.registryOptions.AllowsV1() {
@@ -149,11 +175,19 @@ func (rb *RepositoryBackend) GetImageInfo(url string) ([]string, string, *common
 	return rb.getImageInfoV1(dockerURL)
 }
 
-func (rb *RepositoryBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (rb *RepositoryBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
+	if rb.hostsV1fallback || !rb.hostsV2Support[dockerURL.IndexURL] {
+		return rb.buildACIV1(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
+	} else {
+		return rb.buildACIV2(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
+	}
+}
+
+func (rb *RepositoryBackend) GetImageMetadata(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
 	if rb.hostsV1fallback || !rb.hostsV2Support[dockerURL.IndexURL] {
-		return rb.buildACIV1(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression)
+		return rb.getImageMetadataV1(layerIDs, manhash, dockerURL)
 	} else {
-		return rb.buildACIV2(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression)
+		return rb.getImageMetadataV2(layerIDs, manhash, dockerURL)
 	}
 }
 
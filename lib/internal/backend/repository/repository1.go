@@ -30,8 +30,8 @@ import (
 	"github.com/appc/docker2aci/lib/internal"
 	"github.com/appc/docker2aci/lib/internal/types"
 	"github.com/appc/docker2aci/lib/internal/util"
+	"github.com/appc/docker2aci/pkg/progress"
 	"github.com/appc/spec/schema"
-	"github.com/coreos/ioprogress"
 )
 
 type RepoData struct {
@@ -62,7 +62,35 @@ func (rb *RepositoryBackend) getImageInfoV1(dockerURL *common.ParsedDockerURL) (
 	return ancestry, appImageID, dockerURL, nil
 }
 
-func (rb *RepositoryBackend) buildACIV1(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (rb *RepositoryBackend) getImageMetadataV1(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	var aciManifest *schema.ImageManifest
+	var layers []common.LayerInfo
+	for i := len(layerIDs) - 1; i >= 0; i-- {
+		if err := common.ValidateLayerId(layerIDs[i]); err != nil {
+			return nil, nil, err
+		}
+		j, size, err := rb.getJsonV1(layerIDs[i], rb.repoData.Endpoints[0], rb.repoData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting image json: %v", err)
+		}
+		layers = append(layers, common.LayerInfo{Digest: layerIDs[i], Size: size})
+
+		if i != 0 {
+			continue
+		}
+		layerData := types.DockerImageData{}
+		if err := json.Unmarshal(j, &layerData); err != nil {
+			return nil, nil, fmt.Errorf("error unmarshaling layer data: %v", err)
+		}
+		aciManifest, err = internal.GenerateManifest(layerData, manhash, dockerURL, rb.debug)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+		}
+	}
+	return aciManifest, layers, nil
+}
+
+func (rb *RepositoryBackend) buildACIV1(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
 	layerFiles := make([]*os.File, len(layerIDs))
 	layerDatas := make([]types.DockerImageData, len(layerIDs))
 
@@ -121,10 +149,12 @@ func (rb *RepositoryBackend) buildACIV1(layerIDs []string, manhash string, docke
 
 	for i := len(layerIDs) - 1; i >= 0; i-- {
 		rb.debug.Println("Generating layer ACI...")
-		aciPath, manifest, err := internal.GenerateACI(i, manhash, layerDatas[i], dockerURL, outputDir, layerFiles[i], curPwl, compression, rb.debug)
+		aciPath, manifest, err := internal.GenerateACI(i, manhash, layerDatas[i], dockerURL, outputDir, layerFiles[i], curPwl, compression, filter, rb.debug)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 		}
+		rb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+
 		aciLayerPaths = append(aciLayerPaths, aciPath)
 		aciManifests = append(aciManifests, manifest)
 		curPwl = manifest.PathWhitelist
@@ -328,25 +358,10 @@ func (rb *RepositoryBackend) getLayerV1(imgID, registry string, repoData *RepoDa
 		}
 	}
 
-	prefix := "Downloading " + imgID[:12]
-	fmtBytesSize := 18
-	barSize := int64(80 - len(prefix) - fmtBytesSize)
-	bar := ioprogress.DrawTextFormatBarForW(barSize, os.Stderr)
-	fmtfunc := func(progress, total int64) string {
-		return fmt.Sprintf(
-			"%s: %s %s",
-			prefix,
-			bar(progress, total),
-			ioprogress.DrawTextFormatBytes(progress, total),
-		)
-	}
-
-	progressReader := &ioprogress.Reader{
-		Reader:       res.Body,
-		Size:         imgSize,
-		DrawFunc:     ioprogress.DrawTerminalf(os.Stderr, fmtfunc),
-		DrawInterval: 500 * time.Millisecond,
+	if imgSize < 0 {
+		imgSize = 0
 	}
+	progressReader := progress.NewReader(res.Body, rb.progress, imgID, imgSize)
 
 	layerFile, err := ioutil.TempFile(tmpDir, "dockerlayer-")
 	if err != nil {
@@ -357,6 +372,7 @@ func (rb *RepositoryBackend) getLayerV1(imgID, registry string, repoData *RepoDa
 	if err != nil {
 		return nil, err
 	}
+	progressReader.Done()
 
 	if err := layerFile.Sync(); err != nil {
 		return nil, err
@@ -33,8 +33,8 @@ import (
 	"github.com/appc/docker2aci/lib/internal/types"
 	"github.com/appc/docker2aci/lib/internal/typesV2"
 	"github.com/appc/docker2aci/lib/internal/util"
+	"github.com/appc/docker2aci/pkg/progress"
 	"github.com/appc/spec/schema"
-	"github.com/coreos/pkg/progressutil"
 	godigest "github.com/opencontainers/go-digest"
 )
 
@@ -64,15 +64,62 @@ func (rb *RepositoryBackend) getImageInfoV2(dockerURL *common.ParsedDockerURL) (
 	return layers, manhash, dockerURL, nil
 }
 
-func (rb *RepositoryBackend) buildACIV2(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (rb *RepositoryBackend) getImageMetadataV2(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	if manifest, ok := rb.imageV2Manifests[*dockerURL]; ok {
+		var layers []common.LayerInfo
+		for _, l := range manifest.Layers {
+			layers = append(layers, common.LayerInfo{
+				Digest:    l.Digest,
+				Size:      int64(l.Size),
+				MediaType: l.MediaType,
+			})
+		}
+		manifests, err := internal.GenerateManifestsV22(dockerURL, manhash, rb.imageConfigs[*dockerURL], manifest.Annotations, layerIDs, rb.debug)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+		}
+		return manifests[len(manifests)-1], layers, nil
+	}
+
+	if len(layerIDs) == 0 {
+		return nil, nil, fmt.Errorf("image has no layers")
+	}
+	manifest := rb.imageManifests[*dockerURL]
+	layerIndex, ok := rb.layersIndex[layerIDs[0]]
+	if !ok || len(manifest.History) <= layerIndex {
+		return nil, nil, fmt.Errorf("history not found for layer %s", layerIDs[0])
+	}
+	layerData := types.DockerImageData{}
+	if err := json.Unmarshal([]byte(manifest.History[layerIndex].V1Compatibility), &layerData); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling layer data: %v", err)
+	}
+	aciManifest, err := internal.GenerateManifest(layerData, manhash, dockerURL, rb.debug)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+	}
+
+	// v2.1 manifests list layers from the top one to the base one and don't
+	// carry their sizes
+	var layers []common.LayerInfo
+	for i := len(layerIDs) - 1; i >= 0; i-- {
+		layers = append(layers, common.LayerInfo{
+			Digest:    layerIDs[i],
+			Size:      -1,
+			MediaType: common.MediaTypeDockerV21ManifestLayer,
+		})
+	}
+	return aciManifest, layers, nil
+}
+
+func (rb *RepositoryBackend) buildACIV2(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
 	_, isVersion22 := rb.imageV2Manifests[*dockerURL]
 	if isVersion22 {
-		return rb.buildACIV22(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression)
+		return rb.buildACIV22(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
 	}
-	return rb.buildACIV21(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression)
+	return rb.buildACIV21(layerIDs, manhash, dockerURL, outputDir, tmpBaseDir, compression, filter)
 }
 
-func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
 	layerFiles := make([]*os.File, len(layerIDs))
 	layerDatas := make([]types.DockerImageData, len(layerIDs))
 
@@ -82,10 +129,7 @@ func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dock
 	}
 	defer os.RemoveAll(tmpParentDir)
 
-	copier := progressutil.NewCopyProgressPrinter()
-
 	var errChannels []chan error
-	closers := make([]io.ReadCloser, len(layerIDs))
 	var wg sync.WaitGroup
 	for i, layerID := range layerIDs {
 		if err := common.ValidateLayerId(layerID); err != nil {
@@ -125,7 +169,7 @@ func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dock
 				return
 			}
 
-			layerFiles[i], closers[i], err = rb.getLayerV2(layerID, dockerURL, tmpDir, copier)
+			layerFiles[i], err = rb.getLayerV2(layerID, dockerURL, tmpDir)
 			if err != nil {
 				errChan <- fmt.Errorf("error getting the remote layer: %v", err)
 				return
@@ -133,17 +177,7 @@ func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dock
 			errChan <- nil
 		}()
 	}
-	// Need to wait for all of the readers to be added to the copier (which happens during rb.getLayerV2)
 	wg.Wait()
-	err = copier.PrintAndWait(os.Stderr, 500*time.Millisecond, nil)
-	if err != nil {
-		return nil, nil, err
-	}
-	for _, closer := range closers {
-		if closer != nil {
-			closer.Close()
-		}
-	}
 	for _, errChan := range errChannels {
 		err := <-errChan
 		if err != nil {
@@ -161,10 +195,12 @@ func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dock
 	var curPwl []string
 	for i := len(layerIDs) - 1; i >= 0; i-- {
 		rb.debug.Println("Generating layer ACI...")
-		aciPath, aciManifest, err := internal.GenerateACI(i, manhash, layerDatas[i], dockerURL, outputDir, layerFiles[i], curPwl, compression, rb.debug)
+		aciPath, aciManifest, err := internal.GenerateACI(i, manhash, layerDatas[i], dockerURL, outputDir, layerFiles[i], curPwl, compression, filter, rb.debug)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 		}
+		rb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+
 		aciLayerPaths = append(aciLayerPaths, aciPath)
 		aciManifests = append(aciManifests, aciManifest)
 		curPwl = aciManifest.PathWhitelist
@@ -176,13 +212,12 @@ func (rb *RepositoryBackend) buildACIV21(layerIDs []string, manhash string, dock
 }
 
 type layer struct {
-	index  int
-	file   *os.File
-	closer io.Closer
-	err    error
+	index int
+	file  *os.File
+	err   error
 }
 
-func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error) {
+func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
 	layerFiles := make([]*os.File, len(layerIDs))
 
 	tmpParentDir, err := ioutil.TempDir(tmpBaseDir, "docker2aci-")
@@ -191,8 +226,6 @@ func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dock
 	}
 	defer os.RemoveAll(tmpParentDir)
 
-	copier := progressutil.NewCopyProgressPrinter()
-
 	resultChan := make(chan layer, len(layerIDs))
 	for i, layerID := range layerIDs {
 		if err := common.ValidateLayerId(layerID); err != nil {
@@ -211,7 +244,7 @@ func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dock
 				return
 			}
 
-			layerFile, closer, err := rb.getLayerV2(layerID, dockerURL, tmpDir, copier)
+			layerFile, err := rb.getLayerV2(layerID, dockerURL, tmpDir)
 			if err != nil {
 				resultChan <- layer{
 					index: i,
@@ -220,19 +253,15 @@ func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dock
 				return
 			}
 			resultChan <- layer{
-				index:  i,
-				file:   layerFile,
-				closer: closer,
-				err:    nil,
+				index: i,
+				file:  layerFile,
+				err:   nil,
 			}
 		}()
 	}
 	var errs []error
 	for i := 0; i < len(layerIDs); i++ {
 		res := <-resultChan
-		if res.closer != nil {
-			defer res.closer.Close()
-		}
 		if res.file != nil {
 			defer res.file.Close()
 		}
@@ -244,10 +273,6 @@ func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dock
 	if len(errs) > 0 {
 		return nil, nil, errs[0]
 	}
-	err = copier.PrintAndWait(os.Stderr, 500*time.Millisecond, nil)
-	if err != nil {
-		return nil, nil, err
-	}
 	for _, layerFile := range layerFiles {
 		err := layerFile.Sync()
 		if err != nil {
@@ -260,19 +285,23 @@ func (rb *RepositoryBackend) buildACIV22(layerIDs []string, manhash string, dock
 	var i int
 	for i = 0; i < len(layerIDs)-1; i++ {
 		rb.debug.Println("Generating layer ACI...")
-		aciPath, aciManifest, err := internal.GenerateACI22LowerLayer(dockerURL, layerIDs[i], outputDir, layerFiles[i], curPwl, compression)
+		aciPath, aciManifest, err := internal.GenerateACI22LowerLayer(dockerURL, layerIDs[i], outputDir, layerFiles[i], curPwl, compression, filter)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 		}
+		rb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+
 		aciLayerPaths = append(aciLayerPaths, aciPath)
 		aciManifests = append(aciManifests, aciManifest)
 		curPwl = aciManifest.PathWhitelist
 	}
 	rb.debug.Println("Generating layer ACI...")
-	aciPath, aciManifest, err := internal.GenerateACI22TopLayer(dockerURL, manhash, rb.imageConfigs[*dockerURL], layerIDs[i], outputDir, layerFiles[i], curPwl, compression, aciManifests, rb.debug)
+	aciPath, aciManifest, err := internal.GenerateACI22TopLayer(dockerURL, manhash, rb.imageConfigs[*dockerURL], rb.imageV2Manifests[*dockerURL].Annotations, layerIDs[i], outputDir, layerFiles[i], curPwl, compression, filter, aciManifests, rb.debug)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error generating ACI: %v", err)
 	}
+	rb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[i], Path: aciPath})
+
 	aciLayerPaths = append(aciLayerPaths, aciPath)
 	aciManifests = append(aciManifests, aciManifest)
 
@@ -305,24 +334,33 @@ func (rb *RepositoryBackend) getManifestV2(dockerURL *common.ParsedDockerURL) ([
 		return nil, "", &httpStatusErr{res.StatusCode, req.URL}
 	}
 
+	var manblob []byte
+	if length, ok := rb.trustedLengths[reference]; ok {
+		// read one byte more than trusted to detect longer manifests
+		manblob, err = ioutil.ReadAll(io.LimitReader(res.Body, length+1))
+		if err == nil && int64(len(manblob)) != length {
+			err = fmt.Errorf("manifest length doesn't match trusted length %d", length)
+		}
+	} else {
+		manblob, err = ioutil.ReadAll(res.Body)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
 	switch res.Header.Get("content-type") {
 	case common.MediaTypeDockerV22Manifest, common.MediaTypeOCIV1Manifest:
-		return rb.getManifestV22(dockerURL, res)
+		return rb.getManifestV22(dockerURL, manblob)
 	case common.MediaTypeDockerV21Manifest:
-		return rb.getManifestV21(dockerURL, res)
+		return rb.getManifestV21(dockerURL, manblob)
 	}
-	return rb.getManifestV21(dockerURL, res)
+	return rb.getManifestV21(dockerURL, manblob)
 }
 
-func (rb *RepositoryBackend) getManifestV21(dockerURL *common.ParsedDockerURL, res *http.Response) ([]string, string, error) {
-	manblob, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, "", err
-	}
-
+func (rb *RepositoryBackend) getManifestV21(dockerURL *common.ParsedDockerURL, manblob []byte) ([]string, string, error) {
 	manifest := &v2Manifest{}
 
-	err = json.Unmarshal(manblob, manifest)
+	err := json.Unmarshal(manblob, manifest)
 	if err != nil {
 		return nil, "", err
 	}
@@ -357,15 +395,10 @@ func (rb *RepositoryBackend) getManifestV21(dockerURL *common.ParsedDockerURL, r
 	return layers, string(manhash), nil
 }
 
-func (rb *RepositoryBackend) getManifestV22(dockerURL *common.ParsedDockerURL, res *http.Response) ([]string, string, error) {
-	manblob, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, "", err
-	}
-
+func (rb *RepositoryBackend) getManifestV22(dockerURL *common.ParsedDockerURL, manblob []byte) ([]string, string, error) {
 	manifest := &typesV2.ImageManifest{}
 
-	err = json.Unmarshal(manblob, manifest)
+	err := json.Unmarshal(manblob, manifest)
 	if err != nil {
 		return nil, "", err
 	}
@@ -467,7 +500,7 @@ func fixManifestLayers(manifest *v2Manifest) error {
 	return nil
 }
 
-func (rb *RepositoryBackend) getLayerV2(layerID string, dockerURL *common.ParsedDockerURL, tmpDir string, copier *progressutil.CopyProgressPrinter) (*os.File, io.ReadCloser, error) {
+func (rb *RepositoryBackend) getLayerV2(layerID string, dockerURL *common.ParsedDockerURL, tmpDir string) (*os.File, error) {
 	var (
 		err error
 		res *http.Response
@@ -475,18 +508,18 @@ func (rb *RepositoryBackend) getLayerV2(layerID string, dockerURL *common.Parsed
 	)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	rb.setBasicAuth(req)
 
 	res, err = rb.makeRequest(req, dockerURL.ImageName, rb.mediaTypes.LayerMediaTypes())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	defer func() {
-		if err != nil && res != nil {
+		if res != nil {
 			res.Body.Close()
 		}
 	}()
@@ -496,46 +529,43 @@ func (rb *RepositoryBackend) getLayerV2(layerID string, dockerURL *common.Parsed
 		if location != "" {
 			req, err = http.NewRequest("GET", location, nil)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			res.Body.Close()
 			res = nil
 			res, err = rb.makeRequest(req, dockerURL.ImageName, rb.mediaTypes.LayerMediaTypes())
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 		}
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, nil, &httpStatusErr{res.StatusCode, req.URL}
+		return nil, &httpStatusErr{res.StatusCode, req.URL}
 	}
 
-	var in io.Reader
-	in = res.Body
-
 	var size int64
 
 	if hdr := res.Header.Get("Content-Length"); hdr != "" {
 		size, err = strconv.ParseInt(hdr, 10, 64)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 
-	name := "Downloading " + layerID[:18]
-
 	layerFile, err := ioutil.TempFile(tmpDir, "dockerlayer-")
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	err = copier.AddCopy(in, name, size, layerFile)
-	if err != nil {
-		return nil, nil, err
+	in := progress.NewReader(res.Body, rb.progress, layerID, size)
+	if _, err := io.Copy(layerFile, in); err != nil {
+		layerFile.Close()
+		return nil, err
 	}
+	in.Done()
 
-	return layerFile, res.Body, nil
+	return layerFile, nil
 }
 
 func (rb *RepositoryBackend) makeRequest(req *http.Request, repo string, acceptHeaders []string) (*http.Response, error) {
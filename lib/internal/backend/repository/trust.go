@@ -0,0 +1,168 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/tuf"
+)
+
+const (
+	defaultNotaryServer = "https://notary.docker.io"
+	defaultGUNPrefix    = "docker.io/"
+)
+
+// resolveTrustedDigest resolves the tag of dockerURL to a digest through the
+// Notary server of the registry and sets dockerURL.Digest so the image is
+// pulled by content, with the length of its manifest. Images already
+// referenced by digest are left as is.
+//
+// The timestamp and snapshot metadata are cached next to the trusted root,
+// as Docker does, for the next resolutions not to accept older ones.
+func (rb *RepositoryBackend) resolveTrustedDigest(dockerURL *common.ParsedDockerURL) error {
+	if dockerURL.Digest != "" {
+		return nil
+	}
+
+	gun := trustGUN(dockerURL)
+	metadataDir := filepath.Join(rb.trust.Dir, "tuf", filepath.FromSlash(gun), "metadata")
+	rootPath := filepath.Join(metadataDir, "root.json")
+	trustedRoot, err := ioutil.ReadFile(rootPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no cached trust data for %q in %s", gun, rb.trust.Dir)
+		}
+		return err
+	}
+
+	server := rb.trust.Server
+	if server == "" {
+		server = trustServer(dockerURL.IndexURL)
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	fetch := func(role string, size int64) ([]byte, error) {
+		return rb.getTrustMetadata(server, gun, role, size, dockerURL.ImageName)
+	}
+
+	client, err := tuf.NewClient(trustedRoot, fetch)
+	if err != nil {
+		return err
+	}
+	for _, role := range []string{tuf.RoleTimestamp, tuf.RoleSnapshot} {
+		trusted, err := ioutil.ReadFile(filepath.Join(metadataDir, role+".json"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := client.TrustMetadata(role, trusted); err != nil {
+			return err
+		}
+	}
+
+	target, err := client.Resolve(dockerURL.Tag)
+	if err != nil {
+		if err == tuf.ErrTargetNotFound {
+			return fmt.Errorf("no trust data for tag %q of %q", dockerURL.Tag, gun)
+		}
+		return err
+	}
+
+	if root, updated := client.Root(); updated {
+		rb.debug.Printf("Updating cached trusted root for %q", gun)
+		if err := ioutil.WriteFile(rootPath, root, 0644); err != nil {
+			return fmt.Errorf("error caching updated root: %v", err)
+		}
+	}
+	for _, role := range []string{tuf.RoleTimestamp, tuf.RoleSnapshot} {
+		if err := ioutil.WriteFile(filepath.Join(metadataDir, role+".json"), client.Metadata(role), 0644); err != nil {
+			return fmt.Errorf("error caching %s: %v", role, err)
+		}
+	}
+
+	digest, err := target.Digest()
+	if err != nil {
+		return err
+	}
+	if target.Length <= 0 {
+		return fmt.Errorf("target of tag %q has no length", dockerURL.Tag)
+	}
+	rb.debug.Printf("Tag %q of %q is trusted with digest %s", dockerURL.Tag, gun, digest)
+	dockerURL.Digest = digest
+	rb.trustedLengths[digest] = target.Length
+
+	return nil
+}
+
+func (rb *RepositoryBackend) getTrustMetadata(server, gun, role string, size int64, repo string) ([]byte, error) {
+	url := fmt.Sprintf("%s/v2/%s/_trust/tuf/%s.json", server, gun, role)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rb.setBasicAuth(req)
+
+	res, err := rb.makeRequest(req, repo, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &httpStatusErr{res.StatusCode, req.URL}
+	}
+
+	limit := size
+	if limit < 0 {
+		limit = tuf.MaxMetadataSize
+	}
+	// read one byte more than allowed to detect oversized metadata
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("metadata larger than %d bytes", limit)
+	}
+
+	return b, nil
+}
+
+// trustGUN returns the Globally Unique Name Notary uses for a repository.
+func trustGUN(dockerURL *common.ParsedDockerURL) string {
+	if dockerURL.IndexURL == defaultIndexURL {
+		return defaultGUNPrefix + dockerURL.ImageName
+	}
+	return dockerURL.IndexURL + "/" + dockerURL.ImageName
+}
+
+// trustServer returns the default Notary server for a registry, following
+// the same convention as Docker.
+func trustServer(indexURL string) string {
+	if indexURL == defaultIndexURL {
+		return defaultNotaryServer
+	}
+	return "https://" + indexURL
+}
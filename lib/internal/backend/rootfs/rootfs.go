@@ -0,0 +1,160 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rootfs is an implementation of Docker2ACIBackend for plain rootfs
+// tarballs, like the ones generated by "docker export", converted as
+// single-layer Docker v2.2 images with a supplied image config.
+//
+// Note: this package is an implementation detail and shouldn't be used outside
+// of docker2aci.
+package rootfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+type RootfsBackend struct {
+	file   *os.File
+	config *typesV2.ImageConfig
+
+	debug    log.Logger
+	progress progress.Reporter
+}
+
+// NewRootfsBackend returns a backend converting the rootfs tarball in file,
+// possibly compressed, with the given image config. The rootfs of config is
+// set to the tarball.
+func NewRootfsBackend(file *os.File, config *typesV2.ImageConfig, debug log.Logger, progress progress.Reporter) *RootfsBackend {
+	return &RootfsBackend{
+		file:     file,
+		config:   config,
+		debug:    debug,
+		progress: progress,
+	}
+}
+
+// GetImageInfo returns the digests of the image config and of the rootfs, as
+// the layers of a Docker v2.2 image. dockerURL names the image; the file name
+// stripped of its extensions is used by default.
+func (rb *RootfsBackend) GetImageInfo(dockerURL string) ([]string, string, *common.ParsedDockerURL, error) {
+	var parsedDockerURL *common.ParsedDockerURL
+	if dockerURL != "" {
+		var err error
+		parsedDockerURL, err = common.ParseDockerURL(dockerURL)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("image provided could not be parsed: %v", err)
+		}
+	} else {
+		parsedDockerURL = &common.ParsedDockerURL{
+			IndexURL:  "",
+			Tag:       "latest",
+			ImageName: strings.Split(filepath.Base(rb.file.Name()), ".")[0],
+		}
+	}
+
+	rb.debug.Println("Computing the digest of the rootfs...")
+	diffID, err := rb.diffID()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error reading rootfs: %v", err)
+	}
+	rb.config.RootFS = &typesV2.ImageConfigRootFS{
+		Type:    "layers",
+		DiffIDs: []string{diffID},
+	}
+
+	configb, err := json.Marshal(rb.config)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error marshaling image config: %v", err)
+	}
+	configID := godigest.FromBytes(configb).String()
+
+	return []string{configID, diffID}, configID, parsedDockerURL, nil
+}
+
+// diffID returns the digest of the uncompressed rootfs tarball.
+func (rb *RootfsBackend) diffID() (string, error) {
+	if _, err := rb.file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	r, err := aci.NewCompressedReader(rb.file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	digester := godigest.Canonical.Digester()
+	if _, err := io.Copy(digester.Hash(), r); err != nil {
+		return "", err
+	}
+	return digester.Digest().String(), nil
+}
+
+func (rb *RootfsBackend) GetImageMetadata(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error) {
+	if len(layerIDs) != 2 {
+		return nil, nil, fmt.Errorf("unexpected layers for a rootfs image")
+	}
+	fi, err := rb.file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifests, err := internal.GenerateManifestsV22(dockerURL, manhash, rb.config, nil, []string{hexDigest(layerIDs[1])}, rb.debug)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating the manifest: %v", err)
+	}
+	layers := []common.LayerInfo{{
+		Digest:    layerIDs[1],
+		Size:      fi.Size(),
+		MediaType: common.MediaTypeDockerSavedLayer,
+	}}
+	return manifests[len(manifests)-1], layers, nil
+}
+
+func (rb *RootfsBackend) BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *internal.PathFilter) ([]string, []*schema.ImageManifest, error) {
+	if len(layerIDs) != 2 {
+		return nil, nil, fmt.Errorf("unexpected layers for a rootfs image")
+	}
+	if _, err := rb.file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("error seeking rootfs: %v", err)
+	}
+
+	rb.debug.Println("Generating layer ACI...")
+	aciPath, manifest, err := internal.GenerateACI22TopLayer(dockerURL, manhash, rb.config, nil, hexDigest(layerIDs[1]), outputDir, rb.file, nil, compression, filter, nil, rb.debug)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating ACI: %v", err)
+	}
+
+	rb.progress.Report(progress.Event{Type: progress.LayerConverted, Time: time.Now(), Layer: layerIDs[1], Path: aciPath})
+
+	return []string{aciPath}, []*schema.ImageManifest{manifest}, nil
+}
+
+func hexDigest(digest string) string {
+	return strings.TrimPrefix(digest, string(godigest.Canonical)+":")
+}
@@ -30,6 +30,7 @@ import (
 const (
 	dockercfgFileName    = "config.json"
 	dockercfgFileNameOld = ".dockercfg"
+	trustDirName         = "trust"
 	defaultIndexURL      = "registry-1.docker.io"
 	defaultIndexURLAuth  = "https://index.docker.io/v1/"
 	defaultRepoPrefix    = "library/"
@@ -84,6 +85,11 @@ func getHomeDir() string {
 	return os.Getenv("HOME")
 }
 
+// TrustDir returns the directory where Docker keeps its content trust data.
+func TrustDir() string {
+	return path.Join(getHomeDir(), ".docker", trustDirName)
+}
+
 // GetDockercfgAuth reads a ~/.dockercfg file and returns the username and password
 // of the given docker index server.
 func GetAuthInfo(indexServer string) (string, string, error) {
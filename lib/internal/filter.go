@@ -0,0 +1,65 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"archive/tar"
+	"sync/atomic"
+
+	"github.com/appc/docker2aci/lib/common"
+)
+
+// PathFilter applies a common.PathFilter to the layers converted by a
+// backend and counts the bytes of the files it leaves out. A nil *PathFilter
+// keeps every file.
+type PathFilter struct {
+	filter  *common.PathFilter
+	removed int64
+}
+
+// NewPathFilter returns a PathFilter applying filter, which may be nil.
+func NewPathFilter(filter *common.PathFilter) *PathFilter {
+	return &PathFilter{filter: filter}
+}
+
+// Excluded says whether the file at the absolute path p, a directory if dir
+// is true, is left out of the converted layers. Excluded directories which
+// may hold included paths are kept.
+func (f *PathFilter) Excluded(p string, dir bool) bool {
+	if f == nil {
+		return false
+	}
+	return f.filter.Excluded(p) && !(dir && f.filter.Encloses(p))
+}
+
+// Removed returns the bytes of the files left out so far.
+func (f *PathFilter) Removed() int64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&f.removed)
+}
+
+func (f *PathFilter) active() bool {
+	return f != nil && !f.filter.Empty()
+}
+
+// remove counts the contents of the file of hdr as left out.
+func (f *PathFilter) remove(hdr *tar.Header) {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		atomic.AddInt64(&f.removed, hdr.Size)
+	}
+}
@@ -36,7 +36,6 @@ import (
 	"github.com/appc/docker2aci/lib/internal/tarball"
 	"github.com/appc/docker2aci/lib/internal/types"
 	"github.com/appc/docker2aci/lib/internal/typesV2"
-	"github.com/appc/docker2aci/lib/internal/util"
 	"github.com/appc/docker2aci/pkg/log"
 	"github.com/appc/spec/aci"
 	"github.com/appc/spec/schema"
@@ -60,11 +59,16 @@ type Docker2ACIBackend interface {
 	// - *common.ParsedDockerURL: a parsed docker URL
 	// - error: an error if one occurred
 	GetImageInfo(dockerUrl string) ([]string, string, *common.ParsedDockerURL, error)
-	BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression) ([]string, []*schema.ImageManifest, error)
+	// GetImageMetadata takes the output of GetImageInfo and returns, without
+	// fetching any layer, the ImageManifest BuildACI would generate for the
+	// top layer and information about every layer, ordered from the base
+	// layer to the top one.
+	GetImageMetadata(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL) (*schema.ImageManifest, []common.LayerInfo, error)
+	BuildACI(layerIDs []string, manhash string, dockerURL *common.ParsedDockerURL, outputDir string, tmpBaseDir string, compression common.Compression, filter *PathFilter) ([]string, []*schema.ImageManifest, error)
 }
 
 // GenerateACI takes a Docker layer and generates an ACI from it.
-func GenerateACI(layerNumber int, manhash string, layerData types.DockerImageData, dockerURL *common.ParsedDockerURL, outputDir string, layerFile *os.File, curPwl []string, compression common.Compression, debug log.Logger) (string, *schema.ImageManifest, error) {
+func GenerateACI(layerNumber int, manhash string, layerData types.DockerImageData, dockerURL *common.ParsedDockerURL, outputDir string, layerFile io.ReadSeeker, curPwl []string, compression common.Compression, filter *PathFilter, debug log.Logger) (string, *schema.ImageManifest, error) {
 	manifest, err := GenerateManifest(layerData, manhash, dockerURL, debug)
 	if err != nil {
 		return "", nil, fmt.Errorf("error generating the manifest: %v", err)
@@ -73,7 +77,7 @@ func GenerateACI(layerNumber int, manhash string, layerData types.DockerImageDat
 	imageName := strings.Replace(dockerURL.ImageName, "/", "-", -1)
 	aciPath := generateACIPath(outputDir, imageName, layerData.ID, dockerURL.Tag, layerData.OS, layerData.Architecture, layerNumber)
 
-	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression)
+	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression, filter)
 	if err != nil {
 		return "", nil, fmt.Errorf("error writing ACI: %v", err)
 	}
@@ -85,20 +89,16 @@ func GenerateACI(layerNumber int, manhash string, layerData types.DockerImageDat
 	return aciPath, manifest, nil
 }
 
-func GenerateACI22LowerLayer(dockerURL *common.ParsedDockerURL, layerDigest string, outputDir string, layerFile *os.File, curPwl []string, compression common.Compression) (string, *schema.ImageManifest, error) {
+func GenerateACI22LowerLayer(dockerURL *common.ParsedDockerURL, layerDigest string, outputDir string, layerFile io.ReadSeeker, curPwl []string, compression common.Compression, filter *PathFilter) (string, *schema.ImageManifest, error) {
 	formattedDigest := strings.Replace(layerDigest, ":", "-", -1)
 	aciName := fmt.Sprintf("%s/%s-%s", dockerURL.IndexURL, dockerURL.ImageName, formattedDigest)
-	sanitizedAciName, err := appctypes.SanitizeACIdentifier(aciName)
-	if err != nil {
-		return "", nil, err
-	}
-	manifest, err := GenerateEmptyManifest(sanitizedAciName)
+	manifest, err := GenerateLowerLayerManifestV22(dockerURL, layerDigest)
 	if err != nil {
 		return "", nil, err
 	}
 
 	aciPath := generateACIPath(outputDir, aciName, layerDigest, dockerURL.Tag, runtime.GOOS, runtime.GOARCH, -1)
-	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression)
+	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression, filter)
 	if err != nil {
 		return "", nil, err
 	}
@@ -110,19 +110,15 @@ func GenerateACI22LowerLayer(dockerURL *common.ParsedDockerURL, layerDigest stri
 	return aciPath, manifest, nil
 }
 
-func GenerateACI22TopLayer(dockerURL *common.ParsedDockerURL, manhash string, imageConfig *typesV2.ImageConfig, layerDigest string, outputDir string, layerFile *os.File, curPwl []string, compression common.Compression, lowerLayers []*schema.ImageManifest, debug log.Logger) (string, *schema.ImageManifest, error) {
+func GenerateACI22TopLayer(dockerURL *common.ParsedDockerURL, manhash string, imageConfig *typesV2.ImageConfig, imageAnnotations map[string]string, layerDigest string, outputDir string, layerFile io.ReadSeeker, curPwl []string, compression common.Compression, filter *PathFilter, lowerLayers []*schema.ImageManifest, debug log.Logger) (string, *schema.ImageManifest, error) {
 	aciName := fmt.Sprintf("%s/%s-%s", dockerURL.IndexURL, dockerURL.ImageName, layerDigest)
-	sanitizedAciName, err := appctypes.SanitizeACIdentifier(aciName)
-	if err != nil {
-		return "", nil, err
-	}
-	manifest, err := GenerateManifestV22(sanitizedAciName, manhash, layerDigest, dockerURL, imageConfig, lowerLayers, debug)
+	manifest, err := GenerateTopLayerManifestV22(dockerURL, manhash, imageConfig, imageAnnotations, layerDigest, lowerLayers, debug)
 	if err != nil {
 		return "", nil, err
 	}
 
 	aciPath := generateACIPath(outputDir, aciName, layerDigest, dockerURL.Tag, runtime.GOOS, runtime.GOARCH, -1)
-	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression)
+	manifest, err = writeACI(layerFile, *manifest, curPwl, aciPath, compression, filter)
 	if err != nil {
 		return "", nil, err
 	}
@@ -134,6 +130,52 @@ func GenerateACI22TopLayer(dockerURL *common.ParsedDockerURL, manhash string, im
 	return aciPath, manifest, nil
 }
 
+// GenerateLowerLayerManifestV22 generates the ImageManifest of a layer of a
+// Docker v2.2 image other than the top one.
+func GenerateLowerLayerManifestV22(dockerURL *common.ParsedDockerURL, layerDigest string) (*schema.ImageManifest, error) {
+	formattedDigest := strings.Replace(layerDigest, ":", "-", -1)
+	aciName := fmt.Sprintf("%s/%s-%s", dockerURL.IndexURL, dockerURL.ImageName, formattedDigest)
+	sanitizedAciName, err := appctypes.SanitizeACIdentifier(aciName)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateEmptyManifest(sanitizedAciName)
+}
+
+// GenerateTopLayerManifestV22 generates the ImageManifest of the top layer of
+// a Docker v2.2 image, which carries the image configuration and the
+// annotations of the image manifest.
+func GenerateTopLayerManifestV22(dockerURL *common.ParsedDockerURL, manhash string, imageConfig *typesV2.ImageConfig, imageAnnotations map[string]string, layerDigest string, lowerLayers []*schema.ImageManifest, debug log.Logger) (*schema.ImageManifest, error) {
+	aciName := fmt.Sprintf("%s/%s-%s", dockerURL.IndexURL, dockerURL.ImageName, layerDigest)
+	sanitizedAciName, err := appctypes.SanitizeACIdentifier(aciName)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateManifestV22(sanitizedAciName, manhash, layerDigest, dockerURL, imageConfig, imageAnnotations, lowerLayers, debug)
+}
+
+// GenerateManifestsV22 generates the ImageManifests of all the layers of a
+// Docker v2.2 image, ordered from the base layer to the top one, as
+// GenerateACI22LowerLayer and GenerateACI22TopLayer would.
+func GenerateManifestsV22(dockerURL *common.ParsedDockerURL, manhash string, imageConfig *typesV2.ImageConfig, imageAnnotations map[string]string, layerDigests []string, debug log.Logger) ([]*schema.ImageManifest, error) {
+	if len(layerDigests) == 0 {
+		return nil, fmt.Errorf("image has no layers")
+	}
+	var manifests []*schema.ImageManifest
+	for _, d := range layerDigests[:len(layerDigests)-1] {
+		m, err := GenerateLowerLayerManifestV22(dockerURL, d)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	top, err := GenerateTopLayerManifestV22(dockerURL, manhash, imageConfig, imageAnnotations, layerDigests[len(layerDigests)-1], manifests, debug)
+	if err != nil {
+		return nil, err
+	}
+	return append(manifests, top), nil
+}
+
 func generateACIPath(outputDir, imageName, digest, tag, osString, arch string, layerNum int) string {
 	aciPath := imageName
 	if tag != "" {
@@ -208,6 +250,104 @@ func setAnnotation(annotations *appctypes.Annotations, key, val string) {
 	}
 }
 
+// setResourceAnnotations keeps the resource settings of an image, where zero
+// means unset. They are turned into isolators, or not, once the image is
+// converted.
+func setResourceAnnotations(annotations *appctypes.Annotations, memory, memorySwap, cpuShares int64, cpuset string) {
+	if memory != 0 {
+		setAnnotation(annotations, common.AppcDockerMemory, strconv.FormatInt(memory, 10))
+	}
+	if memorySwap != 0 {
+		setAnnotation(annotations, common.AppcDockerMemorySwap, strconv.FormatInt(memorySwap, 10))
+	}
+	if cpuShares != 0 {
+		setAnnotation(annotations, common.AppcDockerCPUShares, strconv.FormatInt(cpuShares, 10))
+	}
+	setAnnotation(annotations, common.AppcDockerCpuset, cpuset)
+}
+
+// setMetadataAnnotations keeps the settings of an image that appc has no
+// equivalent for, in the formats documented with their annotations.
+func setMetadataAnnotations(annotations *appctypes.Annotations, healthcheck *common.Healthcheck, stopSignal string, shell, onBuild []string) error {
+	if healthcheck != nil {
+		b, err := json.Marshal(healthcheck)
+		if err != nil {
+			return err
+		}
+		setAnnotation(annotations, common.AppcDockerHealthcheck, string(b))
+	}
+	setAnnotation(annotations, common.AppcDockerStopSignal, stopSignal)
+	if len(shell) > 0 {
+		b, err := json.Marshal(shell)
+		if err != nil {
+			return err
+		}
+		setAnnotation(annotations, common.AppcDockerShell, string(b))
+	}
+	if len(onBuild) > 0 {
+		b, err := json.Marshal(onBuild)
+		if err != nil {
+			return err
+		}
+		setAnnotation(annotations, common.AppcDockerOnBuild, string(b))
+	}
+	return nil
+}
+
+func newHealthcheck(test []string, interval, timeout, startPeriod time.Duration, retries int) *common.Healthcheck {
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return &common.Healthcheck{
+		Test:        test,
+		Interval:    duration(interval),
+		Timeout:     duration(timeout),
+		StartPeriod: duration(startPeriod),
+		Retries:     retries,
+	}
+}
+
+// annotations predefined by the OCI image spec
+const (
+	ociAnnotationPrefix  = "org.opencontainers.image."
+	ociVersionAnnotation = "org.opencontainers.image.version"
+)
+
+// ociWellKnownAnnotations maps OCI annotations to the well-known appc
+// annotations with the same meaning.
+var ociWellKnownAnnotations = []struct{ oci, appc string }{
+	{"org.opencontainers.image.authors", "authors"},
+	{"org.opencontainers.image.created", "created"},
+	{"org.opencontainers.image.documentation", "documentation"},
+	{"org.opencontainers.image.url", "homepage"},
+}
+
+// setOCIAnnotations copies the OCI annotations of an image manifest, like its
+// source and revision, and sets the well-known appc annotations they match
+// unless the image config already did. Other annotations are left out.
+func setOCIAnnotations(annotations *appctypes.Annotations, imageAnnotations map[string]string) {
+	var names []string
+	for name := range imageAnnotations {
+		if strings.HasPrefix(name, ociAnnotationPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := appctypes.NewACIdentifier(name); err == nil {
+			setAnnotation(annotations, name, imageAnnotations[name])
+		}
+	}
+	for _, a := range ociWellKnownAnnotations {
+		if _, ok := annotations.Get(a.appc); !ok {
+			setAnnotation(annotations, a.appc, imageAnnotations[a.oci])
+		}
+	}
+}
+
 // GenerateManifest converts the docker manifest format to an appc
 // ImageManifest.
 func GenerateManifest(layerData types.DockerImageData, manhash string, dockerURL *common.ParsedDockerURL, debug log.Logger) (*schema.ImageManifest, error) {
@@ -296,6 +436,16 @@ func GenerateManifest(layerData types.DockerImageData, manhash string, dockerURL
 		if len(cmd) > 0 {
 			setAnnotation(&annotations, common.AppcDockerCmd, cmd)
 		}
+		setAnnotation(&annotations, common.AppcDockerUser, dockerConfig.User)
+		setResourceAnnotations(&annotations, dockerConfig.Memory, dockerConfig.MemorySwap, dockerConfig.CpuShares, dockerConfig.Cpuset)
+
+		var healthcheck *common.Healthcheck
+		if h := dockerConfig.Healthcheck; h != nil {
+			healthcheck = newHealthcheck(h.Test, h.Interval, h.Timeout, h.StartPeriod, h.Retries)
+		}
+		if err := setMetadataAnnotations(&annotations, healthcheck, dockerConfig.StopSignal, dockerConfig.Shell, dockerConfig.OnBuild); err != nil {
+			return nil, err
+		}
 
 		genManifest.App = app
 	}
@@ -311,7 +461,7 @@ func GenerateManifest(layerData types.DockerImageData, manhash string, dockerURL
 		}
 		parentImageName := appctypes.MustACIdentifier(parentImageNameString)
 
-		plbl, err := appctypes.LabelsFromMap(labels)
+		plbl, err := labelsFromMap(labels)
 		if err != nil {
 			return nil, err
 		}
@@ -321,7 +471,7 @@ func GenerateManifest(layerData types.DockerImageData, manhash string, dockerURL
 		setAnnotation(&annotations, common.AppcDockerTag, dockerURL.Tag)
 	}
 
-	genManifest.Labels, err = appctypes.LabelsFromMap(labels)
+	genManifest.Labels, err = labelsFromMap(labels)
 	if err != nil {
 		return nil, err
 	}
@@ -330,6 +480,17 @@ func GenerateManifest(layerData types.DockerImageData, manhash string, dockerURL
 	return genManifest, nil
 }
 
+// labelsFromMap returns the labels of m sorted by name, for the manifests to
+// be the same from a conversion to the next.
+func labelsFromMap(m map[appctypes.ACIdentifier]string) (appctypes.Labels, error) {
+	labels, err := appctypes.LabelsFromMap(m)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(appcLabelSorter(labels))
+	return labels, nil
+}
+
 func GenerateEmptyManifest(name string) (*schema.ImageManifest, error) {
 	acid, err := appctypes.NewACIdentifier(name)
 	if err != nil {
@@ -342,7 +503,7 @@ func GenerateEmptyManifest(name string) (*schema.ImageManifest, error) {
 		return nil, err
 	}
 
-	labels, err := appctypes.LabelsFromMap(labelsMap)
+	labels, err := labelsFromMap(labelsMap)
 	if err != nil {
 		return nil, err
 	}
@@ -364,6 +525,7 @@ func GenerateManifestV22(
 	imageDigest string, // The digest of the image
 	dockerURL *common.ParsedDockerURL, // The parsed docker URL
 	config *typesV2.ImageConfig, // The image config
+	imageAnnotations map[string]string, // The annotations of the image manifest
 	lowerLayers []*schema.ImageManifest, // A list of manifests for the lower layers
 	debug log.Logger, // The debug logger, for logging debug information
 ) (*schema.ImageManifest, error) {
@@ -376,6 +538,10 @@ func GenerateManifestV22(
 	annotations := manifest.Annotations
 
 	setLabel(labels, "version", dockerURL.Tag)
+	if dockerURL.Tag == "" {
+		// images referenced by digest
+		setLabel(labels, "version", imageAnnotations[ociVersionAnnotation])
+	}
 	setOSArch(labels, config.OS, config.Architecture)
 
 	setAnnotation(&annotations, "author", config.Author)
@@ -387,6 +553,7 @@ func GenerateManifestV22(
 	setAnnotation(&annotations, common.AppcDockerImageID, imageDigest)
 	setAnnotation(&annotations, "created", config.Created)
 	setAnnotation(&annotations, common.AppcDockerManifestHash, manhash)
+	setOCIAnnotations(&annotations, imageAnnotations)
 
 	if config.Config != nil {
 		innerCfg := config.Config
@@ -405,6 +572,7 @@ func GenerateManifestV22(
 			Group:            group,
 			Environment:      env,
 			WorkingDirectory: innerCfg.WorkingDir,
+			UserLabels:       innerCfg.Labels,
 		}
 		manifest.App.MountPoints, err = convertVolumesToMPs(innerCfg.Volumes)
 		if err != nil {
@@ -425,6 +593,16 @@ func GenerateManifestV22(
 		if len(cmd) > 0 {
 			setAnnotation(&annotations, common.AppcDockerCmd, cmd)
 		}
+		setAnnotation(&annotations, common.AppcDockerUser, innerCfg.User)
+		setResourceAnnotations(&annotations, int64(innerCfg.Memory), int64(innerCfg.MemorySwap), int64(innerCfg.CpuShares), innerCfg.Cpuset)
+
+		var healthcheck *common.Healthcheck
+		if h := innerCfg.Healthcheck; h != nil {
+			healthcheck = newHealthcheck(h.Test, h.Interval, h.Timeout, h.StartPeriod, h.Retries)
+		}
+		if err := setMetadataAnnotations(&annotations, healthcheck, innerCfg.StopSignal, innerCfg.Shell, innerCfg.OnBuild); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, lowerLayer := range lowerLayers {
@@ -434,7 +612,7 @@ func GenerateManifestV22(
 		})
 	}
 
-	manifest.Labels, err = appctypes.LabelsFromMap(labels)
+	manifest.Labels, err = labelsFromMap(labels)
 	if err != nil {
 		return nil, err
 	}
@@ -463,6 +641,20 @@ func ValidateACI(aciPath string) error {
 	return nil
 }
 
+type appcLabelSorter []appctypes.Label
+
+func (s appcLabelSorter) Len() int {
+	return len(s)
+}
+
+func (s appcLabelSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s appcLabelSorter) Less(i, j int) bool {
+	return s[i].Name.String() < s[j].Name.String()
+}
+
 type appcPortSorter []appctypes.Port
 
 func (s appcPortSorter) Len() int {
@@ -479,23 +671,33 @@ func (s appcPortSorter) Less(i, j int) bool {
 
 func convertPorts(dockerExposedPorts map[string]struct{}, dockerPortSpecs []string, debug log.Logger) ([]appctypes.Port, error) {
 	ports := []appctypes.Port{}
-
-	for ep := range dockerExposedPorts {
+	seen := make(map[appctypes.ACName]struct{})
+	add := func(ep string) error {
 		appcPort, err := parseDockerPort(ep)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		// "80" and "80/tcp" are the same port
+		if _, ok := seen[appcPort.Name]; ok {
+			return nil
 		}
+		seen[appcPort.Name] = struct{}{}
 		ports = append(ports, *appcPort)
+		return nil
+	}
+
+	for ep := range dockerExposedPorts {
+		if err := add(ep); err != nil {
+			return nil, err
+		}
 	}
 
 	if dockerExposedPorts == nil && dockerPortSpecs != nil {
 		debug.Println("warning: docker image uses deprecated PortSpecs field")
 		for _, ep := range dockerPortSpecs {
-			appcPort, err := parseDockerPort(ep)
-			if err != nil {
+			if err := add(ep); err != nil {
 				return nil, err
 			}
-			ports = append(ports, *appcPort)
 		}
 	}
 
@@ -504,31 +706,56 @@ func convertPorts(dockerExposedPorts map[string]struct{}, dockerPortSpecs []stri
 	return ports, nil
 }
 
+// parseDockerPort parses an exposed port of the form PORT[-END][/PROTOCOL],
+// like 80, 53/udp or 8000-8010/tcp. The protocol defaults to tcp. The
+// port is named after its normalized form, like 8000-8010-tcp.
 func parseDockerPort(dockerPort string) (*appctypes.Port, error) {
-	var portString string
+	portString := dockerPort
 	proto := "tcp"
-	sp := strings.Split(dockerPort, "/")
-	if len(sp) < 2 {
-		portString = dockerPort
-	} else {
-		proto = sp[1]
-		portString = sp[0]
+	if i := strings.Index(dockerPort, "/"); i != -1 {
+		portString = dockerPort[:i]
+		proto = strings.ToLower(dockerPort[i+1:])
+	}
+	switch proto {
+	case "tcp", "udp", "sctp":
+	default:
+		return nil, fmt.Errorf("error parsing port %q: unknown protocol %q", dockerPort, proto)
 	}
 
-	port, err := strconv.ParseUint(portString, 10, 0)
+	parsePort := func(s string) (uint, error) {
+		port, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || port == 0 {
+			return 0, fmt.Errorf("error parsing port %q: invalid port number %q", dockerPort, s)
+		}
+		return uint(port), nil
+	}
+	start, end := portString, portString
+	if i := strings.Index(portString, "-"); i != -1 {
+		start, end = portString[:i], portString[i+1:]
+	}
+	port, err := parsePort(start)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing port %q: %v", portString, err)
+		return nil, err
 	}
-
-	sn, err := appctypes.SanitizeACName(dockerPort)
+	last, err := parsePort(end)
 	if err != nil {
 		return nil, err
 	}
+	if last < port {
+		return nil, fmt.Errorf("error parsing port %q: range ends before it starts", dockerPort)
+	}
+
+	name := strconv.FormatUint(uint64(port), 10)
+	if last != port {
+		name += "-" + strconv.FormatUint(uint64(last), 10)
+	}
+	name += "-" + proto
 
 	appcPort := &appctypes.Port{
-		Name:     *appctypes.MustACName(sn),
+		Name:     *appctypes.MustACName(name),
 		Protocol: proto,
-		Port:     uint(port),
+		Port:     port,
+		Count:    last - port + 1,
 	}
 
 	return appcPort, nil
@@ -580,7 +807,26 @@ func convertVolumesToMPs(dockerVolumes map[string]struct{}) ([]appctypes.MountPo
 	return mps, nil
 }
 
-func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []string, output string, compression common.Compression) (*schema.ImageManifest, error) {
+// whiteouts of the layers, in the AUFS format of Docker and the OCI image
+// spec, and in the overlayfs format some tools emit
+const (
+	whiteoutPrefix     = ".wh."
+	opaqueWhiteout     = ".wh..wh..opq"
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []string, output string, compression common.Compression, filter *PathFilter) (*schema.ImageManifest, error) {
+	// the hard links kept to an excluded file get its contents, under the
+	// name of the first of them
+	var movedTargets map[string]string
+	if filter.active() {
+		var err error
+		movedTargets, err = excludedLinkTargets(layer, filter)
+		if err != nil {
+			return nil, fmt.Errorf("error reading hard links: %v", err)
+		}
+	}
+
 	dir, _ := path.Split(output)
 	if dir != "" {
 		err := os.MkdirAll(dir, 0755)
@@ -599,7 +845,7 @@ func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []strin
 		w = gzip.NewWriter(aciFile)
 		defer w.Close()
 	}
-	trw := tar.NewWriter(w)
+	trw := NewTarWriter(w, dir)
 	defer trw.Close()
 
 	if err := WriteRootfsDir(trw); err != nil {
@@ -607,7 +853,9 @@ func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []strin
 	}
 
 	fileMap := make(map[string]struct{})
-	var whiteouts []string
+	var whiteouts, opaques, paths []string
+	// one buffer for all the files, instead of one per file
+	buf := make([]byte, 32*1024)
 	convWalker := func(t *tarball.TarFile) error {
 		name := t.Name()
 		if name == "./" {
@@ -620,42 +868,52 @@ func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []strin
 			return fmt.Errorf(`invalid layer: "/dev" is not a directory`)
 		}
 
-		fileMap[absolutePath] = struct{}{}
-		if strings.Contains(newName, "/.wh.") {
-			whiteouts = append(whiteouts, strings.Replace(absolutePath, ".wh.", "", 1))
+		// whiteouts hide files of the lower layers and aren't written
+		parent, base := path.Split(absolutePath)
+		switch {
+		case base == opaqueWhiteout:
+			opaques = append(opaques, path.Clean(parent))
+			return nil
+		case strings.HasPrefix(base, whiteoutPrefix):
+			whiteouts = append(whiteouts, path.Join(parent, strings.TrimPrefix(base, whiteoutPrefix)))
 			return nil
+		case isOverlayWhiteout(t.Header):
+			whiteouts = append(whiteouts, absolutePath)
+			return nil
+		}
+		newHeader := copyHeader(t.Header, newName)
+		if t.Header.Typeflag == tar.TypeDir && isOverlayOpaque(t.Header) {
+			opaques = append(opaques, absolutePath)
+			delete(newHeader.PAXRecords, paxXattrPrefix+overlayOpaqueXattr)
 		}
 
-		newHeader := &tar.Header{
-			Typeflag:   t.Header.Typeflag,
-			Name:       newName,
-			Linkname:   t.Header.Linkname,
-			Size:       t.Header.Size,
-			Mode:       t.Header.Mode,
-			Uid:        t.Header.Uid,
-			Gid:        t.Header.Gid,
-			Uname:      t.Header.Uname,
-			Gname:      t.Header.Gname,
-			ModTime:    t.Header.ModTime,
-			AccessTime: t.Header.AccessTime,
-			ChangeTime: t.Header.ChangeTime,
-			Devmajor:   t.Header.Devmajor,
-			Devminor:   t.Header.Devminor,
-			Xattrs:     t.Header.Xattrs,
+		if filter.Excluded(absolutePath, t.Header.Typeflag == tar.TypeDir) {
+			link, ok := movedTargets[absolutePath]
+			if !ok {
+				filter.remove(t.Header)
+				return nil
+			}
+			newHeader.Name = path.Join("rootfs", link)
+			absolutePath = link
 		}
 		if t.Header.Typeflag == tar.TypeLink {
-			newHeader.Linkname = path.Join("rootfs", t.Linkname())
-		}
-		if err := trw.WriteHeader(newHeader); err != nil {
-			return err
+			target := path.Join("/", t.Linkname())
+			if link, ok := movedTargets[target]; ok {
+				if link == absolutePath {
+					// already written with the contents of the target
+					return nil
+				}
+				target = link
+			}
+			newHeader.Linkname = path.Join("rootfs", target)
 		}
-		if _, err := io.Copy(trw, t.TarStream); err != nil {
+
+		fileMap[absolutePath] = struct{}{}
+		if err := trw.WriteEntry(newHeader, t.TarStream, buf); err != nil {
 			return err
 		}
 
-		if !util.In(curPwl, absolutePath) {
-			curPwl = append(curPwl, absolutePath)
-		}
+		paths = append(paths, absolutePath)
 
 		return nil
 	}
@@ -672,16 +930,19 @@ func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []strin
 		// ACIs will have the manifest and an empty rootfs directory in any
 		// case.
 	}
-	newPwl := subtractWhiteouts(curPwl, whiteouts)
+	// whiteouts only apply to the lower layers, so they are subtracted
+	// before the files of the layer are added
+	pwl := newPathWhitelist(curPwl)
+	subtractWhiteouts(pwl, whiteouts, opaques)
+	for _, p := range paths {
+		pwl.Add(p)
+	}
 
-	newPwl, err = writeStdioSymlinks(trw, fileMap, newPwl)
-	if err != nil {
+	if err := writeStdioSymlinks(trw, fileMap, pwl); err != nil {
 		return nil, err
 	}
-	// Let's copy the newly generated PathWhitelist to avoid unintended
-	// side-effects
-	manifest.PathWhitelist = make([]string, len(newPwl))
-	copy(manifest.PathWhitelist, newPwl)
+	// Paths returns a new slice, so curPwl is left untouched
+	manifest.PathWhitelist = pwl.Paths()
 
 	if err := WriteManifest(trw, manifest); err != nil {
 		return nil, fmt.Errorf("error writing manifest: %v", err)
@@ -690,6 +951,42 @@ func writeACI(layer io.ReadSeeker, manifest schema.ImageManifest, curPwl []strin
 	return &manifest, nil
 }
 
+// excludedLinkTargets returns the first hard link kept by filter to each
+// excluded file of layer, by file. layer is read from its current offset,
+// which is restored.
+func excludedLinkTargets(layer io.ReadSeeker, filter *PathFilter) (map[string]string, error) {
+	offset, err := layer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer layer.Seek(offset, io.SeekStart)
+
+	tr, err := aci.NewCompressedTarReader(layer)
+	if err != nil {
+		// empty layers are ignored by writeACI
+		return nil, nil
+	}
+	defer tr.Close()
+
+	targets := make(map[string]string)
+	linkWalker := func(t *tarball.TarFile) error {
+		if t.Header.Typeflag != tar.TypeLink {
+			return nil
+		}
+		link := path.Join("/", t.Name())
+		target := path.Join("/", t.Linkname())
+		if _, ok := targets[target]; ok || filter.Excluded(link, false) || !filter.Excluded(target, false) {
+			return nil
+		}
+		targets[target] = link
+		return nil
+	}
+	if err := tarball.Walk(*tr.Reader, linkWalker); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
 func getExecCommand(entrypoint []string, cmd []string) appctypes.Exec {
 	return append(entrypoint, cmd...)
 }
@@ -704,7 +1001,9 @@ func parseDockerUser(dockerUser string) (string, string) {
 
 	// when only the user is given, the docker spec says that the default and
 	// supplementary groups of the user in /etc/passwd should be applied.
-	// To avoid inspecting image content, we set gid to the same value as uid.
+	// The layers aren't available yet, so we set gid to the same value as
+	// uid. Names are resolved once the layers are converted, using the
+	// original user in the AppcDockerUser annotation.
 	if len(dockerUserParts) < 2 {
 		return dockerUserParts[0], dockerUserParts[0]
 	}
@@ -712,35 +1011,73 @@ func parseDockerUser(dockerUser string) (string, string) {
 	return dockerUserParts[0], dockerUserParts[1]
 }
 
-func subtractWhiteouts(pathWhitelist []string, whiteouts []string) []string {
-	matchPaths := []string{}
-	for _, path := range pathWhitelist {
-		// If one of the parent dirs of the current path matches the
-		// whiteout then also this path should be removed
-		curPath := path
-		for curPath != "/" {
-			for _, whiteout := range whiteouts {
-				if curPath == whiteout {
-					matchPaths = append(matchPaths, path)
-				}
-			}
-			curPath = filepath.Dir(curPath)
-		}
+// subtractWhiteouts removes from pwl the paths hidden by the whiteouts,
+// with their children, and the children of the opaque directories.
+func subtractWhiteouts(pwl *pathWhitelist, whiteouts []string, opaques []string) {
+	for _, w := range whiteouts {
+		pwl.Remove(w)
 	}
-	for _, matchPath := range matchPaths {
-		idx := util.IndexOf(pathWhitelist, matchPath)
-		if idx != -1 {
-			pathWhitelist = append(pathWhitelist[:idx], pathWhitelist[idx+1:]...)
-		}
+	for _, o := range opaques {
+		pwl.RemoveChildren(o)
 	}
+}
 
-	sort.Sort(sort.StringSlice(pathWhitelist))
+// isOverlayWhiteout says whether hdr is an overlayfs whiteout, a 0:0
+// character device.
+func isOverlayWhiteout(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar && hdr.Devmajor == 0 && hdr.Devminor == 0
+}
 
-	return pathWhitelist
+// isOverlayOpaque says whether hdr is marked as an opaque overlayfs
+// directory.
+func isOverlayOpaque(hdr *tar.Header) bool {
+	if hdr.Xattrs[overlayOpaqueXattr] == "y" {
+		return true
+	}
+	return hdr.PAXRecords[paxXattrPrefix+overlayOpaqueXattr] == "y"
+}
+
+// paxXattrPrefix prefixes the PAX records of the extended attributes, like
+// security.capability for the file capabilities or system.posix_acl_access
+// for the ACLs.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// copyHeader returns a copy of hdr named name, with all its metadata. The
+// extended attributes and the other PAX records, like ACLs, are kept in
+// PAXRecords. Sparse files keep their type or their GNU.sparse records, for
+// WriteEntry to write them sparse.
+func copyHeader(hdr *tar.Header, name string) *tar.Header {
+	newHeader := &tar.Header{
+		Typeflag:   hdr.Typeflag,
+		Name:       name,
+		Linkname:   hdr.Linkname,
+		Size:       hdr.Size,
+		Mode:       hdr.Mode,
+		Uid:        hdr.Uid,
+		Gid:        hdr.Gid,
+		Uname:      hdr.Uname,
+		Gname:      hdr.Gname,
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+		Devmajor:   hdr.Devmajor,
+		Devminor:   hdr.Devminor,
+	}
+	if len(hdr.PAXRecords) > 0 || len(hdr.Xattrs) > 0 {
+		newHeader.PAXRecords = make(map[string]string)
+		for k, v := range hdr.PAXRecords {
+			newHeader.PAXRecords[k] = v
+		}
+		// headers built by hand may only have the deprecated field
+		for k, v := range hdr.Xattrs {
+			newHeader.PAXRecords[paxXattrPrefix+k] = v
+		}
+	}
+	return newHeader
 }
 
 // WriteManifest writes a schema.ImageManifest entry on a tar.Writer.
-func WriteManifest(outputWriter *tar.Writer, manifest schema.ImageManifest) error {
+func WriteManifest(outputWriter *TarWriter, manifest schema.ImageManifest) error {
 	b, err := json.Marshal(manifest)
 	if err != nil {
 		return err
@@ -763,7 +1100,7 @@ func WriteManifest(outputWriter *tar.Writer, manifest schema.ImageManifest) erro
 }
 
 // WriteRootfsDir writes a "rootfs" dir entry on a tar.Writer.
-func WriteRootfsDir(tarWriter *tar.Writer) error {
+func WriteRootfsDir(tarWriter *TarWriter) error {
 	hdr := getGenericTarHeader()
 	hdr.Name = "rootfs"
 	hdr.Mode = 0755
@@ -781,7 +1118,7 @@ type symlink struct {
 // writeStdioSymlinks adds the /dev/stdin, /dev/stdout, /dev/stderr, and
 // /dev/fd symlinks expected by Docker to the converted ACIs so apps can find
 // them as expected
-func writeStdioSymlinks(tarWriter *tar.Writer, fileMap map[string]struct{}, pwl []string) ([]string, error) {
+func writeStdioSymlinks(tarWriter *TarWriter, fileMap map[string]struct{}, pwl *pathWhitelist) error {
 	stdioSymlinks := []symlink{
 		{"/dev/stdin", "/proc/self/fd/0"},
 		// Docker makes /dev/{stdout,stderr} point to /proc/self/fd/{1,2} but
@@ -805,14 +1142,12 @@ func writeStdioSymlinks(tarWriter *tar.Writer, fileMap map[string]struct{}, pwl
 			Linkname: target,
 		}
 		if err := tarWriter.WriteHeader(hdr); err != nil {
-			return nil, err
-		}
-		if !util.In(pwl, name) {
-			pwl = append(pwl, name)
+			return err
 		}
+		pwl.Add(name)
 	}
 
-	return pwl, nil
+	return nil
 }
 
 func getGenericTarHeader() *tar.Header {
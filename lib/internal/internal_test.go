@@ -15,8 +15,21 @@
 package internal
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/spec/schema"
 	"github.com/appc/spec/schema/types"
 )
 
@@ -116,3 +129,405 @@ func TestOSArch(t *testing.T) {
 		}
 	}
 }
+
+func TestOCIAnnotations(t *testing.T) {
+	imageAnnotations := map[string]string{
+		"org.opencontainers.image.source":   "https://github.com/example/foo",
+		"org.opencontainers.image.revision": "0123456789abcdef",
+		"org.opencontainers.image.version":  "1.2.3",
+		"org.opencontainers.image.url":      "https://example.com/foo",
+		"org.opencontainers.image.authors":  "foo maintainers",
+		"com.example.internal":              "ignored",
+	}
+	config := &typesV2.ImageConfig{
+		Author: "someone",
+		OS:     "linux",
+		Config: &typesV2.ImageConfigConfig{
+			Labels: map[string]string{"maintainer": "someone"},
+		},
+	}
+
+	tests := []struct {
+		tag             string
+		expectedVersion string
+	}{
+		{"latest", "latest"},
+		{"", "1.2.3"},
+	}
+	for _, tt := range tests {
+		dockerURL := &common.ParsedDockerURL{IndexURL: "example.com", ImageName: "foo", Tag: tt.tag}
+		manifest, err := GenerateManifestV22("example.com/foo", "", "sha256:abcd", dockerURL, config, imageAnnotations, nil, log.NewNopLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if version, _ := manifest.Labels.Get("version"); version != tt.expectedVersion {
+			t.Errorf("tag %q: expected version %q, got %q", tt.tag, tt.expectedVersion, version)
+		}
+
+		expected := map[string]string{
+			"org.opencontainers.image.source":   "https://github.com/example/foo",
+			"org.opencontainers.image.revision": "0123456789abcdef",
+			"org.opencontainers.image.version":  "1.2.3",
+			"homepage":                          "https://example.com/foo",
+			"authors":                           "foo maintainers",
+		}
+		for name, value := range expected {
+			if v, _ := manifest.Annotations.Get(name); v != value {
+				t.Errorf("expected annotation %s=%s, got %q", name, value, v)
+			}
+		}
+		if _, ok := manifest.Annotations.Get("com.example.internal"); ok {
+			t.Errorf("unexpected annotation com.example.internal")
+		}
+		if maintainer := manifest.App.UserLabels["maintainer"]; maintainer != "someone" {
+			t.Errorf("expected maintainer label, got %v", manifest.App.UserLabels)
+		}
+	}
+}
+
+func TestConvertPorts(t *testing.T) {
+	exposed := map[string]struct{}{
+		"80":            {},
+		"80/tcp":        {},
+		"53/tcp":        {},
+		"53/udp":        {},
+		"8000-8010/tcp": {},
+		"9000/SCTP":     {},
+	}
+	expected := []types.Port{
+		{Name: "53-tcp", Protocol: "tcp", Port: 53, Count: 1},
+		{Name: "53-udp", Protocol: "udp", Port: 53, Count: 1},
+		{Name: "80-tcp", Protocol: "tcp", Port: 80, Count: 1},
+		{Name: "8000-8010-tcp", Protocol: "tcp", Port: 8000, Count: 11},
+		{Name: "9000-sctp", Protocol: "sctp", Port: 9000, Count: 1},
+	}
+
+	ports, err := convertPorts(exposed, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ports) != len(expected) {
+		t.Fatalf("expected ports %v, got %v", expected, ports)
+	}
+	for i := range expected {
+		if ports[i] != expected[i] {
+			t.Errorf("expected port %v, got %v", expected[i], ports[i])
+		}
+	}
+
+	for _, p := range []string{"http", "80/icmp", "0", "70000/tcp", "8010-8000/tcp", "8000-/tcp", "/tcp"} {
+		if _, err := convertPorts(map[string]struct{}{p: {}}, nil, log.NewNopLogger()); err == nil || !strings.Contains(err.Error(), strconv.Quote(p)) {
+			t.Errorf("%q: expected an error naming the port, got %v", p, err)
+		}
+	}
+}
+
+func TestCopyHeader(t *testing.T) {
+	capability := "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	longName := "rootfs/" + strings.Repeat("very-long-directory-name/", 8) + "file"
+	records := map[string]string{
+		"SCHILY.xattr.security.capability":      capability,
+		"SCHILY.acl.access":                     "user::rwx,group::r-x,other::r-x",
+		"SCHILY.xattr.system.posix_acl_default": "\x02\x00\x00\x00",
+	}
+	expectedRecords := map[string]string{
+		"SCHILY.xattr.security.capability":      capability,
+		"SCHILY.acl.access":                     "user::rwx,group::r-x,other::r-x",
+		"SCHILY.xattr.system.posix_acl_default": "\x02\x00\x00\x00",
+		"SCHILY.xattr.user.comment":             "legacy",
+	}
+	// a sparse file with data at both ends, and one with a hole at its end
+	sparse := append(append([]byte("head"), make([]byte, 1<<20)...), "tail"...)
+	trailingHole := append([]byte("head"), make([]byte, 1<<20)...)
+
+	tests := []struct {
+		typeflag byte
+		uid      int
+		contents []byte
+		// the maximum size of the archive, if sparse
+		maxSize int
+	}{
+		{tar.TypeReg, 0, []byte("contents"), 0},
+		{tar.TypeGNUSparse, 0, sparse, 8192},
+		{tar.TypeGNUSparse, 1 << 30, trailingHole, 8192},
+		{tar.TypeGNUSparse, 0, make([]byte, 1<<20), 8192},
+	}
+	for i, tt := range tests {
+		hdr := &tar.Header{
+			Typeflag:   tt.typeflag,
+			Name:       "file",
+			Size:       int64(len(tt.contents)),
+			Mode:       0755,
+			Uid:        tt.uid,
+			ModTime:    time.Unix(1500000000, 0),
+			PAXRecords: records,
+			Xattrs:     map[string]string{"user.comment": "legacy"},
+		}
+		newHeader := copyHeader(hdr, longName)
+
+		var b bytes.Buffer
+		tw := NewTarWriter(&b, "")
+		if err := tw.WriteEntry(newHeader, bytes.NewReader(tt.contents), nil); err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if tt.maxSize != 0 && b.Len() > tt.maxSize {
+			t.Errorf("%d: expected a sparse entry of at most %d bytes, got %d bytes", i, tt.maxSize, b.Len())
+		}
+		tr := tar.NewReader(&b)
+		got, err := tr.Next()
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+
+		if got.Name != longName {
+			t.Errorf("%d: expected name %q, got %q", i, longName, got.Name)
+		}
+		if got.Size != hdr.Size {
+			t.Errorf("%d: expected size %d, got %d", i, hdr.Size, got.Size)
+		}
+		if !bytes.Equal(contents, tt.contents) {
+			t.Errorf("%d: wrong contents", i)
+		}
+		if got.Typeflag != tar.TypeReg {
+			t.Errorf("%d: expected a regular file, got type %q", i, got.Typeflag)
+		}
+		if got.Uid != hdr.Uid || !got.ModTime.Equal(hdr.ModTime) {
+			t.Errorf("%d: expected uid %d and time %v, got %d and %v", i, hdr.Uid, hdr.ModTime, got.Uid, got.ModTime)
+		}
+		if IsSparse(got) != (tt.typeflag == tar.TypeGNUSparse) {
+			t.Errorf("%d: expected sparse %v", i, tt.typeflag == tar.TypeGNUSparse)
+		}
+		for k, v := range expectedRecords {
+			if got.PAXRecords[k] != v {
+				t.Errorf("%d: expected PAX record %s=%q, got %q", i, k, v, got.PAXRecords[k])
+			}
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("%d: expected the end of the archive, got %v", i, err)
+		}
+	}
+}
+
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestLargeSparseFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads 8GiB of holes")
+	}
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// larger than the 8GiB of the size field, with data in the middle
+	const middle = 4 << 30
+	const size = 8<<30 + 10
+	contents := func() io.Reader {
+		return io.MultiReader(
+			strings.NewReader("head"),
+			io.LimitReader(zeroReader{}, middle-4),
+			strings.NewReader("middle"),
+			io.LimitReader(zeroReader{}, size-middle-10),
+			strings.NewReader("tail"),
+		)
+	}
+	var layer bytes.Buffer
+	tw := NewTarWriter(&layer, tmpDir)
+	hdr := &tar.Header{Typeflag: tar.TypeGNUSparse, Name: "big", Size: size, Mode: 0644, ModTime: time.Unix(1500000000, 0)}
+	if err := tw.WriteEntry(hdr, contents(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the real size is in the PAX records, and the size field holds the
+	// size of the sparse map and the data
+	octal := func(field []byte) int64 {
+		v, err := strconv.ParseInt(strings.TrimRight(string(field), "\x00"), 8, 64)
+		if err != nil {
+			t.Fatalf("invalid numeric field %q: %v", field, err)
+		}
+		return v
+	}
+	b := layer.Bytes()
+	paxLen := octal(b[124:136])
+	records := string(b[blockSize : blockSize+paxLen])
+	if !strings.Contains(records, " GNU.sparse.realsize="+strconv.Itoa(size)+"\n") {
+		t.Errorf("expected the real size in the PAX records, got %q", records)
+	}
+	ustar := b[blockSize+paxLen+blockPadding(paxLen):]
+	stored := octal(ustar[124:136])
+	if expected := int64(len(ustar)) - blockSize - 2*blockSize; stored+blockPadding(stored) != expected {
+		t.Errorf("expected a size field of about %d, got %d", expected, stored)
+	}
+
+	output := filepath.Join(tmpDir, "layer.aci")
+	manifest := schema.ImageManifest{ACKind: schema.ImageManifestKind, ACVersion: schema.AppContainerVersion, Name: "example.com/sparse"}
+	if _, err := writeACI(bytes.NewReader(layer.Bytes()), manifest, nil, output, common.NoCompression, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fi, err := os.Stat(output)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fi.Size() > 1<<20 {
+		t.Errorf("expected the holes to stay holes, got an ACI of %d bytes", fi.Size())
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		got, err := tr.Next()
+		if err != nil {
+			t.Fatalf("expected rootfs/big: %v", err)
+		}
+		if got.Name != "rootfs/big" {
+			continue
+		}
+		if got.Size != size || got.PAXRecords["GNU.sparse.realsize"] != strconv.Itoa(size) {
+			t.Errorf("expected size %d, got %d and PAX records %v", size, got.Size, got.PAXRecords)
+		}
+		break
+	}
+	expected := contents()
+	chunk, want := make([]byte, 1<<20), make([]byte, 1<<20)
+	for {
+		n, err := io.ReadFull(tr, chunk)
+		if _, err := io.ReadFull(expected, want[:n]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(chunk[:n], want[:n]) {
+			t.Fatalf("wrong contents")
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n, _ := expected.Read(want); n != 0 {
+		t.Errorf("expected %d bytes, got less", size)
+	}
+}
+
+func TestSubtractWhiteouts(t *testing.T) {
+	pwl := newPathWhitelist([]string{
+		"/",
+		"/a",
+		"/a/b",
+		"/a/b/c",
+		"/a/bc",
+		"/d",
+		"/d/e",
+		"/f",
+		"/f/g",
+		"/f/g/h",
+	})
+	subtractWhiteouts(pwl, []string{"/a/b", "/missing/x"}, []string{"/f", "/missing"})
+	pwl.Add("/f/new")
+	pwl.Add("/a/b")
+
+	expected := "/ /a /a/b /a/bc /d /d/e /f /f/new"
+	if got := strings.Join(pwl.Paths(), " "); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if pwl.Has("/a/b/c") || !pwl.Has("/d/e") || pwl.Has("/f/g") {
+		t.Errorf("unexpected paths in %v", pwl.Paths())
+	}
+
+	subtractWhiteouts(pwl, nil, []string{"/"})
+	if got := strings.Join(pwl.Paths(), " "); got != "/" {
+		t.Errorf("expected only / below an opaque root, got %s", got)
+	}
+}
+
+// benchmarkLayer returns a layer of files, 100 per directory, and the path
+// whitelist of a lower layer with as many other files.
+func benchmarkLayer(files int) ([]byte, []string) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	var lowerPwl []string
+	for i := 0; i < files; i++ {
+		dir := "dir" + strconv.Itoa(i/100)
+		name := dir + "/file" + strconv.Itoa(i)
+		if i%100 == 0 {
+			tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755})
+			lowerPwl = append(lowerPwl, "/lower/"+dir)
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644})
+		lowerPwl = append(lowerPwl, "/lower/"+name)
+		// hide a tenth of the lower directories
+		if i%1000 == 0 {
+			tw.WriteHeader(&tar.Header{Name: "lower/" + dir + "/.wh..wh..opq", Typeflag: tar.TypeReg})
+		}
+	}
+	tw.Close()
+	return b.Bytes(), lowerPwl
+}
+
+// BenchmarkWriteACI converts layers of growing sizes, the time per file
+// should stay about the same.
+func BenchmarkWriteACI(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-bench-")
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, files := range []int{1000, 10000, 100000} {
+		layer, lowerPwl := benchmarkLayer(files)
+		b.Run(strconv.Itoa(files), func(b *testing.B) {
+			output := filepath.Join(tmpDir, "layer.aci")
+			for i := 0; i < b.N; i++ {
+				manifest := schema.ImageManifest{ACKind: schema.ImageManifestKind, ACVersion: schema.AppContainerVersion, Name: "example.com/bench"}
+				if _, err := writeACI(bytes.NewReader(layer), manifest, lowerPwl, output, common.NoCompression, nil); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*files), "ns/file")
+		})
+	}
+}
+
+// BenchmarkSubtractWhiteouts computes the path whitelists of growing sizes,
+// the time per path should stay about the same.
+func BenchmarkSubtractWhiteouts(b *testing.B) {
+	for _, files := range []int{1000, 10000, 100000} {
+		_, lowerPwl := benchmarkLayer(files)
+		var whiteouts, opaques []string
+		for i := 0; i < files/100; i += 10 {
+			whiteouts = append(whiteouts, "/lower/dir"+strconv.Itoa(i))
+			opaques = append(opaques, "/lower/dir"+strconv.Itoa(i+1))
+		}
+		b.Run(strconv.Itoa(files), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pwl := newPathWhitelist(lowerPwl)
+				subtractWhiteouts(pwl, whiteouts, opaques)
+				pwl.Paths()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*files), "ns/path")
+		})
+	}
+}
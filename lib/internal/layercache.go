@@ -0,0 +1,104 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+
+	"github.com/appc/spec/schema"
+)
+
+// LayerCache keeps the layer ACIs converted by a backend for an image, so
+// that the images sharing layers with it reuse them instead of converting
+// them again. The layers must be converted to the same directory, with the
+// same compression and path filter. A nil *LayerCache keeps nothing.
+//
+// Only the layers other than the top one of Docker v2.2 images are kept, as
+// their ACIs don't depend on the image. A layer ACI is reused once the
+// converter completed it, as it may rename it.
+type LayerCache struct {
+	layers map[string]*cachedLayer // by LayerKey
+	paths  map[string]*cachedLayer // by path
+}
+
+type cachedLayer struct {
+	path     string
+	manifest *schema.ImageManifest
+	complete bool
+}
+
+// NewLayerCache returns an empty LayerCache.
+func NewLayerCache() *LayerCache {
+	return &LayerCache{
+		layers: make(map[string]*cachedLayer),
+		paths:  make(map[string]*cachedLayer),
+	}
+}
+
+// LayerKey identifies the layer ACI of the first of layerIDs, which are
+// ordered from the top layer to the base one: the path whitelist of a layer
+// depends on the layers below it.
+func LayerKey(layerIDs []string) string {
+	return strings.Join(layerIDs, " ")
+}
+
+// Get returns the path and the manifest of the complete layer ACI of key.
+func (c *LayerCache) Get(key string) (string, *schema.ImageManifest, bool) {
+	if c == nil {
+		return "", nil, false
+	}
+	l, ok := c.layers[key]
+	if !ok || !l.complete {
+		return "", nil, false
+	}
+	return l.path, l.manifest, true
+}
+
+// Add adds the layer ACI of key, converted at path.
+func (c *LayerCache) Add(key, path string, manifest *schema.ImageManifest) {
+	if c == nil {
+		return
+	}
+	l := &cachedLayer{path: path, manifest: manifest}
+	c.layers[key] = l
+	c.paths[path] = l
+}
+
+// Reused says whether the layer ACI at path was completed for another
+// image.
+func (c *LayerCache) Reused(path string) bool {
+	if c == nil {
+		return false
+	}
+	l, ok := c.paths[path]
+	return ok && l.complete
+}
+
+// Complete records that the layer ACI added at path is complete, at
+// finalPath with manifest. Other paths are ignored.
+func (c *LayerCache) Complete(path, finalPath string, manifest *schema.ImageManifest) {
+	if c == nil {
+		return
+	}
+	l, ok := c.paths[path]
+	if !ok || l.complete {
+		return
+	}
+	delete(c.paths, path)
+	l.path = finalPath
+	l.manifest = manifest
+	l.complete = true
+	c.paths[finalPath] = l
+}
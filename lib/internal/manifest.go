@@ -0,0 +1,120 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/tarball"
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
+	gzip "github.com/klauspost/pgzip"
+)
+
+// RewriteManifest replaces the manifest of the ACI at aciPath, which is
+// rewritten with the given compression.
+func RewriteManifest(aciPath string, manifest schema.ImageManifest, compression common.Compression) error {
+	return RewriteACI(aciPath, manifest, nil, compression)
+}
+
+// RewriteACI is like RewriteManifest, and also adds the empty rootfs
+// entries of entries, named by their absolute path in the rootfs, replacing
+// the existing ones.
+func RewriteACI(aciPath string, manifest schema.ImageManifest, entries []*tar.Header, compression common.Compression) error {
+	replaced := make(map[string]struct{})
+	for _, hdr := range entries {
+		replaced[filepath.Join("rootfs", hdr.Name)] = struct{}{}
+	}
+
+	in, err := os.Open(aciPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tr, err := aci.NewCompressedTarReader(in)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(aciPath), "docker2aci-")
+	if err != nil {
+		return fmt.Errorf("error creating ACI file: %v", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if err := out.Chmod(fi.Mode()); err != nil {
+		return err
+	}
+
+	var w io.Writer = out
+	var gw *gzip.Writer
+	if compression == common.GzipCompression {
+		gw = gzip.NewWriter(out)
+		w = gw
+	}
+	tw := NewTarWriter(w, filepath.Dir(aciPath))
+	if err := WriteManifest(tw, manifest); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+
+	copyWalker := func(t *tarball.TarFile) error {
+		name := filepath.Clean(t.Name())
+		if name == "manifest" {
+			return nil
+		}
+		if _, ok := replaced[name]; ok {
+			return nil
+		}
+		return tw.WriteEntry(t.Header, t.TarStream, nil)
+	}
+	if err := tarball.Walk(*tr.Reader, copyWalker); err != nil {
+		return fmt.Errorf("error copying ACI: %v", err)
+	}
+	for _, hdr := range entries {
+		h := *hdr
+		h.Name = filepath.Join("rootfs", hdr.Name)
+		h.Size = 0
+		if err := tw.WriteHeader(&h); err != nil {
+			return fmt.Errorf("error writing %s: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(out.Name(), aciPath); err != nil {
+		return err
+	}
+	return ValidateACI(aciPath)
+}
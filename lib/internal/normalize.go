@@ -0,0 +1,115 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/tarball"
+	"github.com/appc/spec/aci"
+)
+
+// NormalizeACI rewrites the ACI at aciPath for identical images to give
+// identical files: the timestamps of the entries are truncated to the
+// second, and clamped to epoch if it isn't zero, the entries generated by
+// docker2aci get epoch, and the ACI is compressed with a deterministic
+// gzip writer.
+func NormalizeACI(aciPath string, compression common.Compression, epoch time.Time) error {
+	in, err := os.Open(aciPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tr, err := aci.NewCompressedTarReader(in)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(aciPath), "docker2aci-")
+	if err != nil {
+		return fmt.Errorf("error creating ACI file: %v", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if err := out.Chmod(fi.Mode()); err != nil {
+		return err
+	}
+
+	var w io.Writer = out
+	var gw *gzip.Writer
+	switch compression {
+	case common.NoCompression:
+	case common.GzipCompression:
+		// unlike pgzip, compress/gzip gives the same output for the same
+		// input, and leaves the header timestamp unset
+		gw = gzip.NewWriter(out)
+		w = gw
+	default:
+		return fmt.Errorf("unexpected compression enum value: %d", compression)
+	}
+	tw := NewTarWriter(w, filepath.Dir(aciPath))
+
+	clamp := func(t time.Time) time.Time {
+		t = t.Truncate(time.Second)
+		if !epoch.IsZero() && t.After(epoch) {
+			return epoch
+		}
+		return t
+	}
+	generatedTime := time.Unix(0, 0)
+	if !epoch.IsZero() {
+		generatedTime = epoch
+	}
+
+	normalizeWalker := func(t *tarball.TarFile) error {
+		hdr := copyHeader(t.Header, t.Header.Name)
+		hdr.ModTime = clamp(t.Header.ModTime)
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+		switch filepath.Clean(t.Name()) {
+		case "manifest", "rootfs":
+			hdr.ModTime = generatedTime
+		}
+		return tw.WriteEntry(hdr, t.TarStream, nil)
+	}
+	if err := tarball.Walk(*tr.Reader, normalizeWalker); err != nil {
+		return fmt.Errorf("error normalizing ACI: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), aciPath)
+}
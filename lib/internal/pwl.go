@@ -0,0 +1,125 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// pathWhitelist is a set of absolute paths stored as a tree of their
+// components, so that the whiteouts can remove a directory with everything
+// below it without scanning the whole set. The path whitelists of big layers
+// have hundreds of thousands of paths.
+type pathWhitelist struct {
+	root pathNode
+}
+
+type pathNode struct {
+	children map[string]*pathNode
+	present  bool // whether the path of the node is in the set, and not only below it
+}
+
+// newPathWhitelist returns a pathWhitelist holding paths.
+func newPathWhitelist(paths []string) *pathWhitelist {
+	pwl := &pathWhitelist{}
+	for _, p := range paths {
+		pwl.Add(p)
+	}
+	return pwl
+}
+
+// Add adds p to the set.
+func (pwl *pathWhitelist) Add(p string) {
+	node := &pwl.root
+	for _, c := range pathComponents(p) {
+		child, ok := node.children[c]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*pathNode)
+			}
+			child = &pathNode{}
+			node.children[c] = child
+		}
+		node = child
+	}
+	node.present = true
+}
+
+// Has says whether p is in the set.
+func (pwl *pathWhitelist) Has(p string) bool {
+	node := pwl.find(pathComponents(p))
+	return node != nil && node.present
+}
+
+// Remove removes p and the paths below it from the set.
+func (pwl *pathWhitelist) Remove(p string) {
+	components := pathComponents(p)
+	if len(components) == 0 {
+		pwl.root = pathNode{}
+		return
+	}
+	if parent := pwl.find(components[:len(components)-1]); parent != nil {
+		delete(parent.children, components[len(components)-1])
+	}
+}
+
+// RemoveChildren removes the paths below p from the set, keeping p.
+func (pwl *pathWhitelist) RemoveChildren(p string) {
+	if node := pwl.find(pathComponents(p)); node != nil {
+		node.children = nil
+	}
+}
+
+// Paths returns the paths of the set, sorted.
+func (pwl *pathWhitelist) Paths() []string {
+	var paths []string
+	var walk func(node *pathNode, p string)
+	walk = func(node *pathNode, p string) {
+		if node.present {
+			paths = append(paths, p)
+		}
+		for c, child := range node.children {
+			walk(child, path.Join(p, c))
+		}
+	}
+	walk(&pwl.root, "/")
+	sort.Strings(paths)
+	return paths
+}
+
+// find returns the node of the path made of components, or nil if there's no
+// such node.
+func (pwl *pathWhitelist) find(components []string) *pathNode {
+	node := &pwl.root
+	for _, c := range components {
+		node = node.children[c]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// pathComponents splits the absolute path p in its components, none for
+// "/".
+func pathComponents(p string) []string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,353 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	blockSize = 512
+
+	paxGNUSparse = "GNU.sparse."
+)
+
+// paxBasicKeys are the PAX records of the header fields, which the tar reader
+// copies to PAXRecords. The sparse entries set them again.
+var paxBasicKeys = map[string]bool{
+	"path": true, "linkpath": true, "size": true, "uid": true, "gid": true,
+	"uname": true, "gname": true, "mtime": true, "atime": true, "ctime": true,
+}
+
+var errNoEntry = errors.New("tar write without an entry")
+
+// IsSparse says whether hdr, read by a tar reader, is a sparse file, in the
+// old GNU format or in a PAX one. The tar reader reads the holes of sparse
+// files as zeros and keeps their sparse map to itself.
+func IsSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, paxGNUSparse) {
+			return true
+		}
+	}
+	return false
+}
+
+// TarWriter writes a tar archive, with the sparse files that tar.Writer can't
+// write. It owns the stream: each entry is written by a tar.Writer of its
+// own, which doesn't write anything after the padding of the entry, and
+// TarWriter writes the sparse entries and the end of the archive itself.
+type TarWriter struct {
+	w      io.Writer
+	tw     *tar.Writer // of the current entry, if any
+	tmpDir string
+	closed bool
+}
+
+// NewTarWriter returns a TarWriter writing to w, which spools the data of the
+// sparse files to tmpDir.
+func NewTarWriter(w io.Writer, tmpDir string) *TarWriter {
+	return &TarWriter{w: w, tmpDir: tmpDir}
+}
+
+// WriteHeader ends the current entry and starts the entry hdr, whose contents
+// are then written with Write. Sparse files are written with WriteEntry.
+func (t *TarWriter) WriteHeader(hdr *tar.Header) error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	if IsSparse(hdr) {
+		return fmt.Errorf("sparse file %q written without its contents", hdr.Name)
+	}
+	t.tw = tar.NewWriter(t.w)
+	return t.tw.WriteHeader(hdr)
+}
+
+// Write writes the contents of the current entry.
+func (t *TarWriter) Write(b []byte) (int, error) {
+	if t.tw == nil {
+		return 0, errNoEntry
+	}
+	return t.tw.Write(b)
+}
+
+// Flush ends the current entry, padding it to a block.
+func (t *TarWriter) Flush() error {
+	if t.tw == nil {
+		return nil
+	}
+	err := t.tw.Flush()
+	t.tw = nil
+	return err
+}
+
+// Close ends the archive, without closing the underlying writer.
+func (t *TarWriter) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	_, err := t.w.Write(make([]byte, 2*blockSize))
+	return err
+}
+
+// WriteEntry writes the entry hdr with its contents read from r. Sparse files
+// are written in the PAX 1.0 sparse format: their blocks of zeros are holes,
+// and their data is spooled to know its size. buf is used to copy the
+// contents, if not nil.
+func (t *TarWriter) WriteEntry(hdr *tar.Header, r io.Reader, buf []byte) error {
+	if !IsSparse(hdr) {
+		if err := t.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.CopyBuffer(t, r, buf)
+		return err
+	}
+	if err := t.Flush(); err != nil {
+		return err
+	}
+
+	spool, err := ioutil.TempFile(t.tmpDir, "docker2aci-sparse-")
+	if err != nil {
+		return fmt.Errorf("error creating sparse file spool: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	fragments, err := spoolSparseData(spool, r, hdr.Size)
+	if err != nil {
+		return fmt.Errorf("error reading sparse file %q: %v", hdr.Name, err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	sparseMap := []byte(strconv.Itoa(len(fragments)) + "\n")
+	var size int64
+	for _, f := range fragments {
+		sparseMap = append(sparseMap, fmt.Sprintf("%d\n%d\n", f.offset, f.length)...)
+		size += f.length
+	}
+	sparseMap = append(sparseMap, make([]byte, blockPadding(int64(len(sparseMap))))...)
+	size += int64(len(sparseMap))
+
+	header, err := sparseHeader(hdr, size)
+	if err != nil {
+		return err
+	}
+	for _, b := range [][]byte{header, sparseMap} {
+		if _, err := t.w.Write(b); err != nil {
+			return err
+		}
+	}
+	if _, err := io.CopyBuffer(t.w, spool, buf); err != nil {
+		return err
+	}
+	_, err = t.w.Write(make([]byte, blockPadding(size)))
+	return err
+}
+
+// sparseHeader returns the PAX header and the USTAR header of the sparse file
+// hdr, stored in size bytes with its sparse map.
+func sparseHeader(hdr *tar.Header, size int64) ([]byte, error) {
+	dir, file := path.Split(hdr.Name)
+	records := map[string]string{
+		paxGNUSparse + "major":    "1",
+		paxGNUSparse + "minor":    "0",
+		paxGNUSparse + "name":     hdr.Name,
+		paxGNUSparse + "realsize": strconv.FormatInt(hdr.Size, 10),
+		"mtime":                   formatPAXTime(hdr.ModTime),
+	}
+	for k, v := range hdr.PAXRecords {
+		if !paxBasicKeys[k] && !strings.HasPrefix(k, paxGNUSparse) {
+			records[k] = v
+		}
+	}
+	for k, v := range hdr.Xattrs {
+		records[paxXattrPrefix+k] = v
+	}
+	if !hdr.AccessTime.IsZero() {
+		records["atime"] = formatPAXTime(hdr.AccessTime)
+	}
+	if !hdr.ChangeTime.IsZero() {
+		records["ctime"] = formatPAXTime(hdr.ChangeTime)
+	}
+
+	block := ustarBlock(path.Join(dir, "GNUSparseFile.0", file), tar.TypeReg, hdr.Mode, hdr.ModTime.Unix())
+	if !setOctal(block[124:136], size) {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	if !setOctal(block[108:116], int64(hdr.Uid)) {
+		records["uid"] = strconv.Itoa(hdr.Uid)
+	}
+	if !setOctal(block[116:124], int64(hdr.Gid)) {
+		records["gid"] = strconv.Itoa(hdr.Gid)
+	}
+	if !setString(block[265:297], hdr.Uname) {
+		records["uname"] = hdr.Uname
+	}
+	if !setString(block[297:329], hdr.Gname) {
+		records["gname"] = hdr.Gname
+	}
+	setChecksum(block)
+
+	var paxData bytes.Buffer
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.ContainsAny(k, "=\x00") {
+			return nil, fmt.Errorf("invalid PAX record %q of %q", k, hdr.Name)
+		}
+		paxData.WriteString(paxRecord(k, records[k]))
+	}
+	paxBlock := ustarBlock(path.Join(dir, "PaxHeaders.0", file), tar.TypeXHeader, 0644, 0)
+	setOctal(paxBlock[124:136], int64(paxData.Len()))
+	setChecksum(paxBlock)
+
+	header := append(paxBlock, paxData.Bytes()...)
+	header = append(header, make([]byte, blockPadding(int64(paxData.Len())))...)
+	return append(header, block...), nil
+}
+
+// sparseFragment is a part of the data of a sparse file.
+type sparseFragment struct {
+	offset, length int64
+}
+
+// spoolSparseData copies the blocks of the size bytes read from r which
+// aren't all zeros to w, and returns their fragments. The last fragment ends
+// the file, even if it's empty.
+func spoolSparseData(w io.Writer, r io.Reader, size int64) ([]sparseFragment, error) {
+	chunk := make([]byte, 128*blockSize)
+	zeros := make([]byte, blockSize)
+	var fragments []sparseFragment
+	for offset := int64(0); offset < size; {
+		n := int64(len(chunk))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := io.ReadFull(r, chunk[:n]); err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < n; i += blockSize {
+			block := chunk[i:n]
+			if len(block) > blockSize {
+				block = block[:blockSize]
+			}
+			if bytes.Equal(block, zeros[:len(block)]) {
+				continue
+			}
+			if _, err := w.Write(block); err != nil {
+				return nil, err
+			}
+			last := len(fragments) - 1
+			if last >= 0 && fragments[last].offset+fragments[last].length == offset+i {
+				fragments[last].length += int64(len(block))
+			} else {
+				fragments = append(fragments, sparseFragment{offset + i, int64(len(block))})
+			}
+		}
+		offset += n
+	}
+	last := len(fragments) - 1
+	if last < 0 || fragments[last].offset+fragments[last].length != size {
+		fragments = append(fragments, sparseFragment{size, 0})
+	}
+	return fragments, nil
+}
+
+// ustarBlock returns a USTAR header block without checksum. The fields which
+// don't fit are left empty, for PAX records.
+func ustarBlock(name string, typeflag byte, mode, mtime int64) []byte {
+	block := make([]byte, blockSize)
+	setString(block[0:100], name)
+	setOctal(block[100:108], mode)
+	setOctal(block[136:148], mtime)
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	return block
+}
+
+// setOctal writes v in the numeric field b, if it fits.
+func setOctal(b []byte, v int64) bool {
+	s := strconv.FormatInt(v, 8)
+	if v < 0 || len(s) >= len(b) {
+		return false
+	}
+	copy(b, strings.Repeat("0", len(b)-1-len(s))+s)
+	return true
+}
+
+// setString writes s in the string field b, truncated if it doesn't fit.
+func setString(b []byte, s string) bool {
+	copy(b, s)
+	return len(s) <= len(b)
+}
+
+func setChecksum(block []byte) {
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+}
+
+// paxRecord formats a PAX record, prefixed with its own length.
+func paxRecord(k, v string) string {
+	record := " " + k + "=" + v + "\n"
+	size := len(record)
+	for size < len(strconv.Itoa(size))+len(record) {
+		size = len(strconv.Itoa(size)) + len(record)
+	}
+	return strconv.Itoa(size) + record
+}
+
+func formatPAXTime(t time.Time) string {
+	sec, nsec := t.Unix(), t.Nanosecond()
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	sign := ""
+	if sec < 0 {
+		sign = "-"
+		sec, nsec = -(sec + 1), 1e9-nsec
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%09d", sign, sec, nsec), "0")
+}
+
+func blockPadding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
@@ -0,0 +1,300 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FetchFunc downloads the metadata file of a role. size is the expected length
+// of the file, or -1 if it isn't known yet.
+type FetchFunc func(role string, size int64) ([]byte, error)
+
+// Client resolves targets from a TUF repository, starting from a locally
+// trusted root.
+type Client struct {
+	fetch FetchFunc
+	now   func() time.Time
+
+	root        *Root
+	rootBytes   []byte
+	rootUpdated bool
+
+	versions map[string]int    // of the trusted timestamp and snapshot
+	metadata map[string][]byte // timestamp and snapshot verified by Resolve
+}
+
+// NewClient creates a Client that trusts the keys of the given root.json.
+func NewClient(trustedRoot []byte, fetch FetchFunc) (*Client, error) {
+	var s Signed
+	if err := json.Unmarshal(trustedRoot, &s); err != nil {
+		return nil, fmt.Errorf("error unmarshaling trusted root: %v", err)
+	}
+	root, err := decodeRoot(&s)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyRole(&s, root, RoleRoot); err != nil {
+		return nil, fmt.Errorf("trusted root: %v", err)
+	}
+
+	return &Client{
+		fetch:     fetch,
+		now:       time.Now,
+		root:      root,
+		rootBytes: trustedRoot,
+		versions:  make(map[string]int),
+		metadata:  make(map[string][]byte),
+	}, nil
+}
+
+// TrustMetadata sets the timestamp or snapshot metadata trusted last time,
+// which the fetched one mustn't be older than: older metadata would roll the
+// repository back to targets that may have been revoked since.
+func (c *Client) TrustMetadata(role string, trusted []byte) error {
+	var typ string
+	switch role {
+	case RoleTimestamp:
+		typ = "Timestamp"
+	case RoleSnapshot:
+		typ = "Snapshot"
+	default:
+		return fmt.Errorf("can't trust %s metadata", role)
+	}
+	var s Signed
+	if err := json.Unmarshal(trusted, &s); err != nil {
+		return fmt.Errorf("error unmarshaling trusted %s: %v", role, err)
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := decodeSigned(&s, typ, &header); err != nil {
+		return fmt.Errorf("trusted %s: %v", role, err)
+	}
+	c.versions[role] = header.Version
+	return nil
+}
+
+// Metadata returns the timestamp or snapshot metadata verified by Resolve,
+// to be trusted the next time.
+func (c *Client) Metadata(role string) []byte {
+	return c.metadata[role]
+}
+
+// Root returns the root.json the client currently trusts, and whether it was
+// rotated from the one the client was created with.
+func (c *Client) Root() ([]byte, bool) {
+	return c.rootBytes, c.rootUpdated
+}
+
+// Resolve walks the timestamp, snapshot and targets roles and returns the
+// metadata of the target called name. The targets/releases delegation takes
+// precedence over the base targets role, as in Docker.
+func (c *Client) Resolve(name string) (*FileMeta, error) {
+	if err := c.updateRoot(); err != nil {
+		return nil, err
+	}
+	now := c.now()
+	if err := CheckExpiry(RoleRoot, c.root.Expires, now); err != nil {
+		return nil, err
+	}
+
+	tsSigned, tsBytes, err := c.fetchSigned(RoleTimestamp, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ts Timestamp
+	if err := decodeSigned(tsSigned, "Timestamp", &ts); err != nil {
+		return nil, err
+	}
+	if err := verifyRole(tsSigned, c.root, RoleTimestamp); err != nil {
+		return nil, fmt.Errorf("%s: %v", RoleTimestamp, err)
+	}
+	if err := CheckExpiry(RoleTimestamp, ts.Expires, now); err != nil {
+		return nil, err
+	}
+	if err := c.checkVersion(RoleTimestamp, ts.Version); err != nil {
+		return nil, err
+	}
+
+	snapMeta, ok := ts.Meta[RoleSnapshot]
+	if !ok {
+		return nil, fmt.Errorf("timestamp doesn't reference the snapshot")
+	}
+	snapSigned, snapBytes, err := c.fetchSigned(RoleSnapshot, &snapMeta)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := decodeSigned(snapSigned, "Snapshot", &snap); err != nil {
+		return nil, err
+	}
+	if err := verifyRole(snapSigned, c.root, RoleSnapshot); err != nil {
+		return nil, fmt.Errorf("%s: %v", RoleSnapshot, err)
+	}
+	if err := CheckExpiry(RoleSnapshot, snap.Expires, now); err != nil {
+		return nil, err
+	}
+	if err := c.checkVersion(RoleSnapshot, snap.Version); err != nil {
+		return nil, err
+	}
+	c.metadata[RoleTimestamp] = tsBytes
+	c.metadata[RoleSnapshot] = snapBytes
+
+	targetsMeta, ok := snap.Meta[RoleTargets]
+	if !ok {
+		return nil, fmt.Errorf("snapshot doesn't reference the targets")
+	}
+	targetsSigned, _, err := c.fetchSigned(RoleTargets, &targetsMeta)
+	if err != nil {
+		return nil, err
+	}
+	var targets Targets
+	if err := decodeSigned(targetsSigned, "Targets", &targets); err != nil {
+		return nil, err
+	}
+	if err := verifyRole(targetsSigned, c.root, RoleTargets); err != nil {
+		return nil, fmt.Errorf("%s: %v", RoleTargets, err)
+	}
+	if err := CheckExpiry(RoleTargets, targets.Expires, now); err != nil {
+		return nil, err
+	}
+
+	if releasesMeta, ok := snap.Meta[RoleReleases]; ok {
+		for _, d := range targets.Delegations.Roles {
+			if d.Name != RoleReleases {
+				continue
+			}
+			releasesSigned, _, err := c.fetchSigned(RoleReleases, &releasesMeta)
+			if err != nil {
+				return nil, err
+			}
+			var releases Targets
+			if err := decodeSigned(releasesSigned, "Targets", &releases); err != nil {
+				return nil, err
+			}
+			if err := VerifySignatures(releasesSigned, targets.Delegations.Keys, d.KeyIDs, d.Threshold); err != nil {
+				return nil, fmt.Errorf("%s: %v", RoleReleases, err)
+			}
+			if err := CheckExpiry(RoleReleases, releases.Expires, now); err != nil {
+				return nil, err
+			}
+			if t, ok := releases.Targets[name]; ok {
+				if !d.AllowsTarget(name) {
+					return nil, fmt.Errorf("%s isn't trusted for target %q", RoleReleases, name)
+				}
+				return &t, nil
+			}
+		}
+	}
+
+	if t, ok := targets.Targets[name]; ok {
+		return &t, nil
+	}
+	return nil, ErrTargetNotFound
+}
+
+// updateRoot fetches the remote root.json and, if it is newer than the
+// trusted one, checks it is signed both by the trusted root keys and by its
+// own root keys before trusting it.
+func (c *Client) updateRoot() error {
+	s, b, err := c.fetchSigned(RoleRoot, nil)
+	if err != nil {
+		return err
+	}
+	root, err := decodeRoot(s)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case root.Version < c.root.Version:
+		return fmt.Errorf("root: remote version %d is older than trusted version %d", root.Version, c.root.Version)
+	case root.Version == c.root.Version:
+		return nil
+	}
+
+	if err := verifyRole(s, c.root, RoleRoot); err != nil {
+		return fmt.Errorf("root: new root not signed by trusted root keys: %v", err)
+	}
+	if err := verifyRole(s, root, RoleRoot); err != nil {
+		return fmt.Errorf("root: %v", err)
+	}
+
+	c.root = root
+	c.rootBytes = b
+	c.rootUpdated = true
+	return nil
+}
+
+// checkVersion rejects metadata of role older than the trusted one.
+func (c *Client) checkVersion(role string, version int) error {
+	if trusted, ok := c.versions[role]; ok && version < trusted {
+		return fmt.Errorf("%s: remote version %d is older than trusted version %d", role, version, trusted)
+	}
+	return nil
+}
+
+func (c *Client) fetchSigned(role string, meta *FileMeta) (*Signed, []byte, error) {
+	size := int64(-1)
+	if meta != nil {
+		size = meta.Length
+	}
+	b, err := c.fetch(role, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching %s metadata: %v", role, err)
+	}
+	if meta != nil {
+		if err := CheckFileMeta(b, *meta); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", role, err)
+		}
+	}
+	var s Signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling %s metadata: %v", role, err)
+	}
+	return &s, b, nil
+}
+
+func decodeRoot(s *Signed) (*Root, error) {
+	var root Root
+	if err := decodeSigned(s, "Root", &root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+func decodeSigned(s *Signed, typ string, v interface{}) error {
+	var header struct {
+		Type string `json:"_type"`
+	}
+	if err := json.Unmarshal(s.Signed, &header); err != nil {
+		return err
+	}
+	if header.Type != typ {
+		return fmt.Errorf("unexpected metadata type %q, expected %q", header.Type, typ)
+	}
+	return json.Unmarshal(s.Signed, v)
+}
+
+func verifyRole(s *Signed, root *Root, role string) error {
+	r, ok := root.Roles[role]
+	if !ok {
+		return fmt.Errorf("role %q not found in root", role)
+	}
+	return VerifySignatures(s, root.Keys, r.KeyIDs, r.Threshold)
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuf
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// Key types and signature methods used by Notary.
+const (
+	KeyTypeED25519   = "ed25519"
+	KeyTypeECDSA     = "ecdsa"
+	KeyTypeECDSAX509 = "ecdsa-x509"
+	KeyTypeRSA       = "rsa"
+	KeyTypeRSAX509   = "rsa-x509"
+
+	MethodED25519    = "ed25519"
+	MethodECDSA      = "ecdsa"
+	MethodRSAPSS     = "rsapss"
+	MethodRSAPKCS1v5 = "rsapkcs1v15"
+)
+
+// ID returns the ID of the key, the hex SHA-256 of its canonical JSON form
+// without private part, as Notary computes it.
+func (k Key) ID() string {
+	var pub struct {
+		Type  string `json:"keytype"`
+		Value struct {
+			Private []byte `json:"private"`
+			Public  []byte `json:"public"`
+		} `json:"keyval"`
+	}
+	pub.Type = k.Type
+	pub.Value.Public = k.Value.Public
+	// the fields are in canonical order, and base64 needs no escaping
+	b, _ := json.Marshal(pub)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// publicKey decodes the public part of a TUF key.
+func publicKey(k Key) (crypto.PublicKey, error) {
+	switch k.Type {
+	case KeyTypeED25519:
+		if len(k.Value.Public) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key size %d", len(k.Value.Public))
+		}
+		return ed25519.PublicKey(k.Value.Public), nil
+	case KeyTypeECDSA, KeyTypeRSA:
+		return x509.ParsePKIXPublicKey(k.Value.Public)
+	case KeyTypeECDSAX509, KeyTypeRSAX509:
+		block, _ := pem.Decode(k.Value.Public)
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM certificate in %s key", k.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Type)
+}
+
+// verify checks a single signature of msg made with key.
+func verify(k Key, sig Signature, msg []byte) error {
+	pub, err := publicKey(k)
+	if err != nil {
+		return err
+	}
+
+	switch sig.Method {
+	case MethodED25519:
+		p, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("method %s doesn't match key type %s", sig.Method, k.Type)
+		}
+		if !ed25519.Verify(p, msg, sig.Signature) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+		return nil
+	case MethodECDSA:
+		p, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("method %s doesn't match key type %s", sig.Method, k.Type)
+		}
+		// Notary encodes ECDSA signatures as the concatenation of r and s,
+		// each padded to the size of the curve.
+		size := (p.Curve.Params().BitSize + 7) / 8
+		if len(sig.Signature) != 2*size {
+			return fmt.Errorf("invalid ecdsa signature length %d", len(sig.Signature))
+		}
+		r := new(big.Int).SetBytes(sig.Signature[:size])
+		s := new(big.Int).SetBytes(sig.Signature[size:])
+		digest := sha256.Sum256(msg)
+		if !ecdsa.Verify(p, digest[:], r, s) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+		return nil
+	case MethodRSAPSS, MethodRSAPKCS1v5:
+		p, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("method %s doesn't match key type %s", sig.Method, k.Type)
+		}
+		digest := sha256.Sum256(msg)
+		if sig.Method == MethodRSAPSS {
+			return rsa.VerifyPSS(p, crypto.SHA256, digest[:], sig.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(p, crypto.SHA256, digest[:], sig.Signature)
+	}
+	return fmt.Errorf("unsupported signature method %q", sig.Method)
+}
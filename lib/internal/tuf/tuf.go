@@ -0,0 +1,246 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tuf implements the subset of The Update Framework used by Docker
+// Content Trust (Notary v1) to resolve a tag to a trusted digest.
+//
+// Note: this package is an implementation detail and shouldn't be used outside
+// of docker2aci.
+package tuf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	RoleRoot      = "root"
+	RoleTargets   = "targets"
+	RoleSnapshot  = "snapshot"
+	RoleTimestamp = "timestamp"
+	RoleReleases  = "targets/releases"
+
+	// MaxMetadataSize bounds the size of metadata files whose length isn't
+	// known in advance (root and timestamp).
+	MaxMetadataSize = 5 << 20
+)
+
+var (
+	ErrExpired          = errors.New("metadata has expired")
+	ErrThreshold        = errors.New("not enough valid signatures")
+	ErrTargetNotFound   = errors.New("target not found")
+	ErrMetadataMismatch = errors.New("metadata doesn't match the hashes in the referring role")
+)
+
+// Signed is the envelope every TUF metadata file is wrapped in.
+type Signed struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []Signature     `json:"signatures"`
+}
+
+// Signature is a signature over the canonical form of Signed.Signed.
+type Signature struct {
+	KeyID     string `json:"keyid"`
+	Method    string `json:"method"`
+	Signature []byte `json:"sig"`
+}
+
+// Key is a public key as stored in root metadata and delegations.
+type Key struct {
+	Type  string `json:"keytype"`
+	Value struct {
+		Public []byte `json:"public"`
+	} `json:"keyval"`
+}
+
+// RootRole lists the keys that can sign a top level role.
+type RootRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// DelegationRole is a RootRole that is delegated by the targets role.
+type DelegationRole struct {
+	RootRole
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+// FileMeta describes the length and hashes of a file.
+type FileMeta struct {
+	Length int64             `json:"length"`
+	Hashes map[string][]byte `json:"hashes"`
+}
+
+// Root is the signed portion of root.json.
+type Root struct {
+	Type    string              `json:"_type"`
+	Version int                 `json:"version"`
+	Expires time.Time           `json:"expires"`
+	Keys    map[string]Key      `json:"keys"`
+	Roles   map[string]RootRole `json:"roles"`
+}
+
+// Targets is the signed portion of targets.json and of its delegations.
+type Targets struct {
+	Type        string              `json:"_type"`
+	Version     int                 `json:"version"`
+	Expires     time.Time           `json:"expires"`
+	Targets     map[string]FileMeta `json:"targets"`
+	Delegations struct {
+		Keys  map[string]Key   `json:"keys"`
+		Roles []DelegationRole `json:"roles"`
+	} `json:"delegations"`
+}
+
+// Snapshot is the signed portion of snapshot.json.
+type Snapshot struct {
+	Type    string              `json:"_type"`
+	Version int                 `json:"version"`
+	Expires time.Time           `json:"expires"`
+	Meta    map[string]FileMeta `json:"meta"`
+}
+
+// Timestamp is the signed portion of timestamp.json.
+type Timestamp struct {
+	Type    string              `json:"_type"`
+	Version int                 `json:"version"`
+	Expires time.Time           `json:"expires"`
+	Meta    map[string]FileMeta `json:"meta"`
+}
+
+// CanonicalJSON returns the canonical encoding of a JSON document: object
+// keys sorted, no insignificant whitespace and no HTML escaping. Signatures
+// are computed over this form.
+func CanonicalJSON(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// VerifySignatures checks that s carries at least threshold valid signatures
+// from distinct keys among keyIDs. Only the keys whose ID is their own hash
+// are used.
+func VerifySignatures(s *Signed, keys map[string]Key, keyIDs []string, threshold int) error {
+	if threshold < 1 {
+		return fmt.Errorf("invalid threshold %d", threshold)
+	}
+	msg, err := CanonicalJSON(s.Signed)
+	if err != nil {
+		return fmt.Errorf("error canonicalizing metadata: %v", err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, id := range keyIDs {
+		allowed[id] = true
+	}
+
+	valid := make(map[string]bool)
+	for _, sig := range s.Signatures {
+		if !allowed[sig.KeyID] || valid[sig.KeyID] {
+			continue
+		}
+		// a key listed under the ID of another one doesn't count for it
+		key, ok := keys[sig.KeyID]
+		if !ok || key.ID() != sig.KeyID {
+			continue
+		}
+		if err := verify(key, sig, msg); err != nil {
+			continue
+		}
+		valid[sig.KeyID] = true
+	}
+
+	if len(valid) < threshold {
+		return ErrThreshold
+	}
+	return nil
+}
+
+// CheckExpiry returns ErrExpired if expires is before now.
+func CheckExpiry(role string, expires, now time.Time) error {
+	if expires.Before(now) {
+		return fmt.Errorf("%s: %v (expired %s)", role, ErrExpired, expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckFileMeta verifies b against the length and hashes in meta. At least
+// one hash algorithm known to us must be present.
+func CheckFileMeta(b []byte, meta FileMeta) error {
+	if meta.Length != 0 && int64(len(b)) != meta.Length {
+		return ErrMetadataMismatch
+	}
+	checked := false
+	for alg, expected := range meta.Hashes {
+		var sum []byte
+		switch alg {
+		case "sha256":
+			h := sha256.Sum256(b)
+			sum = h[:]
+		case "sha512":
+			h := sha512.Sum512(b)
+			sum = h[:]
+		default:
+			continue
+		}
+		if !bytes.Equal(sum, expected) {
+			return ErrMetadataMismatch
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no supported hash algorithm in file meta")
+	}
+	return nil
+}
+
+// Digest returns the OCI digest ("sha256:<hex>") of a target.
+func (m FileMeta) Digest() (string, error) {
+	h, ok := m.Hashes["sha256"]
+	if !ok || len(h) != sha256.Size {
+		return "", fmt.Errorf("target has no valid sha256 hash")
+	}
+	return "sha256:" + hex.EncodeToString(h), nil
+}
+
+// AllowsTarget says whether the delegation is trusted for the target called
+// name. Notary scopes delegations by path prefixes, "" standing for all the
+// targets.
+func (d DelegationRole) AllowsTarget(name string) bool {
+	for _, p := range d.Paths {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
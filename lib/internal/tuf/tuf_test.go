@@ -0,0 +1,267 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`{"b": 1, "a": {"d": [1, 2], "c": null}}`, `{"a":{"c":null,"d":[1,2]},"b":1}`},
+		{`{"n": 12345678901234567890}`, `{"n":12345678901234567890}`},
+		{`{"s": "<a&b>"}`, `{"s":"<a&b>"}`},
+	}
+
+	for i, tt := range tests {
+		out, err := CanonicalJSON([]byte(tt.in))
+		if err != nil {
+			t.Errorf("#%d unexpected error: %v", i, err)
+			continue
+		}
+		if string(out) != tt.out {
+			t.Errorf("#%d expected %s, got %s", i, tt.out, out)
+		}
+	}
+}
+
+type testKey struct {
+	id   string
+	key  Key
+	priv ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T) testKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	k := Key{Type: KeyTypeED25519}
+	k.Value.Public = pub
+	return testKey{id: k.ID(), key: k, priv: priv}
+}
+
+func sign(t *testing.T, v interface{}, keys ...testKey) *Signed {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	msg, err := CanonicalJSON(raw)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := &Signed{Signed: msg}
+	for _, k := range keys {
+		s.Signatures = append(s.Signatures, Signature{
+			KeyID:     k.id,
+			Method:    MethodED25519,
+			Signature: ed25519.Sign(k.priv, msg),
+		})
+	}
+	return s
+}
+
+func TestVerifySignatures(t *testing.T) {
+	k1, k2, k3 := newTestKey(t), newTestKey(t), newTestKey(t)
+	keys := map[string]Key{k1.id: k1.key, k2.id: k2.key, k3.id: k3.key}
+	payload := map[string]string{"_type": "Targets"}
+	// k1 listed and signing under the ID of k2
+	impostor := testKey{id: k2.id, key: k1.key, priv: k1.priv}
+	impostorKeys := map[string]Key{k2.id: k1.key}
+
+	tests := []struct {
+		s         *Signed
+		keys      map[string]Key
+		keyIDs    []string
+		threshold int
+		ok        bool
+	}{
+		{sign(t, payload, k1), keys, []string{k1.id}, 1, true},
+		{sign(t, payload, k1), keys, []string{k2.id}, 1, false},
+		{sign(t, payload, k1, k2), keys, []string{k1.id, k2.id}, 2, true},
+		// the same key signing twice only counts once
+		{sign(t, payload, k1, k1), keys, []string{k1.id, k2.id}, 2, false},
+		{sign(t, payload, k1, k3), keys, []string{k1.id, k2.id}, 2, false},
+		{sign(t, payload), keys, []string{k1.id}, 0, false},
+		// the key ID isn't the hash of the key
+		{sign(t, payload, impostor), impostorKeys, []string{k2.id}, 1, false},
+	}
+
+	for i, tt := range tests {
+		err := VerifySignatures(tt.s, tt.keys, tt.keyIDs, tt.threshold)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d expected ok=%v, got %v", i, tt.ok, err)
+		}
+	}
+
+	// tampering with the payload invalidates the signature
+	s := sign(t, payload, k1)
+	s.Signed = json.RawMessage(`{"_type":"Root"}`)
+	if err := VerifySignatures(s, keys, []string{k1.id}, 1); err != ErrThreshold {
+		t.Errorf("expected %v on tampered payload, got %v", ErrThreshold, err)
+	}
+}
+
+func TestCheckFileMeta(t *testing.T) {
+	b := []byte("metadata")
+	sum := sha256.Sum256(b)
+
+	tests := []struct {
+		meta FileMeta
+		ok   bool
+	}{
+		{FileMeta{Length: 8, Hashes: map[string][]byte{"sha256": sum[:]}}, true},
+		{FileMeta{Hashes: map[string][]byte{"sha256": sum[:]}}, true},
+		{FileMeta{Length: 9, Hashes: map[string][]byte{"sha256": sum[:]}}, false},
+		{FileMeta{Length: 8, Hashes: map[string][]byte{"sha256": sum[1:]}}, false},
+		{FileMeta{Length: 8, Hashes: map[string][]byte{"md5": sum[:]}}, false},
+	}
+
+	for i, tt := range tests {
+		err := CheckFileMeta(b, tt.meta)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d expected ok=%v, got %v", i, tt.ok, err)
+		}
+	}
+}
+
+func TestRootRotation(t *testing.T) {
+	oldKey, newKey, other := newTestKey(t), newTestKey(t), newTestKey(t)
+	expires := time.Now().Add(time.Hour)
+
+	root := func(version int, k testKey) Root {
+		return Root{
+			Type:    "Root",
+			Version: version,
+			Expires: expires,
+			Keys:    map[string]Key{k.id: k.key},
+			Roles:   map[string]RootRole{RoleRoot: {KeyIDs: []string{k.id}, Threshold: 1}},
+		}
+	}
+	marshal := func(s *Signed) []byte {
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return b
+	}
+
+	trusted := marshal(sign(t, root(1, oldKey), oldKey))
+
+	tests := []struct {
+		remote  []byte
+		updated bool
+		ok      bool
+	}{
+		{trusted, false, true},
+		// cross-signed by the trusted and the new keys
+		{marshal(sign(t, root(2, newKey), oldKey, newKey)), true, true},
+		// not signed by the trusted keys
+		{marshal(sign(t, root(2, newKey), newKey)), false, false},
+		// not signed by its own keys
+		{marshal(sign(t, root(2, newKey), oldKey, other)), false, false},
+		// rollback
+		{marshal(sign(t, root(0, oldKey), oldKey)), false, false},
+	}
+
+	for i, tt := range tests {
+		remote := tt.remote
+		c, err := NewClient(trusted, func(role string, size int64) ([]byte, error) {
+			return remote, nil
+		})
+		if err != nil {
+			t.Fatalf("#%d unexpected error: %v", i, err)
+		}
+		err = c.updateRoot()
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d expected ok=%v, got %v", i, tt.ok, err)
+		}
+		if _, updated := c.Root(); updated != tt.updated {
+			t.Errorf("#%d expected updated=%v, got %v", i, tt.updated, updated)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	k := newTestKey(t)
+	expires := time.Now().Add(time.Hour)
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(sign(t, v, k))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return b
+	}
+	fileMeta := func(b []byte) FileMeta {
+		sum := sha256.Sum256(b)
+		return FileMeta{Length: int64(len(b)), Hashes: map[string][]byte{"sha256": sum[:]}}
+	}
+
+	roles := make(map[string]RootRole)
+	for _, role := range []string{RoleRoot, RoleTargets, RoleSnapshot, RoleTimestamp} {
+		roles[role] = RootRole{KeyIDs: []string{k.id}, Threshold: 1}
+	}
+	root := marshal(Root{Type: "Root", Version: 1, Expires: expires, Keys: map[string]Key{k.id: k.key}, Roles: roles})
+	targets := marshal(Targets{Type: "Targets", Version: 1, Expires: expires, Targets: map[string]FileMeta{"latest": fileMeta([]byte("manifest"))}})
+	// repo returns the metadata of a repository at version
+	repo := func(version int) map[string][]byte {
+		snap := marshal(Snapshot{Type: "Snapshot", Version: version, Expires: expires, Meta: map[string]FileMeta{RoleTargets: fileMeta(targets)}})
+		ts := marshal(Timestamp{Type: "Timestamp", Version: version, Expires: expires, Meta: map[string]FileMeta{RoleSnapshot: fileMeta(snap)}})
+		return map[string][]byte{RoleRoot: root, RoleTargets: targets, RoleSnapshot: snap, RoleTimestamp: ts}
+	}
+	resolve := func(remote map[string][]byte, trusted map[string][]byte) (*Client, error) {
+		c, err := NewClient(root, func(role string, size int64) ([]byte, error) {
+			return remote[role], nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for role, b := range trusted {
+			if err := c.TrustMetadata(role, b); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		_, err = c.Resolve("latest")
+		return c, err
+	}
+
+	v1, v2 := repo(1), repo(2)
+	c, err := resolve(v2, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trusted := map[string][]byte{
+		RoleTimestamp: c.Metadata(RoleTimestamp),
+		RoleSnapshot:  c.Metadata(RoleSnapshot),
+	}
+
+	if _, err := resolve(v2, trusted); err != nil {
+		t.Errorf("unexpected error resolving the trusted version: %v", err)
+	}
+	if _, err := resolve(v1, trusted); err == nil {
+		t.Errorf("expected error resolving an older version")
+	}
+	// an older snapshot is rejected even behind an up to date timestamp
+	if _, err := resolve(v1, map[string][]byte{RoleSnapshot: trusted[RoleSnapshot]}); err == nil {
+		t.Errorf("expected error resolving an older snapshot")
+	}
+}
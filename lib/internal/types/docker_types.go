@@ -63,6 +63,23 @@ type DockerImageConfig struct {
 	MacAddress      string
 	OnBuild         []string
 	Labels          map[string]string
+	StopSignal      string        `json:",omitempty"`
+	Healthcheck     *HealthConfig `json:",omitempty"`
+	Shell           []string      `json:",omitempty"`
+}
+
+// HealthConfig holds the HEALTHCHECK of an image.
+// Taken from upstream Docker.
+type HealthConfig struct {
+	// Test is the test to perform: [] inherits it, ["NONE"] disables it,
+	// ["CMD", args...] execs args and ["CMD-SHELL", command] runs command
+	// with the shell of the image.
+	Test []string `json:",omitempty"`
+
+	Interval    time.Duration `json:",omitempty"` // time between checks
+	Timeout     time.Duration `json:",omitempty"` // time to wait before a check is considered hung
+	StartPeriod time.Duration `json:",omitempty"` // time for the container to start before retries are counted
+	Retries     int           `json:",omitempty"` // consecutive failures needed to be unhealthy
 }
 
 // DockerAuthConfigOld represents the deprecated ~/.dockercfg auth
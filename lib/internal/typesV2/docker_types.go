@@ -17,6 +17,7 @@ package typesV2
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/appc/docker2aci/lib/common"
 )
@@ -41,6 +42,39 @@ type ImageManifestDigest struct {
 	Digest    string `json:"digest"`
 }
 
+// ImageIndex is an OCI image index or a Docker manifest list.
+type ImageIndex struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType,omitempty"`
+	Manifests     []*ImageDescriptor `json:"manifests"`
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+}
+
+// ImageDescriptor references a manifest or an index from an ImageIndex.
+type ImageDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	Platform    *ImagePlatform    `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ImagePlatform is the platform an image in an ImageIndex runs on.
+type ImagePlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// SavedImageManifest is an entry of the manifest.json file written by "docker
+// save". Paths are relative to the root of the archive and layers are ordered
+// from the base one to the top one.
+type SavedImageManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
 func (im *ImageManifest) String() string {
 	manblob, err := json.Marshal(im)
 	if err != nil {
@@ -85,12 +119,32 @@ type ImageConfigConfig struct {
 	Memory       int                 `json:"Memory"`
 	MemorySwap   int                 `json:"MemorySwap"`
 	CpuShares    int                 `json:"CpuShares"`
+	Cpuset       string              `json:"Cpuset"`
 	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
 	Env          []string            `json:"Env"`
 	Entrypoint   []string            `json:"Entrypoint"`
 	Cmd          []string            `json:"Cmd"`
 	Volumes      map[string]struct{} `json:"Volumes"`
 	WorkingDir   string              `json:"WorkingDir"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	OnBuild      []string            `json:"OnBuild,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"`
+	Shell        []string            `json:"Shell,omitempty"`
+}
+
+// HealthConfig holds the HEALTHCHECK of an image.
+// Taken from upstream Docker.
+type HealthConfig struct {
+	// Test is the test to perform: [] inherits it, ["NONE"] disables it,
+	// ["CMD", args...] execs args and ["CMD-SHELL", command] runs command
+	// with the shell of the image.
+	Test []string `json:"Test,omitempty"`
+
+	Interval    time.Duration `json:"Interval,omitempty"`    // time between checks
+	Timeout     time.Duration `json:"Timeout,omitempty"`     // time to wait before a check is considered hung
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"` // time for the container to start before retries are counted
+	Retries     int           `json:"Retries,omitempty"`     // consecutive failures needed to be unhealthy
 }
 
 type ImageConfigRootFS struct {
@@ -0,0 +1,284 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/util"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/pkg/acirenderer"
+	"github.com/appc/spec/schema"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+// shebangMax limits what is read of an executable to find its interpreter.
+const shebangMax = 256
+
+// LintSeverity says how bad a LintProblem is.
+type LintSeverity string
+
+const (
+	// LintError is a problem preventing the app from running.
+	LintError LintSeverity = "error"
+	// LintWarning is a problem that may make the app misbehave.
+	LintWarning LintSeverity = "warning"
+)
+
+// LintProblem is a problem found in a converted image by LintACIs.
+type LintProblem struct {
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+}
+
+// lintReporter records a problem found by a check.
+type lintReporter func(severity LintSeverity, format string, args ...interface{})
+
+// LintACIs checks that the app of a converted image can run, which valid ACIs
+// don't guarantee: its executable, user, group and working directory must
+// exist in the rendered rootfs, and its mount points can't be files.
+// aciPaths are ordered from the base layer to the top one, like the
+// conversion functions return them, and the app of the last one is checked.
+func LintACIs(aciPaths []string) ([]LintProblem, error) {
+	if len(aciPaths) == 0 {
+		return nil, fmt.Errorf("no ACI to lint")
+	}
+	conversionStore := newConversionStore()
+	var images acirenderer.Images
+	for i, p := range aciPaths {
+		key, err := conversionStore.WriteACI(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ACI %s: %v", p, err)
+		}
+		im, err := conversionStore.GetImageManifest(key)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, acirenderer.Image{Im: im, Key: key, Level: uint16(len(aciPaths) - 1 - i)})
+	}
+	// acirenderer expects images in order from upper to base layer
+	images = util.ReverseImages(images)
+
+	return lintImage(images[0].Im, newFlattenedRootfs(images, conversionStore))
+}
+
+// lint lints the generated ACIs, according to the lint policy of the
+// conversion.
+func (c *converter) lint(aciPaths []string) error {
+	if c.config.Lint == common.LintNone {
+		return nil
+	}
+	problems, err := LintACIs(aciPaths)
+	if err != nil {
+		return fmt.Errorf("error linting image: %v", err)
+	}
+	var errors []string
+	for _, p := range problems {
+		if p.Severity == LintError && c.config.Lint == common.LintFail {
+			errors = append(errors, p.Message)
+			continue
+		}
+		progress.Warnf(c.config.Progress, "lint %s", p)
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("the converted image can't run: %s", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+// lintImage checks the app of manifest against the files fs.
+func lintImage(manifest *schema.ImageManifest, fs *flattenedRootfs) ([]LintProblem, error) {
+	var problems []LintProblem
+	report := func(severity LintSeverity, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	app := manifest.App
+	if app == nil {
+		report(LintWarning, "the image has no app to run")
+		return problems, nil
+	}
+	if err := fs.index(); err != nil {
+		return nil, err
+	}
+	checks := []func(*appctypes.App, *flattenedRootfs, lintReporter) error{
+		lintExec,
+		lintUser,
+		lintWorkingDirectory,
+		lintMountPoints,
+	}
+	for _, check := range checks {
+		if err := check(app, fs, report); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// lintExec checks that the executable of app, and its interpreter if it's a
+// script, exist and can be executed.
+func lintExec(app *appctypes.App, fs *flattenedRootfs, report lintReporter) error {
+	if len(app.Exec) == 0 {
+		report(LintError, "the app has no executable")
+		return nil
+	}
+	exec := app.Exec[0]
+	if !path.IsAbs(exec) {
+		report(LintError, "executable %q isn't an absolute path", exec)
+		return nil
+	}
+	if !lintExecutable(fs, "executable", exec, report) {
+		return nil
+	}
+
+	head, err := fs.readFileHead(exec, shebangMax)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", exec, err)
+	}
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return nil
+	}
+	line := string(head[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		report(LintError, "executable %q has an empty interpreter line", exec)
+		return nil
+	}
+	lintExecutable(fs, fmt.Sprintf("interpreter of %q", exec), fields[0], report)
+	return nil
+}
+
+// lintExecutable reports what keeps the file at p, described by what, from
+// being executed, and says whether it can be.
+func lintExecutable(fs *flattenedRootfs, what, p string, report lintReporter) bool {
+	_, hdr, err := fs.stat(p)
+	switch {
+	case os.IsNotExist(err):
+		report(LintError, "%s %q not found", what, p)
+	case err != nil:
+		report(LintError, "%s %q: %v", what, p, err)
+	case hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA:
+		report(LintError, "%s %q isn't a regular file", what, p)
+	case !isExecutable(hdr):
+		report(LintError, "%s %q isn't executable", what, p)
+	default:
+		return true
+	}
+	return false
+}
+
+// lintUser checks that the user and group of app exist. Names must be found
+// in /etc/passwd and /etc/group, while ids only need to for other users than
+// root.
+func lintUser(app *appctypes.App, fs *flattenedRootfs, report lintReporter) error {
+	users, err := readPasswd(fs)
+	if err != nil {
+		return err
+	}
+	groups, err := readGroup(fs)
+	if err != nil {
+		return err
+	}
+
+	if app.User == "" {
+		report(LintError, "the app has no user")
+	} else if uid, err := strconv.Atoi(app.User); err != nil {
+		found := false
+		for _, u := range users {
+			found = found || u.name == app.User
+		}
+		if !found {
+			report(LintError, "user %q not found in %s", app.User, passwdFile)
+		}
+	} else if uid != 0 {
+		found := false
+		for _, u := range users {
+			found = found || u.uid == uid
+		}
+		if !found {
+			report(LintWarning, "uid %d not found in %s", uid, passwdFile)
+		}
+	}
+
+	if app.Group == "" {
+		report(LintError, "the app has no group")
+	} else if gid, err := strconv.Atoi(app.Group); err != nil {
+		found := false
+		for _, g := range groups {
+			found = found || g.name == app.Group
+		}
+		if !found {
+			report(LintError, "group %q not found in %s", app.Group, groupFile)
+		}
+	} else if gid != 0 {
+		found := false
+		for _, g := range groups {
+			found = found || g.gid == gid
+		}
+		if !found {
+			report(LintWarning, "gid %d not found in %s", gid, groupFile)
+		}
+	}
+	return nil
+}
+
+// lintWorkingDirectory checks that the working directory of app is a
+// directory.
+func lintWorkingDirectory(app *appctypes.App, fs *flattenedRootfs, report lintReporter) error {
+	wd := app.WorkingDirectory
+	if wd == "" {
+		return nil
+	}
+	_, hdr, err := fs.stat(wd)
+	switch {
+	case os.IsNotExist(err):
+		report(LintError, "working directory %q not found", wd)
+	case err != nil:
+		report(LintError, "working directory %q: %v", wd, err)
+	case hdr.Typeflag != tar.TypeDir:
+		report(LintError, "working directory %q isn't a directory", wd)
+	}
+	return nil
+}
+
+// lintMountPoints checks that the mount points of app are directories when
+// they exist, as runtimes create the missing ones.
+func lintMountPoints(app *appctypes.App, fs *flattenedRootfs, report lintReporter) error {
+	for _, mp := range app.MountPoints {
+		_, hdr, err := fs.stat(mp.Path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			report(LintError, "mount point %q: %v", mp.Name, err)
+		case hdr.Typeflag != tar.TypeDir:
+			report(LintError, "path %q of mount point %q isn't a directory", mp.Path, mp.Name)
+		}
+	}
+	return nil
+}
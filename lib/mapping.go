@@ -0,0 +1,204 @@
+// Copyright 2015 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/spec/schema"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+// metadataEntries are the entries of a kind of metadata, in order.
+type metadataEntries struct {
+	names  []string
+	values map[string]string
+}
+
+func newMetadataEntries() *metadataEntries {
+	return &metadataEntries{values: make(map[string]string)}
+}
+
+func (e *metadataEntries) set(name, value string) {
+	if _, ok := e.values[name]; !ok {
+		e.names = append(e.names, name)
+	}
+	e.values[name] = value
+}
+
+func (e *metadataEntries) remove(name string) {
+	if _, ok := e.values[name]; !ok {
+		return
+	}
+	delete(e.values, name)
+	for i, n := range e.names {
+		if n == name {
+			e.names = append(e.names[:i], e.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// applyMapping applies the rules of mapping to the labels, annotations and
+// app labels of manifest, the manifest of the image referenced by dockerURL.
+func applyMapping(manifest *schema.ImageManifest, mapping *common.MetadataMapping, dockerURL common.ParsedDockerURL) error {
+	if mapping == nil || len(mapping.Rules) == 0 {
+		return nil
+	}
+	// mappings built in code aren't validated by LoadMetadataMapping
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+
+	entries := map[string]*metadataEntries{
+		common.MetadataLabel:      newMetadataEntries(),
+		common.MetadataAnnotation: newMetadataEntries(),
+		common.MetadataUserLabel:  newMetadataEntries(),
+	}
+	for _, l := range manifest.Labels {
+		entries[common.MetadataLabel].set(l.Name.String(), l.Value)
+	}
+	for _, a := range manifest.Annotations {
+		entries[common.MetadataAnnotation].set(a.Name.String(), a.Value)
+	}
+	if manifest.App != nil {
+		var names []string
+		for name := range manifest.App.UserLabels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entries[common.MetadataUserLabel].set(name, manifest.App.UserLabels[name])
+		}
+	}
+
+	values := common.MetadataValues{
+		OriginalName: dockerURL.OriginalName,
+		Registry:     dockerURL.IndexURL,
+		Repository:   dockerURL.ImageName,
+		Tag:          dockerURL.Tag,
+		Digest:       dockerURL.Digest,
+	}
+	for i, r := range mapping.Rules {
+		if err := applyRule(r, entries, values); err != nil {
+			return fmt.Errorf("error applying metadata rule %d: %v", i, err)
+		}
+	}
+
+	labels := appctypes.Labels{}
+	for _, name := range entries[common.MetadataLabel].names {
+		id, err := appctypes.NewACIdentifier(name)
+		if err != nil {
+			return fmt.Errorf("invalid label name %q: %v", name, err)
+		}
+		labels = append(labels, appctypes.Label{Name: *id, Value: entries[common.MetadataLabel].values[name]})
+	}
+	var annotations appctypes.Annotations
+	for _, name := range entries[common.MetadataAnnotation].names {
+		id, err := appctypes.NewACIdentifier(name)
+		if err != nil {
+			return fmt.Errorf("invalid annotation name %q: %v", name, err)
+		}
+		annotations = append(annotations, appctypes.Annotation{Name: *id, Value: entries[common.MetadataAnnotation].values[name]})
+	}
+	manifest.Labels = labels
+	manifest.Annotations = annotations
+
+	userLabels := entries[common.MetadataUserLabel]
+	if len(userLabels.names) > 0 && manifest.App == nil {
+		return fmt.Errorf("image has no app to set labels of")
+	}
+	if manifest.App != nil {
+		var m map[string]string
+		if len(userLabels.names) > 0 {
+			m = userLabels.values
+		}
+		manifest.App.UserLabels = m
+	}
+	return nil
+}
+
+func applyRule(r common.MetadataRule, entries map[string]*metadataEntries, values common.MetadataValues) error {
+	tmpl, err := r.Template()
+	if err != nil {
+		return err
+	}
+	value := func(v common.MetadataValues) (string, error) {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, v); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	if r.Action == common.MetadataSet {
+		kind, name := common.SplitMetadataName(r.To)
+		v, err := value(values)
+		if err != nil {
+			return err
+		}
+		to, ok := entries[kind]
+		if !ok {
+			return fmt.Errorf("unknown metadata kind %q", kind)
+		}
+		to.set(name, v)
+		return nil
+	}
+
+	kind, pattern := common.SplitMetadataName(r.Match)
+	from, ok := entries[kind]
+	if !ok {
+		return fmt.Errorf("unknown metadata kind %q", kind)
+	}
+	prefix := strings.TrimSuffix(pattern, "*")
+	wildcard := prefix != pattern
+	toKind, toPattern := common.SplitMetadataName(r.To)
+	if toKind == "" {
+		toKind = kind
+	}
+	to, ok := entries[toKind]
+	if !ok {
+		return fmt.Errorf("unknown metadata kind %q", toKind)
+	}
+
+	// copy the names as the rule may change them
+	for _, name := range append([]string(nil), from.names...) {
+		if name != pattern && !(wildcard && strings.HasPrefix(name, prefix)) {
+			continue
+		}
+		v := from.values[name]
+		if r.Action == common.MetadataDrop {
+			from.remove(name)
+			continue
+		}
+
+		target := strings.Replace(toPattern, "*", strings.TrimPrefix(name, prefix), 1)
+		values.Name = name
+		values.Value = v
+		newValue, err := value(values)
+		if err != nil {
+			return err
+		}
+		if r.Action == common.MetadataRename {
+			from.remove(name)
+		}
+		to.set(target, newValue)
+	}
+	return nil
+}
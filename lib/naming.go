@@ -0,0 +1,218 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal"
+	"github.com/appc/spec/schema"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+// namingValues returns the values of the naming templates for the image
+// referenced by dockerURL, whose upper layer has the manifest top. The
+// layer values are left to the caller.
+func namingValues(top *schema.ImageManifest, dockerURL common.ParsedDockerURL) common.NamingValues {
+	v := common.NamingValues{
+		Registry:   dockerURL.IndexURL,
+		Repository: dockerURL.ImageName,
+		Tag:        dockerURL.Tag,
+		Digest:     dockerURL.Digest,
+		Top:        true,
+	}
+	if v.Digest == "" {
+		v.Digest, _ = top.Annotations.Get(common.AppcDockerManifestHash)
+	}
+	v.OS, _ = top.GetLabel("os")
+	v.Arch, _ = top.GetLabel("arch")
+	return v
+}
+
+// layerValues returns v for the layer of index i, from the base one, with
+// the manifest manifest.
+func layerValues(v common.NamingValues, i int, manifest *schema.ImageManifest, top bool) common.NamingValues {
+	v.Layer = strconv.Itoa(i)
+	name := manifest.Name.String()
+	v.LayerID = name[strings.LastIndex(name, "-")+1:]
+	v.Top = top
+	return v
+}
+
+// applyNaming sets the name, the version label and the extra labels of
+// manifest from the templates of naming.
+func applyNaming(manifest *schema.ImageManifest, naming *common.NamingTemplates, v common.NamingValues) error {
+	if naming == nil {
+		return nil
+	}
+
+	name, err := naming.Execute("name", v)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		id, err := appctypes.NewACIdentifier(name)
+		if err != nil {
+			return fmt.Errorf("invalid ACI name %q: %v", name, err)
+		}
+		manifest.Name = *id
+	}
+
+	labels := append(appctypes.Labels(nil), manifest.Labels...)
+	setLabel := func(name, value string) error {
+		id, err := appctypes.NewACIdentifier(name)
+		if err != nil {
+			return fmt.Errorf("invalid label name %q: %v", name, err)
+		}
+		for i, l := range labels {
+			if l.Name == *id {
+				labels[i].Value = value
+				return nil
+			}
+		}
+		labels = append(labels, appctypes.Label{Name: *id, Value: value})
+		return nil
+	}
+	if naming.Version != "" {
+		version, err := naming.Execute("version", v)
+		if err != nil {
+			return err
+		}
+		if err := setLabel("version", version); err != nil {
+			return err
+		}
+	}
+	for _, l := range naming.LabelNames() {
+		value, err := naming.Execute(l, v)
+		if err != nil {
+			return err
+		}
+		if err := setLabel(l, value); err != nil {
+			return err
+		}
+	}
+	manifest.Labels = labels
+	return nil
+}
+
+// namingPath returns the path of the ACI file named name in outputDir from
+// the path template of naming, or defaultPath if there's none.
+func namingPath(naming *common.NamingTemplates, v common.NamingValues, name string, outputDir string, defaultPath string) (string, error) {
+	if naming == nil || naming.Path == "" {
+		return defaultPath, nil
+	}
+	v.Name = name
+	p, err := naming.Execute("path", v)
+	if err != nil {
+		return "", err
+	}
+	p, err = common.CleanOutputPath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(outputDir, p), nil
+}
+
+// nameLayers applies the naming templates to the layers at aciLayerPaths,
+// ordered from the base layer to the upper one, with the manifests
+// aciManifests. The dependencies of the layers follow their new names. The
+// reused layers, already named for another image, are left as they are. It
+// returns the new paths of the layers.
+func (c *converter) nameLayers(aciLayerPaths []string, aciManifests []*schema.ImageManifest, reused []bool, compression common.Compression, dockerURL common.ParsedDockerURL) ([]string, error) {
+	naming := c.config.Naming
+	if naming == nil {
+		return aciLayerPaths, nil
+	}
+
+	v := namingValues(aciManifests[len(aciManifests)-1], dockerURL)
+	values := make([]common.NamingValues, len(aciManifests))
+	manifests := make([]schema.ImageManifest, len(aciManifests))
+	renamed := make(map[appctypes.ACIdentifier]int)
+	for i, im := range aciManifests {
+		values[i] = layerValues(v, i, im, i == len(aciManifests)-1)
+		manifests[i] = *im
+		renamed[im.Name] = i
+		if reused[i] {
+			continue
+		}
+		if err := applyNaming(&manifests[i], naming, values[i]); err != nil {
+			return nil, fmt.Errorf("error naming layer %d: %v", i, err)
+		}
+		for j := 0; j < i; j++ {
+			// dependencies are looked up by name, the labels of a
+			// lower layer may match the upper one
+			if manifests[j].Name == manifests[i].Name {
+				return nil, fmt.Errorf("layers %d and %d are both named %q", j, i, manifests[i].Name)
+			}
+		}
+	}
+
+	paths := make([]string, len(aciLayerPaths))
+	seen := make(map[string]int)
+	for i := range manifests {
+		manifest := &manifests[i]
+		if reused[i] {
+			seen[aciLayerPaths[i]] = i
+			paths[i] = aciLayerPaths[i]
+			continue
+		}
+		deps := append(appctypes.Dependencies(nil), manifest.Dependencies...)
+		for k, d := range deps {
+			if j, ok := renamed[d.ImageName]; ok {
+				deps[k].ImageName = manifests[j].Name
+				deps[k].Labels = append(appctypes.Labels(nil), manifests[j].Labels...)
+			}
+		}
+		manifest.Dependencies = deps
+
+		path, err := namingPath(naming, values[i], manifest.Name.String(), c.config.OutputDir, aciLayerPaths[i])
+		if err != nil {
+			return nil, fmt.Errorf("error naming layer %d: %v", i, err)
+		}
+		if j, ok := seen[path]; ok {
+			return nil, fmt.Errorf("layers %d and %d are both written to %q", j, i, path)
+		}
+		for j, p := range aciLayerPaths {
+			if j != i && p == path {
+				return nil, fmt.Errorf("layer %d would overwrite layer %d at %q", i, j, path)
+			}
+		}
+		seen[path] = i
+		paths[i] = path
+
+		if !reflect.DeepEqual(*manifest, *aciManifests[i]) {
+			if err := internal.RewriteManifest(aciLayerPaths[i], *manifest, compression); err != nil {
+				return nil, fmt.Errorf("error rewriting manifest: %v", err)
+			}
+			aciManifests[i] = manifest
+		}
+		if path != aciLayerPaths[i] {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.Rename(aciLayerPaths[i], path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/schema"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+var resourceAnnotations = []string{
+	common.AppcDockerMemory,
+	common.AppcDockerMemorySwap,
+	common.AppcDockerCPUShares,
+	common.AppcDockerCpuset,
+}
+
+// applyResources applies policy to the resource settings of the image, kept in
+// the annotations of manifest when it was generated.
+//
+// Honoring them sets the memory limit and the CPU shares. There's no isolator
+// for the swap limit, nor for the CPU pinning of a cpuset, which is only kept
+// in its annotation with a warning. Values the isolators can't take are left
+// out, with a warning.
+func applyResources(manifest *schema.ImageManifest, policy common.ResourcePolicy, reporter progress.Reporter) error {
+	switch policy {
+	case common.ResourcesAnnotate:
+		return nil
+	case common.ResourcesDrop:
+		var annotations appctypes.Annotations
+		for _, a := range manifest.Annotations {
+			if !isResourceAnnotation(a.Name.String()) {
+				annotations = append(annotations, a)
+			}
+		}
+		manifest.Annotations = annotations
+		return nil
+	case common.ResourcesHonor:
+	default:
+		return fmt.Errorf("unknown resource policy: %d", policy)
+	}
+
+	if manifest.App == nil {
+		return nil
+	}
+	var isolators appctypes.Isolators
+	if v, ok := manifest.Annotations.Get(common.AppcDockerMemory); ok {
+		if memory, err := strconv.ParseInt(v, 10, 64); err != nil || memory <= 0 {
+			progress.Warnf(reporter, "ignoring memory limit %q", v)
+		} else {
+			r, err := appctypes.NewResourceMemoryIsolator(v, v)
+			if err != nil {
+				return err
+			}
+			isolators = append(isolators, r.AsIsolator())
+		}
+	}
+	if v, ok := manifest.Annotations.Get(common.AppcDockerCPUShares); ok {
+		shares, err := strconv.Atoi(v)
+		if err != nil {
+			progress.Warnf(reporter, "ignoring CPU shares %q", v)
+		} else if l, err := appctypes.NewLinuxCPUShares(shares); err != nil {
+			progress.Warnf(reporter, "ignoring CPU shares: %v", err)
+		} else {
+			isolators = append(isolators, l.AsIsolator())
+		}
+	}
+	if v, ok := manifest.Annotations.Get(common.AppcDockerCpuset); ok {
+		progress.Warnf(reporter, "cpuset %q can't be enforced by an isolator, only keeping it in the %s annotation", v, common.AppcDockerCpuset)
+	}
+
+	for _, i := range isolators {
+		manifest.App.Isolators.ReplaceIsolatorsByName(i, []appctypes.ACIdentifier{i.Name})
+	}
+	return nil
+}
+
+func isResourceAnnotation(name string) bool {
+	for _, a := range resourceAnnotations {
+		if name == a {
+			return true
+		}
+	}
+	return false
+}
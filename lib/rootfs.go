@@ -0,0 +1,143 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/appc/docker2aci/lib/internal/backend/rootfs"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+)
+
+// RootfsConfig represents the configuration for converting plain rootfs
+// tarballs, which have no image metadata. The image config is read from
+// ConfigFile, if any, and the other fields override it.
+type RootfsConfig struct {
+	CommonConfig
+	DockerURL  string // name of the image, the file name by default, Syntax: "{docker registry URL}/{image name}:{tag}"
+	ConfigFile string // Docker or OCI image config, as JSON
+	Platform   string // platform of the image, "linux/{current arch}" by default, Syntax: "{os}/{arch}[/{variant}]"
+
+	Entrypoint   []string
+	Cmd          []string
+	Env          []string // environment variables, Syntax: "{name}={value}"
+	ExposedPorts []string // Syntax: "{port}[/{protocol}]"
+	WorkingDir   string
+	User         string
+}
+
+// ConvertRootfs generates an ACI image from a rootfs tarball, possibly
+// compressed, like the ones generated by "docker export", and an image config.
+// The image config is mapped to the ACI manifest like the config of a Docker
+// v2.2 image.
+//
+// It returns the list of generated ACI paths.
+func ConvertRootfs(rootfsFile string, config RootfsConfig) ([]string, error) {
+	config.initLogger()
+
+	imageConfig, err := config.imageConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(rootfsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	return (&converter{
+		backend:   rootfs.NewRootfsBackend(f, imageConfig, config.Debug, config.Progress),
+		dockerURL: config.DockerURL,
+		config:    config.CommonConfig,
+	}).convert()
+}
+
+// imageConfig reads the config file, if any, and applies the other options
+// to it.
+func (config *RootfsConfig) imageConfig() (*typesV2.ImageConfig, error) {
+	imageConfig := &typesV2.ImageConfig{}
+	if config.ConfigFile != "" {
+		b, err := ioutil.ReadFile(config.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading image config: %v", err)
+		}
+		if err := json.Unmarshal(b, imageConfig); err != nil {
+			return nil, fmt.Errorf("error unmarshaling image config: %v", err)
+		}
+	}
+	if imageConfig.Config == nil {
+		imageConfig.Config = &typesV2.ImageConfigConfig{}
+	}
+	c := imageConfig.Config
+
+	if config.Platform != "" {
+		parts := strings.Split(config.Platform, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", config.Platform)
+		}
+		imageConfig.OS, imageConfig.Architecture = parts[0], parts[1]
+	}
+	if imageConfig.OS == "" {
+		imageConfig.OS = "linux"
+	}
+	if imageConfig.Architecture == "" {
+		imageConfig.Architecture = runtime.GOARCH
+	}
+
+	if config.Entrypoint != nil {
+		c.Entrypoint = config.Entrypoint
+	}
+	if config.Cmd != nil {
+		c.Cmd = config.Cmd
+	}
+	for _, e := range config.Env {
+		c.Env = setEnv(c.Env, e)
+	}
+	for _, p := range config.ExposedPorts {
+		if !strings.Contains(p, "/") {
+			p += "/tcp"
+		}
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = make(map[string]struct{})
+		}
+		c.ExposedPorts[p] = struct{}{}
+	}
+	if config.WorkingDir != "" {
+		c.WorkingDir = config.WorkingDir
+	}
+	if config.User != "" {
+		c.User = config.User
+	}
+
+	return imageConfig, nil
+}
+
+// setEnv sets the variable in e, "{name}={value}", in env.
+func setEnv(env []string, e string) []string {
+	name := strings.SplitN(e, "=", 2)[0]
+	for i, v := range env {
+		if strings.SplitN(v, "=", 2)[0] == name {
+			env[i] = e
+			return env
+		}
+	}
+	return append(env, e)
+}
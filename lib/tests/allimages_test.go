@@ -0,0 +1,204 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	"github.com/appc/docker2aci/pkg/progress"
+)
+
+func TestConvertAllImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	saveDir := path.Join(tmpDir, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	arm64 := testImage(nil)
+	arm64.Config.Architecture = "arm64"
+	err = GenerateDockerSave(saveDir, []SavedImage{
+		{RepoTags: []string{"quay.io/coreos/foo:1.0", "quay.io/coreos/foo:latest"}, Image: testImage(nil)},
+		{RepoTags: []string{"bar:2.0"}, Image: arm64},
+		{Image: testImage(nil)},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saveTar := path.Join(tmpDir, "save.tar")
+	if err := TarDir(saveDir, saveTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	layoutDir := path.Join(tmpDir, "layout")
+	err = GenerateOCILayout(layoutDir, []OCIImage{
+		{Ref: "v1", Image: testImage(nil)},
+		{Ref: "v2", Image: testImage(nil)},
+		{Ref: "v2", Image: arm64},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the tags of an image, and the identical images of the layout, share
+	// the ACIs of the first one
+	tests := []struct {
+		file     string
+		filter   []string
+		expected map[string]string
+	}{
+		{
+			saveTar,
+			nil,
+			map[string]string{
+				"quay.io/coreos/foo:1.0":    "1.0",
+				"quay.io/coreos/foo:latest": "1.0",
+				"bar:2.0":                   "2.0",
+			},
+		},
+		{
+			saveTar,
+			[]string{"quay.io/coreos/*", "baz*"},
+			map[string]string{
+				"quay.io/coreos/foo:1.0":    "1.0",
+				"quay.io/coreos/foo:latest": "1.0",
+			},
+		},
+		{
+			layoutDir,
+			[]string{"*:v?"},
+			map[string]string{
+				"layout:v1": "v1",
+				"layout:v2": "v1",
+			},
+		},
+	}
+	squashed := docker2aci.CommonConfig{Squash: true}
+	for _, tt := range tests {
+		c, err := convert(t, convertOptions{
+			file:     tt.file,
+			all:      true,
+			config:   squashed,
+			platform: "linux/amd64",
+			filter:   tt.filter,
+		})
+		if err != nil {
+			t.Errorf("%s %v: unexpected error: %v", tt.file, tt.filter, err)
+			continue
+		}
+		// map each image to the version label of its ACI
+		versions := make(map[string]string)
+		for image, acis := range c.images {
+			if len(acis) != 1 {
+				t.Fatalf("%s: expected a squashed ACI, got %d", image, len(acis))
+			}
+			versions[image], _ = acis[0].manifest.Labels.Get("version")
+		}
+		if !reflect.DeepEqual(versions, tt.expected) {
+			t.Errorf("%s %v: expected %v, got %v", tt.file, tt.filter, tt.expected, versions)
+		}
+	}
+
+	if _, err := convert(t, convertOptions{file: saveTar, all: true, config: squashed, filter: []string{"baz*"}}); err == nil {
+		t.Errorf("expected an error with a filter matching no image")
+	}
+	if _, err := convert(t, convertOptions{file: saveTar, all: true, config: squashed, filter: []string{"["}}); err == nil {
+		t.Errorf("expected an error with an invalid filter")
+	}
+}
+
+func TestConvertAllSharedLayers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	base := Layer{fileHeader("base"): []byte("base")}
+	image := func(top string) Docker22Image {
+		return testImage(nil, base, Layer{fileHeader(top): []byte(top)})
+	}
+	saveDir := path.Join(tmpDir, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = GenerateDockerSave(saveDir, []SavedImage{
+		{RepoTags: []string{"foo:1.0", "foo:latest"}, Image: image("foo")},
+		{RepoTags: []string{"bar:1.0"}, Image: image("bar")},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saveTar := path.Join(tmpDir, "save.tar")
+	if err := TarDir(saveDir, saveTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the paths of the ACIs of each image
+	paths := func(acis []convertedACI) []string {
+		var paths []string
+		for _, a := range acis {
+			paths = append(paths, a.path)
+		}
+		return paths
+	}
+	for _, squash := range []bool{true, false} {
+		var converted int
+		reporter := progress.ReporterFunc(func(e progress.Event) {
+			if e.Type == progress.LayerConverted {
+				converted++
+			}
+		})
+		c, err := convert(t, convertOptions{
+			file:   saveTar,
+			all:    true,
+			config: docker2aci.CommonConfig{Squash: squash, Progress: reporter},
+		})
+		if err != nil {
+			t.Errorf("squash %v: unexpected error: %v", squash, err)
+			continue
+		}
+		acis := make(map[string][]string)
+		for image, a := range c.images {
+			acis[image] = paths(a)
+		}
+
+		// the base layer once, and the top layers of foo and bar
+		if converted != 3 {
+			t.Errorf("squash %v: expected 3 converted layers, got %d", squash, converted)
+		}
+		if !reflect.DeepEqual(acis["foo:1.0"], acis["foo:latest"]) {
+			t.Errorf("squash %v: expected the tags of foo to share their ACIs, got %v and %v", squash, acis["foo:1.0"], acis["foo:latest"])
+		}
+		if squash {
+			continue
+		}
+		foo, bar := acis["foo:1.0"], acis["bar:1.0"]
+		if len(foo) != 2 || len(bar) != 2 {
+			t.Errorf("expected two layer ACIs per image, got %v and %v", foo, bar)
+		} else if foo[0] != bar[0] {
+			t.Errorf("expected foo and bar to share their base layer ACI, got %s and %s", foo[0], bar[0])
+		}
+	}
+}
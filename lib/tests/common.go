@@ -6,12 +6,23 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
 
+	docker2aci "github.com/appc/docker2aci/lib"
 	"github.com/appc/docker2aci/lib/common"
 	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
 )
 
 type Layer map[*tar.Header][]byte
@@ -38,25 +49,40 @@ func GenerateDocker22(destPath string, img Docker22Image) error {
 	return nil
 }
 
+type headerSorter []*tar.Header
+
+func (s headerSorter) Len() int {
+	return len(s)
+}
+
+func (s headerSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s headerSorter) Less(i, j int) bool {
+	return s[i].Name < s[j].Name
+}
+
 func GenLayers(destPath string, layers []Layer) ([]string, error) {
 	var layerHashes []string
 	for _, l := range layers {
 		layerBuffer := &bytes.Buffer{}
-		tw := tar.NewWriter(layerBuffer)
-		for hdr, contents := range l {
+		// write the entries in a stable order, for the hard links to follow
+		// their targets
+		var hdrs []*tar.Header
+		for hdr := range l {
+			hdrs = append(hdrs, hdr)
+		}
+		sort.Sort(headerSorter(hdrs))
+		for _, hdr := range hdrs {
+			contents := l[hdr]
 			hdr.Size = int64(len(contents))
-			err := tw.WriteHeader(hdr)
-			if err != nil {
-				tw.Close()
-				return nil, err
-			}
-			_, err = tw.Write(contents)
-			if err != nil {
-				tw.Close()
+			if err := writeLayerEntry(layerBuffer, hdr, contents); err != nil {
 				return nil, err
 			}
 		}
-		tw.Close()
+		// the end of the archive
+		layerBuffer.Write(make([]byte, 1024))
 		layerTarBlob := layerBuffer.Bytes()
 		h := sha256.New()
 		h.Write(layerTarBlob)
@@ -70,6 +96,80 @@ func GenLayers(destPath string, layers []Layer) ([]string, error) {
 	return layerHashes, nil
 }
 
+// writeLayerEntry writes the entry hdr with contents to w, which ends with
+// the padding of the previous entry. Each entry has a tar writer of its own,
+// as the tar writer can't write sparse files: they are written in the old GNU
+// format of "tar --sparse", where the blocks of zeros are holes and the sparse
+// map is in the header.
+func writeLayerEntry(w io.Writer, hdr *tar.Header, contents []byte) error {
+	if hdr.Typeflag != tar.TypeGNUSparse {
+		tw := tar.NewWriter(w)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents); err != nil {
+			return err
+		}
+		return tw.Flush()
+	}
+
+	type fragment struct{ offset, length int }
+	var fragments []fragment
+	zeros := make([]byte, 512)
+	for offset := 0; offset < len(contents); offset += 512 {
+		block := contents[offset:]
+		if len(block) > 512 {
+			block = block[:512]
+		}
+		if bytes.Equal(block, zeros[:len(block)]) {
+			continue
+		}
+		if last := len(fragments) - 1; last >= 0 && fragments[last].offset+fragments[last].length == offset {
+			fragments[last].length += len(block)
+		} else {
+			fragments = append(fragments, fragment{offset, len(block)})
+		}
+	}
+	if last := len(fragments) - 1; last < 0 || fragments[last].offset+fragments[last].length != len(contents) {
+		fragments = append(fragments, fragment{len(contents), 0})
+	}
+	if len(fragments) > 4 {
+		return fmt.Errorf("sparse file %q has more than 4 fragments", hdr.Name)
+	}
+
+	block := make([]byte, 512)
+	octal := func(b []byte, v int64) {
+		copy(b, fmt.Sprintf("%0*o\x00", len(b)-1, v))
+	}
+	copy(block[0:100], hdr.Name)
+	octal(block[100:108], hdr.Mode)
+	octal(block[108:116], int64(hdr.Uid))
+	octal(block[116:124], int64(hdr.Gid))
+	octal(block[136:148], hdr.ModTime.Unix())
+	block[156] = tar.TypeGNUSparse
+	copy(block[257:265], "ustar  \x00")
+	copy(block[265:297], hdr.Uname)
+	copy(block[297:329], hdr.Gname)
+	var data []byte
+	for i, f := range fragments {
+		octal(block[386+24*i:398+24*i], int64(f.offset))
+		octal(block[398+24*i:410+24*i], int64(f.length))
+		data = append(data, contents[f.offset:f.offset+f.length]...)
+	}
+	octal(block[124:136], int64(len(data)))
+	octal(block[483:495], int64(len(contents)))
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	data = append(data, make([]byte, -len(data)&511)...)
+	_, err := w.Write(append(block, data...))
+	return err
+}
+
 func GenDocker22Config(destPath string, conf typesV2.ImageConfig, layerHashes []string) (string, error) {
 	conf.RootFS = &typesV2.ImageConfigRootFS{}
 	conf.RootFS.Type = "layers"
@@ -137,3 +237,283 @@ func GenDocker22Manifest(destPath, configHash string, layerHashes []string) erro
 	}
 	return nil
 }
+
+// SavedImage is an image to put in an archive generated like "docker save"
+// does since Docker 1.10.
+type SavedImage struct {
+	RepoTags []string
+	Image    Docker22Image
+}
+
+// GenerateDockerSave writes the given images in destPath with a manifest.json
+// file listing them, without the legacy per-layer json files.
+func GenerateDockerSave(destPath string, imgs []SavedImage) error {
+	var manifest []typesV2.SavedImageManifest
+	for _, img := range imgs {
+		layerHashes, err := GenLayers(destPath, img.Image.Layers)
+		if err != nil {
+			return err
+		}
+		configHash, err := GenDocker22Config(destPath, img.Image.Config, layerHashes)
+		if err != nil {
+			return err
+		}
+		configPath := configHash + ".json"
+		if err := os.Rename(path.Join(destPath, configHash), path.Join(destPath, configPath)); err != nil {
+			return err
+		}
+		m := typesV2.SavedImageManifest{
+			Config:   configPath,
+			RepoTags: img.RepoTags,
+		}
+		for _, h := range layerHashes {
+			// layer directories are named after v1 IDs, which aren't
+			// the diff IDs
+			id := fmt.Sprintf("%x", sha256.Sum256([]byte(configHash+h)))
+			layerPath := path.Join(id, "layer.tar")
+			if err := os.MkdirAll(path.Join(destPath, id), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(path.Join(destPath, h), path.Join(destPath, layerPath)); err != nil {
+				return err
+			}
+			m.Layers = append(m.Layers, layerPath)
+		}
+		manifest = append(manifest, m)
+	}
+
+	manblob, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(destPath, "manifest.json"), manblob, 0644)
+}
+
+// saveImageTar saves img tagged foo:1.2 in a tarball like "docker save" does,
+// and returns its path. The tarball is removed when the test ends.
+func saveImageTar(t *testing.T, img Docker22Image) string {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	saveDir := path.Join(tmpDir, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := GenerateDockerSave(saveDir, []SavedImage{{RepoTags: []string{"foo:1.2"}, Image: img}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	saveTar := path.Join(tmpDir, "save.tar")
+	if err := TarDir(saveDir, saveTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return saveTar
+}
+
+// dockerImageConfig defines the common image configuration.
+var dockerImageConfig = typesV2.ImageConfigConfig{
+	User:       "",
+	Memory:     12345,
+	MemorySwap: 0,
+	CpuShares:  9001,
+	ExposedPorts: map[string]struct{}{
+		"80": struct{}{},
+	},
+	Env: []string{
+		"FOO=1",
+	},
+	Entrypoint: []string{
+		"/bin/sh",
+		"-c",
+		"echo",
+	},
+	Cmd: []string{
+		"foo",
+	},
+	Volumes:    nil,
+	WorkingDir: "/",
+}
+
+// testImage returns a linux/amd64 image of the layers, configured with conf,
+// or with dockerImageConfig if nil. Without layers, the image has a layer of
+// a single file.
+func testImage(conf *typesV2.ImageConfigConfig, layers ...Layer) Docker22Image {
+	if conf == nil {
+		conf = &dockerImageConfig
+	}
+	if len(layers) == 0 {
+		layers = []Layer{fileLayer("thisisafile", "these are its contents")}
+	}
+	return Docker22Image{
+		Layers: layers,
+		Config: typesV2.ImageConfig{
+			Architecture: "amd64",
+			OS:           "linux",
+			Config:       conf,
+		},
+	}
+}
+
+// fileHeader returns the header of a regular file of a layer.
+func fileHeader(name string) *tar.Header {
+	return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, ModTime: time.Now()}
+}
+
+// dirHeader returns the header of a directory of a layer.
+func dirHeader(name string) *tar.Header {
+	return &tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()}
+}
+
+// fileLayer returns a layer of a single regular file.
+func fileLayer(name, contents string) Layer {
+	return Layer{fileHeader(name): []byte(contents)}
+}
+
+// convertOptions are the input and the configuration of a conversion by
+// convert. The image is converted, saved like "docker save" does, unless a
+// file or a reader is given.
+type convertOptions struct {
+	image  Docker22Image
+	file   string    // a saved file, or an OCI image layout
+	reader io.Reader // a saved archive read as a stream
+	all    bool      // convert all the images with ConvertAllSavedFile
+
+	config    docker2aci.CommonConfig // without the output and tmp directories
+	dockerURL string
+	platform  string
+	filter    []string
+}
+
+// conversion is the result of convert.
+type conversion struct {
+	outputDir string                    // removed when the test ends
+	acis      []convertedACI            // of the converted image
+	images    map[string][]convertedACI // of all the converted images
+	warnings  []string                  // reported during the conversion
+}
+
+// convertedACI is an ACI generated by convert.
+type convertedACI struct {
+	path     string // relative to the output directory
+	manifest *schema.ImageManifest
+	rootfs   map[string]*tar.Header // by absolute path in the rootfs
+	contents map[string][]byte      // of the regular files of rootfs
+}
+
+// manifest returns the manifest of the upper ACI of the converted image.
+func (c *conversion) manifest() *schema.ImageManifest {
+	return c.acis[len(c.acis)-1].manifest
+}
+
+// convert converts the image, the file or the stream of opts to ACIs in a
+// temporary directory, and returns them. The warnings are also reported to
+// the reporter of the configuration, if any.
+func convert(t *testing.T, opts convertOptions) (*conversion, error) {
+	outputDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(outputDir) })
+
+	c := &conversion{outputDir: outputDir}
+	config := docker2aci.FileConfig{
+		CommonConfig: opts.config,
+		DockerURL:    opts.dockerURL,
+		Platform:     opts.platform,
+		ImageFilter:  opts.filter,
+	}
+	config.OutputDir = outputDir
+	config.TmpDir = outputDir
+	reporter := opts.config.Progress
+	config.Progress = progress.ReporterFunc(func(e progress.Event) {
+		if e.Type == progress.Warning {
+			c.warnings = append(c.warnings, e.Message)
+		}
+		if reporter != nil {
+			reporter.Report(e)
+		}
+	})
+
+	file := opts.file
+	if file == "" && opts.reader == nil {
+		file = saveImageTar(t, opts.image)
+	}
+	switch {
+	case opts.reader != nil:
+		acis, err := docker2aci.ConvertSavedReader(opts.reader, config)
+		if err != nil {
+			return nil, err
+		}
+		c.acis = readACIs(t, outputDir, acis)
+	case opts.all:
+		images, err := docker2aci.ConvertAllSavedFile(file, config)
+		if err != nil {
+			return nil, err
+		}
+		c.images = make(map[string][]convertedACI)
+		for image, acis := range images {
+			c.images[image] = readACIs(t, outputDir, acis)
+		}
+	default:
+		acis, err := docker2aci.ConvertSavedFile(file, config)
+		if err != nil {
+			return nil, err
+		}
+		c.acis = readACIs(t, outputDir, acis)
+	}
+	return c, nil
+}
+
+// readACIs reads the ACIs generated in outputDir.
+func readACIs(t *testing.T, outputDir string, acis []string) []convertedACI {
+	var converted []convertedACI
+	for _, p := range acis {
+		rel, err := filepath.Rel(outputDir, p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		c := convertedACI{path: rel, rootfs: make(map[string]*tar.Header), contents: make(map[string][]byte)}
+
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		tr, err := aci.NewCompressedTarReader(f)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			name := path.Clean(hdr.Name)
+			if !strings.HasPrefix(name, "rootfs/") {
+				continue
+			}
+			p := strings.TrimPrefix(name, "rootfs")
+			c.rootfs[p] = hdr
+			if hdr.Typeflag == tar.TypeReg {
+				if c.contents[p], err = ioutil.ReadAll(tr); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}
+		}
+		tr.Close()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("%v", err)
+		}
+		c.manifest, err = aci.ManifestFromImage(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		converted = append(converted, c)
+	}
+	return converted
+}
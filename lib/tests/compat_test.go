@@ -0,0 +1,131 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+)
+
+// compatTestLayers have an /etc/hostname file, and the parent of the
+// working directory behind a symlink.
+var compatTestLayers = []Layer{
+	Layer{
+		&tar.Header{
+			Name:     "etc/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  time.Now(),
+		}: nil,
+		&tar.Header{
+			Name:    "etc/hostname",
+			Mode:    0644,
+			ModTime: time.Now(),
+		}: []byte("myhost"),
+	},
+	Layer{
+		&tar.Header{
+			Name:     "srv",
+			Typeflag: tar.TypeSymlink,
+			Linkname: "var/srv",
+			ModTime:  time.Now(),
+		}: nil,
+		&tar.Header{
+			Name:     "var/srv/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  time.Now(),
+		}: nil,
+	},
+}
+
+func TestDockerCompat(t *testing.T) {
+	compat := &d2acommon.DockerCompat{
+		StdioSymlinks: map[string]string{"/dev/stdout": "/proc/self/fd/1"},
+	}
+	if err := compat.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conf := dockerImageConfig
+	conf.Env = []string{"FOO=1"}
+	conf.WorkingDir = "/srv/app/data"
+	img := testImage(&conf, compatTestLayers...)
+
+	for _, squash := range []bool{true, false} {
+		c, err := convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Squash: squash, DockerCompat: compat}})
+		if err != nil {
+			t.Fatalf("squash %v: unexpected error: %v", squash, err)
+		}
+		top := c.acis[len(c.acis)-1]
+
+		if path, _ := top.manifest.App.Environment.Get("PATH"); path != "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin" {
+			t.Errorf("squash %v: expected Docker's default PATH, got %q", squash, path)
+		}
+
+		for _, p := range []string{"/var/srv/app", "/var/srv/app/data"} {
+			if hdr, ok := top.rootfs[p]; !ok || hdr.Typeflag != tar.TypeDir {
+				t.Errorf("squash %v: expected directory %s", squash, p)
+			}
+		}
+		if hdr, ok := top.rootfs["/srv"]; squash && (!ok || hdr.Typeflag != tar.TypeSymlink) {
+			t.Errorf("squash %v: expected /srv to stay a symlink", squash)
+		}
+		for _, p := range []string{"/etc/hosts", "/etc/resolv.conf"} {
+			if hdr, ok := top.rootfs[p]; !ok || hdr.Typeflag != tar.TypeReg || hdr.Size != 0 {
+				t.Errorf("squash %v: expected empty file %s", squash, p)
+			}
+		}
+		hostname, ok := top.rootfs["/etc/hostname"]
+		if squash && (!ok || hostname.Size != int64(len("myhost"))) {
+			t.Errorf("squash %v: expected /etc/hostname of the image", squash)
+		} else if !squash && ok {
+			t.Errorf("squash %v: unexpected /etc/hostname in the upper layer", squash)
+		}
+		if hdr, ok := top.rootfs["/dev/stdout"]; !ok || hdr.Linkname != "/proc/self/fd/1" {
+			t.Errorf("squash %v: expected /dev/stdout to link to /proc/self/fd/1, got %v", squash, hdr)
+		}
+		if hdr, ok := top.rootfs["/dev/stderr"]; !ok || hdr.Linkname != "/dev/console" {
+			t.Errorf("squash %v: expected /dev/stderr to link to /dev/console, got %v", squash, hdr)
+		}
+
+		if !squash {
+			for _, p := range []string{"/var/srv/app/data", "/etc/hosts", "/etc/resolv.conf"} {
+				found := false
+				for _, w := range top.manifest.PathWhitelist {
+					found = found || w == p
+				}
+				if !found {
+					t.Errorf("expected %s in the path whitelist %v", p, top.manifest.PathWhitelist)
+				}
+			}
+		}
+	}
+
+	c, err := convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Squash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := c.manifest().App.Environment.Get("PATH"); ok {
+		t.Errorf("unexpected PATH without the profile")
+	}
+	if _, ok := c.acis[0].rootfs["/etc/hosts"]; ok {
+		t.Errorf("unexpected /etc/hosts without the profile")
+	}
+}
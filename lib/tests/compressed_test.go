@@ -0,0 +1,154 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+)
+
+// storedXZ returns b in an xz stream of uncompressed LZMA2 chunks, with a
+// CRC32 check.
+func storedXZ(b []byte) []byte {
+	var out bytes.Buffer
+	le32 := func(v uint32) {
+		binary.Write(&out, binary.LittleEndian, v)
+	}
+
+	flags := []byte{0x00, 0x01}
+	out.Write([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00})
+	out.Write(flags)
+	le32(crc32.ChecksumIEEE(flags))
+
+	// block header: a single LZMA2 filter, padded to 12 bytes
+	header := []byte{0x02, 0x00, 0x21, 0x01, 0x00, 0x00, 0x00, 0x00}
+	out.Write(header)
+	le32(crc32.ChecksumIEEE(header))
+	compressed := 1
+	for i := 0; i < len(b); i += 1 << 16 {
+		chunk := b[i:]
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		control := byte(0x02)
+		if i == 0 {
+			control = 0x01
+		}
+		out.Write([]byte{control, byte((len(chunk) - 1) >> 8), byte(len(chunk) - 1)})
+		out.Write(chunk)
+		compressed += 3 + len(chunk)
+	}
+	out.WriteByte(0x00)
+	unpadded := len(header) + 4 + compressed + 4
+	for ; compressed%4 != 0; compressed++ {
+		out.WriteByte(0x00)
+	}
+	le32(crc32.ChecksumIEEE(b))
+
+	var index bytes.Buffer
+	index.WriteByte(0x00)
+	for _, v := range []uint64{1, uint64(unpadded), uint64(len(b))} {
+		var vli [binary.MaxVarintLen64]byte
+		index.Write(vli[:binary.PutUvarint(vli[:], v)])
+	}
+	for index.Len()%4 != 0 {
+		index.WriteByte(0x00)
+	}
+	out.Write(index.Bytes())
+	le32(crc32.ChecksumIEEE(index.Bytes()))
+
+	footer := make([]byte, 6)
+	binary.LittleEndian.PutUint32(footer, uint32((index.Len()+4)/4-1))
+	copy(footer[4:], flags)
+	le32(crc32.ChecksumIEEE(footer))
+	out.Write(footer)
+	out.Write([]byte{'Y', 'Z'})
+	return out.Bytes()
+}
+
+// storedZstd returns b in a zstd frame of raw blocks.
+func storedZstd(b []byte) []byte {
+	var out bytes.Buffer
+	// magic, no frame header flags, 128KiB window
+	out.Write([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38})
+	for i := 0; ; i += 1 << 17 {
+		block := b[i:]
+		last := uint32(1)
+		if len(block) > 1<<17 {
+			block = block[:1<<17]
+			last = 0
+		}
+		header := uint32(len(block))<<3 | last
+		out.Write([]byte{byte(header), byte(header >> 8), byte(header >> 16)})
+		out.Write(block)
+		if last == 1 {
+			return out.Bytes()
+		}
+	}
+}
+
+func TestCompressedArchives(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tarb, err := ioutil.ReadFile(saveImageTar(t, testImage(nil)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(tarb)
+	gw.Close()
+
+	archives := map[string][]byte{
+		"tar":     tarb,
+		"tar.gz":  gz.Bytes(),
+		"tar.xz":  storedXZ(tarb),
+		"tar.zst": storedZstd(tarb),
+	}
+
+	squashed := docker2aci.CommonConfig{Squash: true}
+	for ext, b := range archives {
+		file := path.Join(tmpDir, "foo."+ext)
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		c, err := convert(t, convertOptions{file: file, config: squashed})
+		if err != nil {
+			t.Errorf("%s: unexpected error converting file: %v", ext, err)
+		} else if name := c.manifest().Name; name != "registry-1.docker.io/library/foo" {
+			t.Errorf("%s: expected name registry-1.docker.io/library/foo, got %q", ext, name)
+		}
+
+		c, err = convert(t, convertOptions{reader: bytes.NewReader(b), config: squashed})
+		if err != nil {
+			t.Errorf("%s: unexpected error converting stream: %v", ext, err)
+		} else if name := c.manifest().Name; name != "registry-1.docker.io/library/foo" {
+			t.Errorf("%s: expected name registry-1.docker.io/library/foo, got %q", ext, name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/spec/aci"
+)
+
+// serveDaemon serves, on a unix socket, the subset of the Docker Engine API
+// used to export the images in saveTars, by name.
+func serveDaemon(t *testing.T, socket string, saveTars map[string]string) *http.Server {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, "/images/")
+		name, action := path.Dir(p), path.Base(p)
+		saveTar, ok := saveTars[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such image: " + name})
+			return
+		}
+		switch action {
+		case "json":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Id": "sha256:0123456789ab", "RepoTags": []string{name}})
+		case "get":
+			w.Header().Set("Content-Type", "application/x-tar")
+			http.ServeFile(w, r, saveTar)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})}
+	go server.Serve(l)
+	return server
+}
+
+func TestDaemonImage(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	saveDir := path.Join(tmpDir, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	img := testImage(nil)
+	img.Config.Architecture = "arm64"
+	err = GenerateDockerSave(saveDir, []SavedImage{
+		{RepoTags: []string{"quay.io/coreos/foo:1.0"}, Image: img},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saveTar := path.Join(tmpDir, "save.tar")
+	if err := TarDir(saveDir, saveTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	socket := path.Join(tmpDir, "docker.sock")
+	server := serveDaemon(t, socket, map[string]string{"quay.io/coreos/foo:1.0": saveTar})
+	defer server.Close()
+
+	outputDir := path.Join(tmpDir, "out")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	config := docker2aci.DaemonConfig{
+		CommonConfig: docker2aci.CommonConfig{
+			Squash:      true,
+			OutputDir:   outputDir,
+			TmpDir:      outputDir,
+			Compression: d2acommon.NoCompression,
+		},
+	}
+
+	oldHost := os.Getenv("DOCKER_HOST")
+	defer os.Setenv("DOCKER_HOST", oldHost)
+	os.Setenv("DOCKER_HOST", "unix://"+socket)
+
+	acis, err := docker2aci.ConvertDaemonImage("quay.io/coreos/foo:1.0", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err := os.Open(acis[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	manifest, err := aci.ManifestFromImage(f)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if manifest.Name != "quay.io/coreos/foo" {
+		t.Errorf("expected name quay.io/coreos/foo, got %q", manifest.Name)
+	}
+	if arch, _ := manifest.Labels.Get("arch"); arch != "aarch64" {
+		t.Errorf("expected arch aarch64, got %q", arch)
+	}
+
+	_, err = docker2aci.ConvertDaemonImage("quay.io/coreos/bar", config)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	config.Host = "unix://" + path.Join(tmpDir, "missing.sock")
+	if _, err := docker2aci.ConvertDaemonImage("quay.io/coreos/foo:1.0", config); err == nil {
+		t.Errorf("expected an error connecting to a missing daemon")
+	}
+}
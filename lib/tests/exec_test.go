@@ -0,0 +1,113 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+)
+
+// execTestLayers have executables, in the default PATH or not, and a symlink
+// to a directory of the PATH.
+var execTestLayers = []Layer{
+	Layer{
+		&tar.Header{
+			Name:    "usr/bin/python3.6",
+			Mode:    0755,
+			ModTime: time.Now(),
+		}: []byte("python"),
+		&tar.Header{
+			Name:     "usr/bin/python",
+			Typeflag: tar.TypeSymlink,
+			Linkname: "python3.6",
+			ModTime:  time.Now(),
+		}: nil,
+		&tar.Header{
+			Name:    "usr/bin/readme",
+			Mode:    0644,
+			ModTime: time.Now(),
+		}: []byte("not executable"),
+	},
+	Layer{
+		&tar.Header{
+			Name:    "opt/bin/python",
+			Mode:    0755,
+			ModTime: time.Now(),
+		}: []byte("another python"),
+		&tar.Header{
+			Name:     "bin",
+			Typeflag: tar.TypeSymlink,
+			Linkname: "usr/bin",
+			ModTime:  time.Now(),
+		}: nil,
+		&tar.Header{
+			Name:    "app/run.sh",
+			Mode:    0755,
+			ModTime: time.Now(),
+		}: []byte("#!/bin/sh"),
+	},
+}
+
+func TestResolveExec(t *testing.T) {
+	tests := []struct {
+		entrypoint string
+		env        []string
+		workingDir string
+
+		expectedExec     string
+		expectedWarnings int
+	}{
+		{"python", nil, "", "/usr/bin/python", 0},
+		{"python", []string{"PATH=/opt/bin:/usr/bin"}, "", "/opt/bin/python", 0},
+		{"python3.6", []string{"PATH=/bin"}, "", "/bin/python3.6", 0},
+		{"./run.sh", nil, "/app", "/app/run.sh", 0},
+		{"/usr/bin/missing", nil, "", "/usr/bin/missing", 0},
+		{"readme", nil, "", "readme", 1},
+		{"missing", nil, "", "missing", 1},
+	}
+
+	for _, tt := range tests {
+		for _, squash := range []bool{true, false} {
+			conf := dockerImageConfig
+			conf.Entrypoint = []string{tt.entrypoint, "-v"}
+			conf.Cmd = nil
+			conf.Env = tt.env
+			conf.WorkingDir = tt.workingDir
+			c, err := convert(t, convertOptions{
+				image: testImage(&conf, execTestLayers...),
+				config: docker2aci.CommonConfig{
+					Squash:      squash,
+					Compression: d2acommon.GzipCompression,
+				},
+			})
+			if err != nil {
+				t.Errorf("%s (squash %v): unexpected error: %v", tt.entrypoint, squash, err)
+				continue
+			}
+
+			exec := c.manifest().App.Exec
+			if len(exec) != 2 || exec[0] != tt.expectedExec || exec[1] != "-v" {
+				t.Errorf("%s (squash %v): expected exec [%s -v], got %v", tt.entrypoint, squash, tt.expectedExec, exec)
+			}
+			if len(c.warnings) != tt.expectedWarnings {
+				t.Errorf("%s (squash %v): expected %d warnings, got %v", tt.entrypoint, squash, tt.expectedWarnings, c.warnings)
+			}
+		}
+	}
+}
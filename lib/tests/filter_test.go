@@ -0,0 +1,159 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/pkg/progress"
+)
+
+// filterTestLayers have documentation, manpages and locales to exclude.
+func filterTestLayers() []Layer {
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target, ModTime: time.Now()}
+	}
+	return []Layer{
+		Layer{
+			dirHeader("usr"):                           nil,
+			dirHeader("usr/bin"):                       nil,
+			fileHeader("usr/bin/tool"):                 []byte("tool"),
+			dirHeader("usr/share"):                     nil,
+			dirHeader("usr/share/doc"):                 nil,
+			dirHeader("usr/share/doc/tool"):            nil,
+			fileHeader("usr/share/doc/tool/README"):    bytes.Repeat([]byte("r"), 100),
+			fileHeader("usr/share/doc/tool/copyright"): []byte("license"),
+			dirHeader("usr/share/man"):                 nil,
+			// hard links kept to an excluded file
+			fileHeader("usr/share/man/tool.1"):               []byte("manpage"),
+			link("usr/tool/help.1", "usr/share/man/tool.1"):  nil,
+			link("usr/tool/help2.1", "usr/share/man/tool.1"): nil,
+		},
+		Layer{
+			dirHeader("usr/share/locale"):             nil,
+			dirHeader("usr/share/locale/fr"):          nil,
+			fileHeader("usr/share/locale/fr/tool.mo"): bytes.Repeat([]byte("f"), 30),
+			dirHeader("usr/share/locale/en"):          nil,
+			fileHeader("usr/share/locale/en/tool.mo"): []byte("en"),
+			fileHeader("usr/bin/tool2"):               []byte("tool2"),
+		},
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	filter := &d2acommon.PathFilter{
+		Exclude: []string{"/usr/share/doc", "/usr/share/man", "/usr/share/locale/*"},
+		Include: []string{"/usr/share/doc/*/copyright", "/usr/share/locale/en"},
+	}
+	excluded := []string{
+		"/usr/share/doc/tool/README",
+		"/usr/share/man",
+		"/usr/share/man/tool.1",
+		"/usr/share/locale/fr",
+		"/usr/share/locale/fr/tool.mo",
+	}
+	kept := []string{
+		"/usr/bin/tool",
+		"/usr/bin/tool2",
+		// the parents of included paths are kept
+		"/usr/share/doc",
+		"/usr/share/doc/tool",
+		"/usr/share/doc/tool/copyright",
+		"/usr/share/locale",
+		"/usr/share/locale/en/tool.mo",
+		"/usr/tool/help.1",
+		"/usr/tool/help2.1",
+	}
+
+	for _, squash := range []bool{true, false} {
+		var removed int64
+		reporter := progress.ReporterFunc(func(e progress.Event) {
+			if e.Type == progress.FilesExcluded {
+				removed += e.Removed
+			}
+		})
+		c, err := convert(t, convertOptions{
+			image:  testImage(nil, filterTestLayers()...),
+			config: docker2aci.CommonConfig{Squash: squash, PathFilter: filter, Progress: reporter},
+		})
+		if err != nil {
+			t.Fatalf("squash %v: unexpected error: %v", squash, err)
+		}
+		acis := c.acis
+
+		rootfs := make(map[string]*tar.Header)
+		for _, aci := range acis {
+			for p, hdr := range aci.rootfs {
+				rootfs[p] = hdr
+			}
+		}
+		for _, p := range excluded {
+			if _, ok := rootfs[p]; ok {
+				t.Errorf("squash %v: unexpected %s", squash, p)
+			}
+		}
+		for _, p := range kept {
+			if _, ok := rootfs[p]; !ok {
+				t.Errorf("squash %v: expected %s", squash, p)
+			}
+		}
+		if removed != 130 {
+			t.Errorf("squash %v: expected 130 bytes removed, got %d", squash, removed)
+		}
+
+		// one of the hard links gets the contents of the excluded file
+		var contents, links int
+		for _, p := range []string{"/usr/tool/help.1", "/usr/tool/help2.1"} {
+			hdr, ok := rootfs[p]
+			if !ok {
+				continue
+			}
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				contents++
+				if hdr.Size != int64(len("manpage")) {
+					t.Errorf("squash %v: expected %s to have the contents of the manpage, got %d bytes", squash, p, hdr.Size)
+				}
+			case tar.TypeLink:
+				links++
+			}
+		}
+		if contents != 1 || links != 1 {
+			t.Errorf("squash %v: expected a file and a hard link to it, got %d files and %d links", squash, contents, links)
+		}
+
+		if !squash {
+			pwl := make(map[string]bool)
+			for _, p := range acis[len(acis)-1].manifest.PathWhitelist {
+				pwl[p] = true
+			}
+			for _, p := range excluded {
+				if pwl[p] {
+					t.Errorf("unexpected %s in the path whitelist", p)
+				}
+			}
+			for _, p := range kept {
+				if !pwl[p] {
+					t.Errorf("expected %s in the path whitelist", p)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+)
+
+func TestInspectV22(t *testing.T) {
+	layers := []Layer{
+		Layer{
+			&tar.Header{
+				Name:    "thisisafile",
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("these are its contents"),
+		},
+		Layer{
+			&tar.Header{
+				Name:    "thisisadifferentfile",
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("the contents of this file are different from the last!"),
+		},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	layerHashes, err := GenLayers(tmpDir, layers)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	config := typesV2.ImageConfig{
+		Created:      "2016-06-02T21:43:31.291506236Z",
+		Author:       "rkt developer <rkt-dev@googlegroups.com>",
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       &dockerImageConfig,
+	}
+	configHash, err := GenDocker22Config(tmpDir, config, layerHashes)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := GenDocker22Manifest(tmpDir, configHash, layerHashes); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var expectedLayers []d2acommon.LayerInfo
+	for _, h := range layerHashes {
+		fi, err := os.Stat(path.Join(tmpDir, h))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		expectedLayers = append(expectedLayers, d2acommon.LayerInfo{
+			Digest:    "sha256:" + h,
+			Size:      fi.Size(),
+			MediaType: d2acommon.MediaTypeDockerV22RootFS,
+		})
+		// inspecting must not download the layers, make the registry
+		// fail if it's asked for them
+		if err := os.Remove(path.Join(tmpDir, h)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	imgName := "docker2aci/dockerv22test"
+	imgRef := "v0.1.0"
+	server := RunDockerRegistry(t, tmpDir, imgName, imgRef, d2acommon.MediaTypeDockerV22Manifest)
+	defer server.Close()
+
+	bareServerURL := strings.TrimPrefix(server.URL, "http://")
+	localUrl := path.Join(bareServerURL, imgName) + ":" + imgRef
+
+	conf := docker2aci.RemoteConfig{
+		CommonConfig: docker2aci.CommonConfig{
+			Squash: true,
+		},
+		Insecure: d2acommon.InsecureConfig{
+			SkipVerify: true,
+			AllowHTTP:  true,
+		},
+	}
+
+	info, err := docker2aci.InspectRemoteRepo(localUrl, conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expectedImageManifest := expectedManifest(bareServerURL, localUrl, "linux", "amd64")
+	if err := manifestEqual(info.Manifest, &expectedImageManifest); err != nil {
+		t.Errorf("manifest doesn't match expected manifest: %v", err)
+	}
+
+	if len(info.Layers) != len(expectedLayers) {
+		t.Fatalf("expected %d layers, got %d", len(expectedLayers), len(info.Layers))
+	}
+	for i, l := range info.Layers {
+		if l != expectedLayers[i] {
+			t.Errorf("layer %d: expected %+v, got %+v", i, expectedLayers[i], l)
+		}
+	}
+}
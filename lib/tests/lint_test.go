@@ -0,0 +1,132 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+)
+
+// lintTestLayers have the files checked by the linter.
+func lintTestLayers() []Layer {
+	file := func(name string, mode int64) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: mode, ModTime: time.Now()}
+	}
+	return []Layer{
+		Layer{
+			dirHeader("etc"):             nil,
+			file("etc/passwd", 0644):     []byte("root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n"),
+			file("etc/group", 0644):      []byte("root:x:0:\napp:x:1000:\n"),
+			dirHeader("bin"):             nil,
+			file("bin/sh", 0755):         []byte("sh"),
+			dirHeader("usr/bin"):         nil,
+			file("usr/bin/run.sh", 0755): []byte("#!/usr/bin/python3 -u\nprint('run')\n"),
+		},
+		Layer{
+			dirHeader("srv"):       nil,
+			file("srv/data", 0644): []byte("data"),
+			file("srv/tool", 0644): []byte("tool"),
+			dirHeader("home/app"):  nil,
+		},
+	}
+}
+
+func TestLint(t *testing.T) {
+	good := dockerImageConfig
+	good.User = "app"
+	good.WorkingDir = "/home/app"
+	good.Entrypoint = []string{"sh"}
+	good.Cmd = nil
+	good.Volumes = map[string]struct{}{"/data": {}}
+
+	bad := good
+	bad.User = "nobody"
+	bad.WorkingDir = "/srv/data"
+	bad.Entrypoint = []string{"/usr/bin/run.sh"}
+	bad.Volumes = map[string]struct{}{"/srv/data": {}}
+
+	expected := []string{
+		`error: interpreter of "/usr/bin/run.sh" "/usr/bin/python3" not found`,
+		`error: path "/srv/data" of mount point "volume-srv-data" isn't a directory`,
+		`error: user "nobody" not found in /etc/passwd`,
+		`error: working directory "/srv/data" isn't a directory`,
+	}
+
+	// convert an image configured with conf, and return the problems found
+	// in its ACIs and the lint warnings of the conversion
+	convertLinted := func(conf typesV2.ImageConfigConfig, squash bool, lint d2acommon.LintPolicy) ([]docker2aci.LintProblem, []string, error) {
+		c, err := convert(t, convertOptions{
+			image:  testImage(&conf, lintTestLayers()...),
+			config: docker2aci.CommonConfig{Squash: squash, Lint: lint},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		var acis, warnings []string
+		for _, a := range c.acis {
+			acis = append(acis, filepath.Join(c.outputDir, a.path))
+		}
+		for _, w := range c.warnings {
+			if strings.HasPrefix(w, "lint ") {
+				warnings = append(warnings, w)
+			}
+		}
+		problems, err := docker2aci.LintACIs(acis)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return problems, warnings, nil
+	}
+
+	for _, squash := range []bool{true, false} {
+		problems, warnings, err := convertLinted(good, squash, d2acommon.LintFail)
+		if err != nil {
+			t.Fatalf("squash %v: unexpected error: %v", squash, err)
+		}
+		if len(problems) != 0 || len(warnings) != 0 {
+			t.Errorf("squash %v: expected no problem, got %v and warnings %v", squash, problems, warnings)
+		}
+
+		problems, warnings, err = convertLinted(bad, squash, d2acommon.LintWarn)
+		if err != nil {
+			t.Fatalf("squash %v: unexpected error: %v", squash, err)
+		}
+		var got []string
+		for _, p := range problems {
+			got = append(got, p.String())
+		}
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("squash %v: expected problems:\n%s\ngot:\n%s", squash, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+		if len(warnings) != len(expected) {
+			t.Errorf("squash %v: expected a warning per problem, got %v", squash, warnings)
+		}
+
+		tool := good
+		tool.Entrypoint = []string{"/srv/tool"}
+		if _, _, err := convertLinted(tool, squash, d2acommon.LintFail); err == nil || !strings.Contains(err.Error(), `executable "/srv/tool" isn't executable`) {
+			t.Errorf("squash %v: expected the conversion to fail on lint errors, got %v", squash, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+)
+
+func TestMetadataMapping(t *testing.T) {
+	conf := dockerImageConfig
+	conf.Labels = map[string]string{
+		"com.example.team":          "infra",
+		"com.example.build.host":    "builder-1",
+		"com.example.build.started": "2017-01-01",
+		"maintainer":                "foo maintainers",
+	}
+	img := testImage(&conf)
+
+	mapping := &d2acommon.MetadataMapping{Rules: []d2acommon.MetadataRule{
+		{Match: "userlabel:com.example.team", Action: d2acommon.MetadataCopy, To: "label:team"},
+		{Match: "userlabel:com.example.build.*", Action: d2acommon.MetadataDrop},
+		{Match: "annotation:appc.io/docker/*", Action: d2acommon.MetadataRename, To: "example.com/docker/*"},
+		{Action: d2acommon.MetadataSet, To: "annotation:example.com/image", Value: "{{.Repository}}:{{.Tag}}"},
+		{Match: "userlabel:maintainer", Action: d2acommon.MetadataRename, To: "annotation:authors", Value: "{{.Value}} ({{.Name}})"},
+	}}
+	if err := mapping.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, squash := range []bool{true, false} {
+		c, err := convert(t, convertOptions{
+			image:  img,
+			config: docker2aci.CommonConfig{Squash: squash, MetadataMapping: mapping},
+		})
+		if err != nil {
+			t.Errorf("squash %v: unexpected error: %v", squash, err)
+			continue
+		}
+		manifest := c.manifest()
+
+		if team, _ := manifest.Labels.Get("team"); team != "infra" {
+			t.Errorf("expected team label infra, got %q", team)
+		}
+		expectedUserLabels := map[string]string{"com.example.team": "infra"}
+		if len(manifest.App.UserLabels) != 1 || manifest.App.UserLabels["com.example.team"] != "infra" {
+			t.Errorf("expected user labels %v, got %v", expectedUserLabels, manifest.App.UserLabels)
+		}
+
+		annotations := map[string]string{
+			"example.com/docker/cmd": `["foo"]`,
+			"example.com/image":      "library/foo:1.2",
+			"authors":                "foo maintainers (maintainer)",
+		}
+		for name, value := range annotations {
+			if v, _ := manifest.Annotations.Get(name); v != value {
+				t.Errorf("expected annotation %s=%s, got %q", name, value, v)
+			}
+		}
+		if _, ok := manifest.Annotations.Get(d2acommon.AppcDockerCmd); ok {
+			t.Errorf("unexpected annotation %s", d2acommon.AppcDockerCmd)
+		}
+	}
+}
+
+func TestMetadataMappingInvalid(t *testing.T) {
+	saveTar := saveImageTar(t, testImage(nil))
+	// built in code, without the validation of LoadMetadataMapping
+	for _, rule := range []d2acommon.MetadataRule{
+		{Match: "foo", Action: d2acommon.MetadataDrop},
+		{Match: "label:foo", Action: d2acommon.MetadataCopy},
+		{Action: d2acommon.MetadataSet, To: "foo", Value: "bar"},
+	} {
+		_, err := convert(t, convertOptions{file: saveTar, config: docker2aci.CommonConfig{
+			Squash:          true,
+			MetadataMapping: &d2acommon.MetadataMapping{Rules: []d2acommon.MetadataRule{rule}},
+		}})
+		if err == nil {
+			t.Errorf("%+v: expected an error", rule)
+		}
+	}
+}
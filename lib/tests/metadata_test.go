@@ -0,0 +1,73 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+)
+
+func TestMetadataAnnotations(t *testing.T) {
+	conf := dockerImageConfig
+	conf.Healthcheck = &typesV2.HealthConfig{
+		Test:     []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+		Interval: 30 * time.Second,
+		Timeout:  1500 * time.Millisecond,
+		Retries:  3,
+	}
+	conf.StopSignal = "SIGQUIT"
+	conf.Shell = []string{"/bin/bash", "-c"}
+	conf.OnBuild = []string{"ADD . /app/src", "RUN make"}
+	c, err := convert(t, convertOptions{image: testImage(&conf), config: docker2aci.CommonConfig{Squash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := c.manifest()
+
+	v, ok := manifest.Annotations.Get(d2acommon.AppcDockerHealthcheck)
+	if !ok {
+		t.Fatalf("expected a healthcheck annotation")
+	}
+	var healthcheck d2acommon.Healthcheck
+	if err := json.Unmarshal([]byte(v), &healthcheck); err != nil {
+		t.Fatalf("error decoding healthcheck %q: %v", v, err)
+	}
+	expectedHealthcheck := d2acommon.Healthcheck{
+		Test:     []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+		Interval: "30s",
+		Timeout:  "1.5s",
+		Retries:  3,
+	}
+	if !reflect.DeepEqual(healthcheck, expectedHealthcheck) {
+		t.Errorf("expected healthcheck %+v, got %+v", expectedHealthcheck, healthcheck)
+	}
+
+	annotations := map[string]string{
+		d2acommon.AppcDockerStopSignal: "SIGQUIT",
+		d2acommon.AppcDockerShell:      `["/bin/bash","-c"]`,
+		d2acommon.AppcDockerOnBuild:    `["ADD . /app/src","RUN make"]`,
+	}
+	for name, value := range annotations {
+		if v, _ := manifest.Annotations.Get(name); v != value {
+			t.Errorf("expected annotation %s=%s, got %q", name, value, v)
+		}
+	}
+}
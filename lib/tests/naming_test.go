@@ -0,0 +1,106 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/spec/schema"
+)
+
+// namingTestLayers are named by the templates when the image isn't squashed.
+var namingTestLayers = []Layer{
+	fileLayer("thisisafile", "these are its contents"),
+	fileLayer("anotherfile", "more contents"),
+}
+
+func TestNamingTemplates(t *testing.T) {
+	img := testImage(nil, namingTestLayers...)
+	naming := &d2acommon.NamingTemplates{
+		Name:    "example.com/apps/{{.Repository}}{{if not .Top}}-layer{{.Layer}}{{end}}",
+		Version: "{{.Tag}}",
+		Labels:  map[string]string{"example.com/source": "{{.Registry}}/{{.Repository}}"},
+		Path:    "{{.OS}}/{{.Arch}}/{{.Repository}}-{{.Tag}}{{with .Layer}}-{{.}}{{end}}.aci",
+	}
+	if err := naming.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, err := convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Squash: true, Naming: naming}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	acis := c.acis
+	if len(acis) != 1 {
+		t.Fatalf("expected 1 ACI, got %d", len(acis))
+	}
+	if expected := "linux/amd64/library/foo-1.2.aci"; acis[0].path != expected {
+		t.Errorf("expected path %q, got %q", expected, acis[0].path)
+	}
+	checkNaming(t, acis[0].manifest, "example.com/apps/library/foo")
+
+	c, err = convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Naming: naming}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	acis = c.acis
+	if len(acis) != 2 {
+		t.Fatalf("expected 2 ACIs, got %d", len(acis))
+	}
+	expectedPaths := []string{"linux/amd64/library/foo-1.2-0.aci", "linux/amd64/library/foo-1.2-1.aci"}
+	expectedNames := []string{"example.com/apps/library/foo-layer0", "example.com/apps/library/foo"}
+	for i, a := range acis {
+		if a.path != expectedPaths[i] {
+			t.Errorf("expected path %q, got %q", expectedPaths[i], a.path)
+		}
+		checkNaming(t, a.manifest, expectedNames[i])
+	}
+	if deps := acis[0].manifest.Dependencies; len(deps) != 0 {
+		t.Errorf("expected no dependencies for the base layer, got %v", deps)
+	}
+	deps := acis[1].manifest.Dependencies
+	if len(deps) != 1 || deps[0].ImageName != acis[0].manifest.Name {
+		t.Fatalf("expected a dependency on %q, got %v", acis[0].manifest.Name, deps)
+	}
+	if version, _ := deps[0].Labels.Get("version"); version != "1.2" {
+		t.Errorf("expected dependency version label 1.2, got %q", version)
+	}
+}
+
+func checkNaming(t *testing.T, manifest *schema.ImageManifest, name string) {
+	if manifest.Name.String() != name {
+		t.Errorf("expected name %q, got %q", name, manifest.Name)
+	}
+	if version, _ := manifest.GetLabel("version"); version != "1.2" {
+		t.Errorf("expected version label 1.2, got %q", version)
+	}
+	if source, _ := manifest.GetLabel("example.com/source"); source != "registry-1.docker.io/library/foo" {
+		t.Errorf("expected source label registry-1.docker.io/library/foo, got %q", source)
+	}
+}
+
+func TestNamingTemplatesInvalid(t *testing.T) {
+	for _, naming := range []*d2acommon.NamingTemplates{
+		{Name: "Not A Name"},
+		{Path: "../{{.Repository}}.aci"},
+		{Name: "example.com/foo"},
+	} {
+		if _, err := convert(t, convertOptions{image: testImage(nil, namingTestLayers...), config: docker2aci.CommonConfig{Naming: naming}}); err == nil {
+			t.Errorf("expected an error naming layers with %+v", naming)
+		}
+	}
+}
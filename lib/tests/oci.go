@@ -0,0 +1,196 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+)
+
+// OCIImage is an image to put in an OCI image layout. Images sharing a ref
+// name are put in a nested index, one per platform.
+type OCIImage struct {
+	Ref         string
+	Image       Docker22Image
+	Annotations map[string]string // annotations of the image manifest
+}
+
+// GenerateOCILayout writes an OCI image layout with the given images in
+// destPath.
+func GenerateOCILayout(destPath string, imgs []OCIImage) error {
+	blobsDir := path.Join(destPath, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(destPath, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return err
+	}
+
+	var refs []string
+	byRef := make(map[string][]*typesV2.ImageDescriptor)
+	for _, img := range imgs {
+		desc, err := genOCIManifest(blobsDir, img.Image, img.Annotations)
+		if err != nil {
+			return err
+		}
+		if _, ok := byRef[img.Ref]; !ok {
+			refs = append(refs, img.Ref)
+		}
+		byRef[img.Ref] = append(byRef[img.Ref], desc)
+	}
+
+	index := typesV2.ImageIndex{SchemaVersion: 2}
+	for _, ref := range refs {
+		desc := byRef[ref][0]
+		if len(byRef[ref]) > 1 {
+			nested := typesV2.ImageIndex{
+				SchemaVersion: 2,
+				MediaType:     common.MediaTypeOCIV1Index,
+				Manifests:     byRef[ref],
+			}
+			var err error
+			desc, err = writeOCIBlob(blobsDir, common.MediaTypeOCIV1Index, nested)
+			if err != nil {
+				return err
+			}
+		}
+		desc.Annotations = map[string]string{"org.opencontainers.image.ref.name": ref}
+		index.Manifests = append(index.Manifests, desc)
+	}
+
+	indexb, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(destPath, "index.json"), indexb, 0644)
+}
+
+func genOCIManifest(blobsDir string, img Docker22Image, annotations map[string]string) (*typesV2.ImageDescriptor, error) {
+	layerHashes, err := GenLayers(blobsDir, img.Layers)
+	if err != nil {
+		return nil, err
+	}
+	configHash, err := GenDocker22Config(blobsDir, img.Config, layerHashes)
+	if err != nil {
+		return nil, err
+	}
+	blobDescriptor := func(mediaType, hash string) (*typesV2.ImageManifestDigest, error) {
+		fi, err := os.Stat(path.Join(blobsDir, hash))
+		if err != nil {
+			return nil, err
+		}
+		return &typesV2.ImageManifestDigest{
+			MediaType: mediaType,
+			Size:      int(fi.Size()),
+			Digest:    "sha256:" + hash,
+		}, nil
+	}
+
+	manifest := typesV2.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     common.MediaTypeOCIV1Manifest,
+		Annotations:   annotations,
+	}
+	manifest.Config, err = blobDescriptor(common.MediaTypeOCIV1Config, configHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range layerHashes {
+		layer, err := blobDescriptor("application/vnd.oci.image.layer.v1.tar", h)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+
+	desc, err := writeOCIBlob(blobsDir, common.MediaTypeOCIV1Manifest, manifest)
+	if err != nil {
+		return nil, err
+	}
+	desc.Platform = &typesV2.ImagePlatform{
+		OS:           img.Config.OS,
+		Architecture: img.Config.Architecture,
+	}
+	return desc, nil
+}
+
+func writeOCIBlob(blobsDir, mediaType string, v interface{}) (*typesV2.ImageDescriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	hashStr := hex.EncodeToString(h[:])
+	if err := ioutil.WriteFile(path.Join(blobsDir, hashStr), b, 0644); err != nil {
+		return nil, err
+	}
+	return &typesV2.ImageDescriptor{
+		MediaType: mediaType,
+		Size:      int64(len(b)),
+		Digest:    "sha256:" + hashStr,
+	}, nil
+}
+
+// TarDir writes the contents of dir in a tarball at dest.
+func TarDir(dir, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
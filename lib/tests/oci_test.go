@@ -0,0 +1,134 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+)
+
+func TestOCILayout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	arm64 := testImage(nil)
+	arm64.Config.Architecture = "arm64"
+	layoutDir := path.Join(tmpDir, "layout")
+	err = GenerateOCILayout(layoutDir, []OCIImage{
+		{Ref: "v1", Image: testImage(nil)},
+		{Ref: "v2", Image: testImage(nil)},
+		{Ref: "v2", Image: arm64},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	layoutTar := path.Join(tmpDir, "layout.tar")
+	if err := TarDir(layoutDir, layoutTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	squashed := docker2aci.CommonConfig{Squash: true}
+	for _, file := range []string{layoutDir, layoutTar} {
+		if _, err := convert(t, convertOptions{file: file, config: squashed}); err == nil {
+			t.Errorf("%s: expected an error selecting among several refs", file)
+		} else if _, ok := err.(*d2acommon.ErrSeveralImages); !ok {
+			t.Errorf("%s: expected ErrSeveralImages, got %v", file, err)
+		}
+
+		tests := []struct {
+			image    string
+			platform string
+			arch     string
+		}{
+			{"layout:v1", "", "amd64"},
+			{"layout:v2", "linux/amd64", "amd64"},
+			{"layout:v2", "linux/arm64", "aarch64"},
+		}
+		for _, tt := range tests {
+			c, err := convert(t, convertOptions{
+				file:      file,
+				config:    squashed,
+				dockerURL: tt.image,
+				platform:  tt.platform,
+			})
+			if err != nil {
+				t.Errorf("%s: %s %s: unexpected error: %v", file, tt.image, tt.platform, err)
+				continue
+			}
+			manifest := c.manifest()
+			if arch, _ := manifest.Labels.Get("arch"); arch != tt.arch {
+				t.Errorf("%s: %s %s: expected arch %q, got %q", file, tt.image, tt.platform, tt.arch, arch)
+			}
+			if version, _ := manifest.Labels.Get("version"); version != tt.image[len("layout:"):] {
+				t.Errorf("%s: %s: unexpected version label %q", file, tt.image, version)
+			}
+		}
+
+		if _, err := convert(t, convertOptions{file: file, config: squashed, dockerURL: "layout:v2", platform: "linux/s390x"}); err == nil {
+			t.Errorf("%s: expected an error with a missing platform", file)
+		}
+		if _, err := convert(t, convertOptions{file: file, config: squashed, dockerURL: "layout:v3"}); err == nil {
+			t.Errorf("%s: expected an error with a missing ref", file)
+		}
+	}
+}
+
+func TestOCILabelsAndAnnotations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	conf := dockerImageConfig
+	conf.Labels = map[string]string{"maintainer": "foo maintainers"}
+	img := testImage(&conf)
+
+	layoutDir := path.Join(tmpDir, "layout")
+	err = GenerateOCILayout(layoutDir, []OCIImage{{
+		Ref:   "v1",
+		Image: img,
+		Annotations: map[string]string{
+			"org.opencontainers.image.source":   "https://github.com/example/foo",
+			"org.opencontainers.image.revision": "0123456789abcdef",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c, err := convert(t, convertOptions{file: layoutDir, config: docker2aci.CommonConfig{Squash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := c.manifest()
+	if maintainer := manifest.App.UserLabels["maintainer"]; maintainer != "foo maintainers" {
+		t.Errorf("expected maintainer label, got %v", manifest.App.UserLabels)
+	}
+	if source, _ := manifest.Annotations.Get("org.opencontainers.image.source"); source != "https://github.com/example/foo" {
+		t.Errorf("unexpected source annotation %q", source)
+	}
+	if revision, _ := manifest.Annotations.Get("org.opencontainers.image.revision"); revision != "0123456789abcdef" {
+		t.Errorf("unexpected revision annotation %q", revision)
+	}
+}
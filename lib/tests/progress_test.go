@@ -0,0 +1,163 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/docker2aci/pkg/progress"
+)
+
+func TestProgressEvents(t *testing.T) {
+	layers := []Layer{
+		Layer{
+			&tar.Header{
+				Name:    "thisisafile",
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("these are its contents"),
+		},
+		Layer{
+			&tar.Header{
+				Name:    "thisisadifferentfile",
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("the contents of this file are different from the last!"),
+		},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	layerHashes, err := GenLayers(tmpDir, layers)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	config := typesV2.ImageConfig{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       &dockerImageConfig,
+	}
+	configHash, err := GenDocker22Config(tmpDir, config, layerHashes)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := GenDocker22Manifest(tmpDir, configHash, layerHashes); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	imgName := "docker2aci/dockerv22test"
+	imgRef := "v0.1.0"
+	server := RunDockerRegistry(t, tmpDir, imgName, imgRef, d2acommon.MediaTypeDockerV22Manifest)
+	defer server.Close()
+
+	localUrl := path.Join(strings.TrimPrefix(server.URL, "http://"), imgName) + ":" + imgRef
+
+	outputDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	var out bytes.Buffer
+	conf := docker2aci.RemoteConfig{
+		CommonConfig: docker2aci.CommonConfig{
+			Squash:      true,
+			OutputDir:   outputDir,
+			TmpDir:      outputDir,
+			Compression: d2acommon.NoCompression,
+			Progress:    progress.NewJSONReporter(&out),
+		},
+		Insecure: d2acommon.InsecureConfig{
+			SkipVerify: true,
+			AllowHTTP:  true,
+		},
+	}
+
+	acis, err := docker2aci.ConvertRemoteRepo(localUrl, conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var events []progress.Event
+	dec := json.NewDecoder(&out)
+	for {
+		var e progress.Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("error decoding event: %v", err)
+		}
+		events = append(events, e)
+	}
+
+	seen := make(map[string][]progress.EventType)
+	var squash []progress.Event
+	for _, e := range events {
+		switch e.Type {
+		case progress.SquashStarted, progress.SquashDone:
+			squash = append(squash, e)
+		case progress.LayerDownloadProgress:
+		default:
+			seen[e.Layer] = append(seen[e.Layer], e.Type)
+		}
+		if e.Type == progress.LayerDownloadDone {
+			fi, err := os.Stat(path.Join(tmpDir, strings.TrimPrefix(e.Layer, "sha256:")))
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if e.Current != fi.Size() {
+				t.Errorf("layer %s: expected %d bytes downloaded, got %d", e.Layer, fi.Size(), e.Current)
+			}
+		}
+	}
+
+	expected := []progress.EventType{progress.LayerDownloadStarted, progress.LayerDownloadDone, progress.LayerConverted}
+	for _, h := range layerHashes {
+		got := seen["sha256:"+h]
+		if len(got) != len(expected) {
+			t.Errorf("layer %s: expected events %v, got %v", h, expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("layer %s: expected events %v, got %v", h, expected, got)
+				break
+			}
+		}
+	}
+
+	if len(squash) != 2 || squash[0].Type != progress.SquashStarted || squash[1].Type != progress.SquashDone {
+		t.Fatalf("expected squash started and done events, got %v", squash)
+	}
+	if squash[1].Path != acis[0] {
+		t.Errorf("expected squashed ACI %q, got %q", acis[0], squash[1].Path)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/pkg/progress"
+	"github.com/appc/spec/aci"
+)
+
+func TestReproducible(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	conf := dockerImageConfig
+	conf.Labels = map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	conf.ExposedPorts = map[string]struct{}{"80/tcp": {}, "53/udp": {}, "8000-8010/tcp": {}}
+	img := testImage(&conf, fileLayer("a", "a"), fileLayer("b", "b"))
+
+	saveTar := saveImageTar(t, img)
+
+	epoch := time.Unix(1500000000, 0)
+	convert := func(name string, squash bool) [][]byte {
+		outputDir := path.Join(tmpDir, name)
+		if err := os.Mkdir(outputDir, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		acis, err := docker2aci.ConvertSavedFile(saveTar, docker2aci.FileConfig{CommonConfig: docker2aci.CommonConfig{
+			Squash:          squash,
+			OutputDir:       outputDir,
+			TmpDir:          outputDir,
+			Compression:     d2acommon.GzipCompression,
+			Reproducible:    true,
+			SourceDateEpoch: epoch,
+			Progress:        progress.NewNopReporter(),
+		}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var contents [][]byte
+		for _, p := range acis {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			contents = append(contents, b)
+		}
+		return contents
+	}
+
+	for _, squash := range []bool{true, false} {
+		first := convert("first-"+strconv.FormatBool(squash), squash)
+		second := convert("second-"+strconv.FormatBool(squash), squash)
+		if len(first) != len(second) {
+			t.Fatalf("squash %v: expected %d ACIs, got %d", squash, len(first), len(second))
+		}
+		for i := range first {
+			if !bytes.Equal(first[i], second[i]) {
+				t.Errorf("squash %v: ACI %d differs from a conversion to the next", squash, i)
+			}
+
+			tr, err := aci.NewCompressedTarReader(bytes.NewReader(first[i]))
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				if hdr.ModTime.After(epoch) {
+					t.Errorf("squash %v: expected %s to be clamped to %v, got %v", squash, hdr.Name, epoch, hdr.ModTime)
+				}
+				if (hdr.Name == "manifest" || hdr.Name == "rootfs") && !hdr.ModTime.Equal(epoch) {
+					t.Errorf("squash %v: expected %s at %v, got %v", squash, hdr.Name, epoch, hdr.ModTime)
+				}
+			}
+			tr.Close()
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestResources(t *testing.T) {
+	conf := dockerImageConfig
+	conf.MemorySwap = -1
+	conf.Cpuset = "0-2,4"
+	img := testImage(&conf)
+
+	for _, squash := range []bool{true, false} {
+		for _, policy := range []d2acommon.ResourcePolicy{d2acommon.ResourcesHonor, d2acommon.ResourcesAnnotate, d2acommon.ResourcesDrop} {
+			c, err := convert(t, convertOptions{
+				image: img,
+				config: docker2aci.CommonConfig{
+					Squash:      squash,
+					Compression: d2acommon.GzipCompression,
+					Resources:   policy,
+				},
+			})
+			if err != nil {
+				t.Errorf("policy %d: unexpected error: %v", policy, err)
+				continue
+			}
+			// the cpuset can't be enforced
+			expectedWarnings := 0
+			if policy == d2acommon.ResourcesHonor {
+				expectedWarnings = 1
+			}
+			if len(c.warnings) != expectedWarnings {
+				t.Errorf("policy %d: expected %d warnings, got %v", policy, expectedWarnings, c.warnings)
+			}
+			manifest := c.manifest()
+
+			annotations := map[string]string{
+				d2acommon.AppcDockerMemory:     "12345",
+				d2acommon.AppcDockerMemorySwap: "-1",
+				d2acommon.AppcDockerCPUShares:  "9001",
+				d2acommon.AppcDockerCpuset:     "0-2,4",
+			}
+			for name, value := range annotations {
+				v, ok := manifest.Annotations.Get(name)
+				if policy == d2acommon.ResourcesDrop {
+					if ok {
+						t.Errorf("policy %d: unexpected annotation %s", policy, name)
+					}
+				} else if v != value {
+					t.Errorf("policy %d: expected annotation %s=%s, got %q", policy, name, value, v)
+				}
+			}
+
+			isolators := manifest.App.Isolators
+			if policy != d2acommon.ResourcesHonor {
+				if len(isolators) != 0 {
+					t.Errorf("policy %d: unexpected isolators %v", policy, isolators)
+				}
+				continue
+			}
+			if len(isolators) != 2 {
+				t.Errorf("expected 2 isolators, got %v", isolators)
+			}
+			if i := isolators.GetByName(types.ResourceMemoryName); i == nil {
+				t.Errorf("expected a memory isolator")
+			} else if limit := i.Value().(*types.ResourceMemory).Limit(); limit.Value() != 12345 {
+				t.Errorf("expected a memory limit of 12345, got %v", limit)
+			}
+			if i := isolators.GetByName(types.LinuxCPUSharesName); i == nil {
+				t.Errorf("expected a CPU shares isolator")
+			} else if shares := *i.Value().(*types.LinuxCPUShares); shares != 9001 {
+				t.Errorf("expected 9001 CPU shares, got %d", shares)
+			}
+			if i := isolators.GetByName(types.ResourceCPUName); i != nil {
+				t.Errorf("unexpected CPU isolator %v for the cpuset", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestConvertRootfs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	layerHashes, err := GenLayers(tmpDir, []Layer{
+		Layer{
+			&tar.Header{
+				Name:    "thisisafile",
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("these are its contents"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	layer, err := ioutil.ReadFile(path.Join(tmpDir, layerHashes[0]))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rootfsFile := path.Join(tmpDir, "rootfs.tar.gz")
+	f, err := os.Create(rootfsFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	gw := gzip.NewWriter(f)
+	gw.Write(layer)
+	gw.Close()
+	f.Close()
+
+	configb, err := json.Marshal(typesV2.ImageConfig{
+		Architecture: "arm64",
+		OS:           "linux",
+		Config:       &dockerImageConfig,
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	configFile := path.Join(tmpDir, "config.json")
+	if err := ioutil.WriteFile(configFile, configb, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	outputDir := path.Join(tmpDir, "out")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	acis, err := docker2aci.ConvertRootfs(rootfsFile, docker2aci.RootfsConfig{
+		CommonConfig: docker2aci.CommonConfig{
+			Squash:      true,
+			OutputDir:   outputDir,
+			TmpDir:      outputDir,
+			Compression: d2acommon.NoCompression,
+		},
+		DockerURL:    "example.com/foo/rootfs:1.0",
+		ConfigFile:   configFile,
+		Cmd:          []string{"bar"},
+		Env:          []string{"FOO=2", "BAR=3"},
+		ExposedPorts: []string{"53/udp"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	aciFile, err := os.Open(acis[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer aciFile.Close()
+	manifest, err := aci.ManifestFromImage(aciFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if manifest.Name != "example.com/foo/rootfs" {
+		t.Errorf("expected name example.com/foo/rootfs, got %q", manifest.Name)
+	}
+	if version, _ := manifest.Labels.Get("version"); version != "1.0" {
+		t.Errorf("expected version 1.0, got %q", version)
+	}
+	if arch, _ := manifest.Labels.Get("arch"); arch != "aarch64" {
+		t.Errorf("expected arch aarch64, got %q", arch)
+	}
+	if manifest.App == nil {
+		t.Fatalf("expected an app")
+	}
+	expectedExec := types.Exec{"/bin/sh", "-c", "echo", "bar"}
+	if !reflect.DeepEqual(manifest.App.Exec, expectedExec) {
+		t.Errorf("expected exec %v, got %v", expectedExec, manifest.App.Exec)
+	}
+	for name, value := range map[string]string{"FOO": "2", "BAR": "3"} {
+		if v, _ := manifest.App.Environment.Get(name); v != value {
+			t.Errorf("expected %s=%s, got %q", name, value, v)
+		}
+	}
+	if len(manifest.App.Ports) != 2 {
+		t.Errorf("expected 2 ports, got %v", manifest.App.Ports)
+	}
+
+	if _, err := aciFile.Seek(0, 0); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tr := tar.NewReader(aciFile)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if hdr.Name == "rootfs/thisisafile" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected rootfs/thisisafile in the ACI")
+	}
+}
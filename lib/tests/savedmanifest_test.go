@@ -0,0 +1,97 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestDockerSaveManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	saveDir := path.Join(tmpDir, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	arm64 := testImage(nil)
+	arm64.Config.Architecture = "arm64"
+	err = GenerateDockerSave(saveDir, []SavedImage{
+		{RepoTags: []string{"quay.io/coreos/foo:1.0", "quay.io/coreos/foo:latest"}, Image: testImage(nil)},
+		{RepoTags: []string{"bar:latest"}, Image: arm64},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	saveTar := path.Join(tmpDir, "save.tar")
+	if err := TarDir(saveDir, saveTar); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	squashed := docker2aci.CommonConfig{Squash: true}
+	if _, err := convert(t, convertOptions{file: saveTar, config: squashed}); err == nil {
+		t.Fatalf("expected an error selecting among several images")
+	} else if serr, ok := err.(*d2acommon.ErrSeveralImages); !ok {
+		t.Fatalf("expected ErrSeveralImages, got %v", err)
+	} else if len(serr.Images) != 3 {
+		t.Errorf("expected 3 images, got %v", serr.Images)
+	}
+
+	tests := []struct {
+		image      string
+		arch       string
+		repository string
+		version    string
+	}{
+		{"quay.io/coreos/foo:1.0", "amd64", "coreos/foo", "1.0"},
+		{"quay.io/coreos/foo", "amd64", "coreos/foo", "latest"},
+		{"bar", "aarch64", "library/bar", "latest"},
+	}
+	for _, tt := range tests {
+		c, err := convert(t, convertOptions{file: saveTar, config: squashed, dockerURL: tt.image})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.image, err)
+			continue
+		}
+		manifest := c.manifest()
+		if arch, _ := manifest.Labels.Get("arch"); arch != tt.arch {
+			t.Errorf("%s: expected arch %q, got %q", tt.image, tt.arch, arch)
+		}
+		if version, _ := manifest.Labels.Get("version"); version != tt.version {
+			t.Errorf("%s: expected version %q, got %q", tt.image, tt.version, version)
+		}
+		if repo, _ := manifest.Annotations.Get(d2acommon.AppcDockerRepository); repo != tt.repository {
+			t.Errorf("%s: expected repository %q, got %q", tt.image, tt.repository, repo)
+		}
+		expectedExec := types.Exec{"/bin/sh", "-c", "echo", "foo"}
+		if manifest.App == nil || len(manifest.App.Exec) != len(expectedExec) {
+			t.Errorf("%s: expected exec %v, got %v", tt.image, expectedExec, manifest.App)
+		}
+	}
+
+	if _, err := convert(t, convertOptions{file: saveTar, config: squashed, dockerURL: "bar:1.0"}); err == nil {
+		t.Errorf("expected an error with a missing tag")
+	}
+}
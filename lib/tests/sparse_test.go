@@ -0,0 +1,74 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+)
+
+func TestSparseFiles(t *testing.T) {
+	// data at both ends of the file, and a hole of 1MiB in between
+	sparse := append(append([]byte("head"), make([]byte, 1<<20)...), "tail"...)
+	img := testImage(nil,
+		Layer{
+			&tar.Header{
+				Name:     "var/lib/db",
+				Typeflag: tar.TypeGNUSparse,
+				Mode:     0600,
+				ModTime:  time.Now(),
+			}: sparse,
+		},
+		Layer{
+			fileHeader("etc/config"): []byte("config"),
+		},
+	)
+
+	for _, config := range []docker2aci.CommonConfig{
+		{Squash: false},
+		{Squash: true},
+		{Squash: false, Reproducible: true},
+		{Squash: true, Reproducible: true},
+	} {
+		c, err := convert(t, convertOptions{image: img, config: config})
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", config, err)
+		}
+		var found bool
+		for _, aci := range c.acis {
+			hdr, ok := aci.rootfs["/var/lib/db"]
+			if !ok {
+				continue
+			}
+			found = true
+			if hdr.PAXRecords["GNU.sparse.major"] != "1" || hdr.PAXRecords["GNU.sparse.minor"] != "0" {
+				t.Errorf("%+v: expected a PAX 1.0 sparse file, got PAX records %v", config, hdr.PAXRecords)
+			}
+			if hdr.Mode != 0600 {
+				t.Errorf("%+v: expected mode 0600, got %o", config, hdr.Mode)
+			}
+			if !bytes.Equal(aci.contents["/var/lib/db"], sparse) {
+				t.Errorf("%+v: wrong contents of the sparse file", config)
+			}
+		}
+		if !found {
+			t.Errorf("%+v: expected /var/lib/db", config)
+		}
+	}
+}
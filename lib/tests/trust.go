@@ -0,0 +1,268 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/docker2aci/lib/internal/tuf"
+)
+
+var tufRoles = []string{tuf.RoleRoot, tuf.RoleTargets, tuf.RoleSnapshot, tuf.RoleTimestamp}
+
+// TUFRepo is a minimal Notary repository whose metadata is signed with one
+// ECDSA key per role.
+type TUFRepo struct {
+	GUN     string
+	Keys    map[string]*ecdsa.PrivateKey
+	KeyIDs  map[string]string
+	Expires map[string]time.Time
+	Files   map[string][]byte
+
+	// ManifestLengths maps digests to the length of their manifest, and
+	// Version is the version of the published snapshot and timestamp.
+	ManifestLengths map[string]int64
+	Version         int
+
+	// Releases maps tags to digests in the targets/releases delegation,
+	// which is only published if set, trusted for the ReleasesPaths.
+	Releases      map[string]string
+	ReleasesPaths []string
+}
+
+func NewTUFRepo(gun string) (*TUFRepo, error) {
+	r := &TUFRepo{
+		GUN:     gun,
+		Keys:    make(map[string]*ecdsa.PrivateKey),
+		KeyIDs:  make(map[string]string),
+		Expires: make(map[string]time.Time),
+		Files:   make(map[string][]byte),
+
+		ManifestLengths: make(map[string]int64),
+		Version:         1,
+	}
+	for _, role := range append(tufRoles, tuf.RoleReleases) {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		r.Keys[role] = k
+		r.Expires[role] = time.Now().Add(24 * time.Hour)
+		id, err := tufKeyID(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		r.KeyIDs[role] = id
+	}
+	return r, nil
+}
+
+func tufPublicKey(pub *ecdsa.PublicKey) (tuf.Key, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return tuf.Key{}, err
+	}
+	k := tuf.Key{Type: tuf.KeyTypeECDSA}
+	k.Value.Public = der
+	return k, nil
+}
+
+func tufKeyID(pub *ecdsa.PublicKey) (string, error) {
+	k, err := tufPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return k.ID(), nil
+}
+
+// Sign wraps signed in a TUF envelope signed with the key of role.
+func (r *TUFRepo) Sign(role string, signed interface{}) ([]byte, error) {
+	return r.SignWith(r.Keys[role], r.KeyIDs[role], signed)
+}
+
+func (r *TUFRepo) SignWith(key *ecdsa.PrivateKey, keyID string, signed interface{}) ([]byte, error) {
+	raw, err := json.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := tuf.CanonicalJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(msg)
+	rs, ss, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	rb, sb := rs.Bytes(), ss.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+
+	return json.Marshal(tuf.Signed{
+		Signed: msg,
+		Signatures: []tuf.Signature{
+			{KeyID: keyID, Method: tuf.MethodECDSA, Signature: sig},
+		},
+	})
+}
+
+// Publish generates and signs the metadata of all roles, with targets
+// mapping tags to "sha256:<hex>" digests.
+func (r *TUFRepo) Publish(targets map[string]string) error {
+	root := tuf.Root{
+		Type:    "Root",
+		Version: 1,
+		Expires: r.Expires[tuf.RoleRoot],
+		Keys:    make(map[string]tuf.Key),
+		Roles:   make(map[string]tuf.RootRole),
+	}
+	for _, role := range tufRoles {
+		k, err := tufPublicKey(&r.Keys[role].PublicKey)
+		if err != nil {
+			return err
+		}
+		root.Keys[r.KeyIDs[role]] = k
+		root.Roles[role] = tuf.RootRole{KeyIDs: []string{r.KeyIDs[role]}, Threshold: 1}
+	}
+	rootb, err := r.Sign(tuf.RoleRoot, root)
+	if err != nil {
+		return err
+	}
+
+	tg, err := tufTargets(r.Expires[tuf.RoleTargets], targets, r.ManifestLengths)
+	if err != nil {
+		return err
+	}
+	var releasesb []byte
+	if r.Releases != nil {
+		k, err := tufPublicKey(&r.Keys[tuf.RoleReleases].PublicKey)
+		if err != nil {
+			return err
+		}
+		tg.Delegations.Keys = map[string]tuf.Key{r.KeyIDs[tuf.RoleReleases]: k}
+		tg.Delegations.Roles = []tuf.DelegationRole{{
+			RootRole: tuf.RootRole{KeyIDs: []string{r.KeyIDs[tuf.RoleReleases]}, Threshold: 1},
+			Name:     tuf.RoleReleases,
+			Paths:    r.ReleasesPaths,
+		}}
+		releases, err := tufTargets(r.Expires[tuf.RoleTargets], r.Releases, r.ManifestLengths)
+		if err != nil {
+			return err
+		}
+		if releasesb, err = r.Sign(tuf.RoleReleases, releases); err != nil {
+			return err
+		}
+	}
+	targetsb, err := r.Sign(tuf.RoleTargets, tg)
+	if err != nil {
+		return err
+	}
+
+	snap := tuf.Snapshot{
+		Type:    "Snapshot",
+		Version: r.Version,
+		Expires: r.Expires[tuf.RoleSnapshot],
+		Meta: map[string]tuf.FileMeta{
+			tuf.RoleRoot:    fileMeta(rootb),
+			tuf.RoleTargets: fileMeta(targetsb),
+		},
+	}
+	if releasesb != nil {
+		snap.Meta[tuf.RoleReleases] = fileMeta(releasesb)
+	}
+	snapb, err := r.Sign(tuf.RoleSnapshot, snap)
+	if err != nil {
+		return err
+	}
+
+	ts := tuf.Timestamp{
+		Type:    "Timestamp",
+		Version: r.Version,
+		Expires: r.Expires[tuf.RoleTimestamp],
+		Meta: map[string]tuf.FileMeta{
+			tuf.RoleSnapshot: fileMeta(snapb),
+		},
+	}
+	tsb, err := r.Sign(tuf.RoleTimestamp, ts)
+	if err != nil {
+		return err
+	}
+
+	r.Files[tuf.RoleRoot] = rootb
+	r.Files[tuf.RoleTargets] = targetsb
+	r.Files[tuf.RoleSnapshot] = snapb
+	r.Files[tuf.RoleTimestamp] = tsb
+	if releasesb != nil {
+		r.Files[tuf.RoleReleases] = releasesb
+	}
+	return nil
+}
+
+// tufTargets returns the targets metadata mapping tags to "sha256:<hex>"
+// digests, with the length of their manifest in lengths.
+func tufTargets(expires time.Time, targets map[string]string, lengths map[string]int64) (tuf.Targets, error) {
+	tg := tuf.Targets{
+		Type:    "Targets",
+		Version: 1,
+		Expires: expires,
+		Targets: make(map[string]tuf.FileMeta),
+	}
+	for tag, digest := range targets {
+		h, err := hex.DecodeString(strings.TrimPrefix(digest, "sha256:"))
+		if err != nil {
+			return tuf.Targets{}, err
+		}
+		tg.Targets[tag] = tuf.FileMeta{Length: lengths[digest], Hashes: map[string][]byte{"sha256": h}}
+	}
+	return tg, nil
+}
+
+func fileMeta(b []byte) tuf.FileMeta {
+	sum := sha256.Sum256(b)
+	return tuf.FileMeta{Length: int64(len(b)), Hashes: map[string][]byte{"sha256": sum[:]}}
+}
+
+// RunTUFServer serves the metadata of repo like a Notary server would.
+func RunTUFServer(t *testing.T, repo *TUFRepo) *httptest.Server {
+	prefix := fmt.Sprintf("/v2/%s/_trust/tuf/", repo.GUN)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("trust path requested: %s", r.URL.Path)
+		if !strings.HasPrefix(r.URL.Path, prefix) || !strings.HasSuffix(r.URL.Path, ".json") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		role := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), ".json")
+		b, ok := repo.Files[role]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	})
+	return httptest.NewServer(handler)
+}
@@ -0,0 +1,359 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+	"github.com/appc/docker2aci/lib/internal/tuf"
+	"github.com/appc/docker2aci/lib/internal/typesV2"
+	"github.com/appc/spec/aci"
+)
+
+type trustTest struct {
+	imgDir    string
+	digest    string
+	length    int64 // of the manifest
+	registry  string
+	trustDir  string
+	tufRepo   *TUFRepo
+	outputDir string
+}
+
+func setupTrustTest(t *testing.T, imgName string) *trustTest {
+	tt := &trustTest{}
+
+	var err error
+	tt.imgDir, err = ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	img := Docker22Image{
+		RepoTags: []string{"testimage:latest"},
+		Layers: []Layer{
+			Layer{
+				&tar.Header{
+					Name:    "thisisafile",
+					Mode:    0644,
+					ModTime: time.Now(),
+				}: []byte("these are its contents"),
+			},
+		},
+		Config: typesV2.ImageConfig{
+			Created:      "2016-06-02T21:43:31.291506236Z",
+			Architecture: "amd64",
+			OS:           "linux",
+			Config:       &dockerImageConfig,
+		},
+	}
+	if err := GenerateDocker22(tt.imgDir, img); err != nil {
+		t.Fatalf("%v", err)
+	}
+	manblob, err := ioutil.ReadFile(path.Join(tt.imgDir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sum := sha256.Sum256(manblob)
+	tt.digest = "sha256:" + hex.EncodeToString(sum[:])
+	tt.length = int64(len(manblob))
+
+	tt.trustDir, err = ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tt.outputDir, err = ioutil.TempDir("", "docker2aci-test-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return tt
+}
+
+func (tt *trustTest) cleanup() {
+	os.RemoveAll(tt.imgDir)
+	os.RemoveAll(tt.trustDir)
+	os.RemoveAll(tt.outputDir)
+}
+
+// newTUFRepo creates the Notary repository of gun, knowing the length of the
+// manifest of the test image.
+func (tt *trustTest) newTUFRepo(t *testing.T, gun string) *TUFRepo {
+	repo, err := NewTUFRepo(gun)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	repo.ManifestLengths[tt.digest] = tt.length
+	return repo
+}
+
+func (tt *trustTest) cacheRoot(t *testing.T, gun string, root []byte) {
+	dir := filepath.Join(tt.trustDir, "tuf", gun, "metadata")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "root.json"), root, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func (tt *trustTest) convert(imgURL, trustServer string) ([]string, error) {
+	conf := docker2aci.RemoteConfig{
+		CommonConfig: docker2aci.CommonConfig{
+			Squash:      true,
+			OutputDir:   tt.outputDir,
+			TmpDir:      tt.outputDir,
+			Compression: d2acommon.NoCompression,
+		},
+		Insecure: d2acommon.InsecureConfig{
+			SkipVerify: true,
+			AllowHTTP:  true,
+		},
+		Trust: d2acommon.TrustConfig{
+			Enabled: true,
+			Server:  trustServer,
+			Dir:     tt.trustDir,
+		},
+	}
+	return docker2aci.ConvertRemoteRepo(imgURL, conf)
+}
+
+func TestContentTrustPullsTrustedDigest(t *testing.T) {
+	imgName := "docker2aci/trusttest"
+	tt := setupTrustTest(t, imgName)
+	defer tt.cleanup()
+
+	// the registry only answers to the digest, so a pull by tag fails
+	registry := RunDockerRegistry(t, tt.imgDir, imgName, tt.digest, d2acommon.MediaTypeDockerV22Manifest)
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	gun := path.Join(host, imgName)
+
+	repo := tt.newTUFRepo(t, gun)
+	if err := repo.Publish(map[string]string{"v0.1.0": tt.digest}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	notary := RunTUFServer(t, repo)
+	defer notary.Close()
+	tt.cacheRoot(t, gun, repo.Files[tuf.RoleRoot])
+
+	acis, err := tt.convert(gun+":v0.1.0", notary.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(acis[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	manifest, err := aci.ManifestFromImage(f)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h, _ := manifest.Annotations.Get(d2acommon.AppcDockerManifestHash); h != tt.digest {
+		t.Errorf("expected manifest hash %q, got %q", tt.digest, h)
+	}
+	if v, _ := manifest.GetLabel("version"); v != "v0.1.0" {
+		t.Errorf("expected version label %q, got %q", "v0.1.0", v)
+	}
+}
+
+func TestContentTrustDelegation(t *testing.T) {
+	imgName := "docker2aci/trusttest"
+	tt := setupTrustTest(t, imgName)
+	defer tt.cleanup()
+
+	registry := RunDockerRegistry(t, tt.imgDir, imgName, tt.digest, d2acommon.MediaTypeDockerV22Manifest)
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	gun := path.Join(host, imgName)
+
+	// the delegation is trusted for all the targets, and only it has the tag
+	repo := tt.newTUFRepo(t, gun)
+	repo.Releases = map[string]string{"v0.1.0": tt.digest}
+	repo.ReleasesPaths = []string{""}
+	if err := repo.Publish(map[string]string{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	notary := RunTUFServer(t, repo)
+	defer notary.Close()
+	tt.cacheRoot(t, gun, repo.Files[tuf.RoleRoot])
+
+	if _, err := tt.convert(gun+":v0.1.0", notary.URL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestContentTrustFailures(t *testing.T) {
+	imgName := "docker2aci/trusttest"
+
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, repo *TUFRepo, digest string)
+		noCache bool
+		errText string
+	}{
+		{
+			name:    "no cached root",
+			noCache: true,
+			errText: "no cached trust data",
+		},
+		{
+			name: "expired timestamp",
+			modify: func(t *testing.T, repo *TUFRepo, digest string) {
+				repo.Expires[tuf.RoleTimestamp] = time.Now().Add(-time.Hour)
+				if err := repo.Publish(map[string]string{"v0.1.0": digest}); err != nil {
+					t.Fatalf("%v", err)
+				}
+			},
+			errText: "expired",
+		},
+		{
+			name: "unknown tag",
+			modify: func(t *testing.T, repo *TUFRepo, digest string) {
+				if err := repo.Publish(map[string]string{"v0.2.0": digest}); err != nil {
+					t.Fatalf("%v", err)
+				}
+			},
+			errText: "no trust data for tag",
+		},
+		{
+			name: "targets signed by the wrong key",
+			modify: func(t *testing.T, repo *TUFRepo, digest string) {
+				targets := tuf.Targets{
+					Type:    "Targets",
+					Version: 2,
+					Expires: time.Now().Add(time.Hour),
+					Targets: map[string]tuf.FileMeta{},
+				}
+				b, err := repo.SignWith(repo.Keys[tuf.RoleSnapshot], repo.KeyIDs[tuf.RoleTargets], targets)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				repo.Files[tuf.RoleTargets] = b
+			},
+			errText: "targets",
+		},
+		{
+			name: "wrong manifest length",
+			modify: func(t *testing.T, repo *TUFRepo, digest string) {
+				repo.ManifestLengths[digest]--
+				if err := repo.Publish(map[string]string{"v0.1.0": digest}); err != nil {
+					t.Fatalf("%v", err)
+				}
+			},
+			errText: "manifest length doesn't match trusted length",
+		},
+		{
+			name: "delegation restricted to another path",
+			modify: func(t *testing.T, repo *TUFRepo, digest string) {
+				repo.Releases = map[string]string{"v0.1.0": digest}
+				repo.ReleasesPaths = []string{"other/"}
+				if err := repo.Publish(map[string]string{}); err != nil {
+					t.Fatalf("%v", err)
+				}
+			},
+			errText: `targets/releases isn't trusted for target "v0.1.0"`,
+		},
+	}
+
+	for _, test := range tests {
+		tt := setupTrustTest(t, imgName)
+
+		registry := RunDockerRegistry(t, tt.imgDir, imgName, tt.digest, d2acommon.MediaTypeDockerV22Manifest)
+		host := strings.TrimPrefix(registry.URL, "http://")
+		gun := path.Join(host, imgName)
+
+		repo := tt.newTUFRepo(t, gun)
+		if err := repo.Publish(map[string]string{"v0.1.0": tt.digest}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !test.noCache {
+			tt.cacheRoot(t, gun, repo.Files[tuf.RoleRoot])
+		}
+		if test.modify != nil {
+			test.modify(t, repo, tt.digest)
+		}
+		notary := RunTUFServer(t, repo)
+
+		_, err := tt.convert(gun+":v0.1.0", notary.URL)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !strings.Contains(err.Error(), test.errText) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.errText, err)
+		}
+
+		notary.Close()
+		registry.Close()
+		tt.cleanup()
+	}
+}
+
+func TestContentTrustRollback(t *testing.T) {
+	imgName := "docker2aci/trusttest"
+	tt := setupTrustTest(t, imgName)
+	defer tt.cleanup()
+
+	registry := RunDockerRegistry(t, tt.imgDir, imgName, tt.digest, d2acommon.MediaTypeDockerV22Manifest)
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	gun := path.Join(host, imgName)
+
+	repo := tt.newTUFRepo(t, gun)
+	repo.Version = 2
+	if err := repo.Publish(map[string]string{"v0.1.0": tt.digest}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	notary := RunTUFServer(t, repo)
+	defer notary.Close()
+	tt.cacheRoot(t, gun, repo.Files[tuf.RoleRoot])
+
+	if _, err := tt.convert(gun+":v0.1.0", notary.URL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, role := range []string{tuf.RoleTimestamp, tuf.RoleSnapshot} {
+		b, err := ioutil.ReadFile(filepath.Join(tt.trustDir, "tuf", gun, "metadata", role+".json"))
+		if err != nil {
+			t.Fatalf("expected %s to be cached: %v", role, err)
+		}
+		if string(b) != string(repo.Files[role]) {
+			t.Errorf("expected the published %s to be cached", role)
+		}
+	}
+
+	// the server now serves older metadata
+	repo.Version = 1
+	if err := repo.Publish(map[string]string{"v0.1.0": tt.digest}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, err := tt.convert(gun+":v0.1.0", notary.URL)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(err.Error(), "older than trusted version") {
+		t.Errorf("expected a rollback error, got %v", err)
+	}
+}
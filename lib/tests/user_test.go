@@ -0,0 +1,112 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	d2acommon "github.com/appc/docker2aci/lib/common"
+)
+
+// userTestLayers have passwd and group files, the group file being replaced
+// by a symlink in the upper layer.
+var userTestLayers = []Layer{
+	Layer{
+		&tar.Header{
+			Name:    "etc/passwd",
+			Mode:    0644,
+			ModTime: time.Now(),
+		}: []byte("root:x:0:0:root:/root:/bin/sh\n" +
+			"nginx:x:101:101:nginx:/var/lib/nginx:/sbin/nologin\n" +
+			"app:x:1000:1000::/home/app:/bin/sh\n"),
+		&tar.Header{
+			Name:    "etc/group",
+			Mode:    0644,
+			ModTime: time.Now(),
+		}: []byte("root:x:0:\n"),
+	},
+	Layer{
+		&tar.Header{
+			Name:     "etc/group",
+			Typeflag: tar.TypeSymlink,
+			Linkname: "../usr/share/group",
+			ModTime:  time.Now(),
+		}: nil,
+		&tar.Header{
+			Name:    "usr/share/group",
+			Mode:    0644,
+			ModTime: time.Now(),
+		}: []byte("root:x:0:\n" +
+			"audio:x:29:nginx\n" +
+			"www:x:33:nginx,app\n" +
+			"nginx:x:101:\n"),
+	},
+}
+
+func TestResolveUser(t *testing.T) {
+	tests := []struct {
+		user   string
+		squash bool
+
+		expectedUser     string
+		expectedGroup    string
+		expectedGIDs     []int
+		expectedWarnings int
+	}{
+		{"nginx", true, "101", "101", []int{29, 33}, 0},
+		{"nginx", false, "101", "101", []int{29, 33}, 0},
+		{"nginx:www", true, "101", "33", nil, 0},
+		{"1000", true, "1000", "1000", []int{33}, 0},
+		{"2000", true, "2000", "0", nil, 1},
+		{"app:2000", true, "1000", "2000", nil, 0},
+		{"missing", true, "missing", "0", nil, 1},
+		{"nginx:missing", true, "101", "missing", nil, 1},
+	}
+
+	for _, tt := range tests {
+		conf := dockerImageConfig
+		conf.User = tt.user
+		c, err := convert(t, convertOptions{
+			image: testImage(&conf, userTestLayers...),
+			config: docker2aci.CommonConfig{
+				Squash:      tt.squash,
+				Compression: d2acommon.GzipCompression,
+			},
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.user, err)
+			continue
+		}
+
+		manifest := c.manifest()
+		app := manifest.App
+		if app.User != tt.expectedUser || app.Group != tt.expectedGroup {
+			t.Errorf("%s: expected %s:%s, got %s:%s", tt.user, tt.expectedUser, tt.expectedGroup, app.User, app.Group)
+		}
+		if !reflect.DeepEqual(app.SupplementaryGIDs, tt.expectedGIDs) {
+			t.Errorf("%s: expected supplementary gids %v, got %v", tt.user, tt.expectedGIDs, app.SupplementaryGIDs)
+		}
+		if user, _ := manifest.Annotations.Get(d2acommon.AppcDockerUser); user != tt.user {
+			t.Errorf("%s: expected annotation %q, got %q", tt.user, tt.user, user)
+		}
+		if len(c.warnings) != tt.expectedWarnings {
+			t.Errorf("%s: expected %d warnings, got %v", tt.user, tt.expectedWarnings, c.warnings)
+		}
+	}
+}
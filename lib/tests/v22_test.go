@@ -48,30 +48,6 @@ var osArchTuples = []osArchTuple{
 	{"darwin", "386"},
 }
 
-// dockerImageConfig defines the common image configuration.
-var dockerImageConfig = typesV2.ImageConfigConfig{
-	User:       "",
-	Memory:     12345,
-	MemorySwap: 0,
-	CpuShares:  9001,
-	ExposedPorts: map[string]struct{}{
-		"80": struct{}{},
-	},
-	Env: []string{
-		"FOO=1",
-	},
-	Entrypoint: []string{
-		"/bin/sh",
-		"-c",
-		"echo",
-	},
-	Cmd: []string{
-		"foo",
-	},
-	Volumes:    nil,
-	WorkingDir: "/",
-}
-
 // testDocker22Images generates the Docker images v22 for all supported
 // os/arch pairs and calls the passed testing function.
 func testDocker22Images(layers []Layer, fn func(Docker22Image)) {
@@ -131,7 +107,7 @@ func expectedManifest(registryUrl, imageName, imageOs, imageArch string) schema.
 			WorkingDirectory: "/",
 			Ports: []types.Port{
 				{
-					Name:            "80",
+					Name:            "80-tcp",
 					Protocol:        "tcp",
 					Port:            80,
 					Count:           1,
@@ -177,6 +153,14 @@ func expectedManifest(registryUrl, imageName, imageOs, imageArch string) schema.
 				Name:  *types.MustACIdentifier("appc.io/docker/cmd"),
 				Value: "[\"foo\"]",
 			},
+			{
+				Name:  *types.MustACIdentifier("appc.io/docker/memory"),
+				Value: "12345",
+			},
+			{
+				Name:  *types.MustACIdentifier("appc.io/docker/cpushares"),
+				Value: "9001",
+			},
 		},
 	}
 }
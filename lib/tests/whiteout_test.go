@@ -0,0 +1,159 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+)
+
+// whiteoutTestLayers use the whiteouts of the OCI image spec and of
+// overlayfs.
+var whiteoutTestLayers = []Layer{
+	Layer{
+		dirHeader("a"):       nil,
+		dirHeader("a/b"):     nil,
+		fileHeader("a/f1"):   []byte("f1"),
+		fileHeader("a/b/f2"): []byte("f2"),
+		dirHeader("c"):       nil,
+		fileHeader("c/f3"):   []byte("f3"),
+		fileHeader("c/f4"):   []byte("f4"),
+		dirHeader("d"):       nil,
+		fileHeader("d/f5"):   []byte("f5"),
+		fileHeader("e"):      []byte("e"),
+		fileHeader("keep"):   []byte("old"),
+		dirHeader("o"):       nil,
+		fileHeader("o/f6"):   []byte("f6"),
+	},
+	Layer{
+		// opaque directory, with a new file
+		fileHeader("a/.wh..wh..opq"): nil,
+		fileHeader("a/new"):          []byte("new"),
+		fileHeader("c/.wh.f3"):       nil,
+		// overlayfs whiteout of a directory
+		&tar.Header{Name: "d", Typeflag: tar.TypeChar, ModTime: time.Now()}: nil,
+		fileHeader("keep"): []byte("new contents"),
+	},
+	Layer{
+		// overlayfs opaque directory
+		&tar.Header{
+			Name:       "o/",
+			Typeflag:   tar.TypeDir,
+			Mode:       0755,
+			ModTime:    time.Now(),
+			PAXRecords: map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "y"},
+		}: nil,
+		fileHeader("o/f7"):   []byte("f7"),
+		fileHeader(".wh.e"):  nil,
+		dirHeader("a/b"):     nil,
+		fileHeader("a/b/f8"): []byte("f8"),
+	},
+}
+
+// referenceOverlay returns the contents of the files of layers stacked like
+// an overlayfs mount with the OCI whiteouts, by path.
+func referenceOverlay(layers []Layer) map[string]string {
+	files := make(map[string]string)
+	remove := func(p string, self bool) {
+		for f := range files {
+			if self && f == p || strings.HasPrefix(f, p+"/") {
+				delete(files, f)
+			}
+		}
+	}
+
+	for _, l := range layers {
+		added := make(map[string]string)
+		for hdr, contents := range l {
+			p := path.Clean("/" + hdr.Name)
+			dir, base := path.Split(p)
+			switch {
+			case base == ".wh..wh..opq":
+				remove(path.Clean(dir), false)
+			case strings.HasPrefix(base, ".wh."):
+				remove(path.Join(dir, strings.TrimPrefix(base, ".wh.")), true)
+			case hdr.Typeflag == tar.TypeChar && hdr.Devmajor == 0 && hdr.Devminor == 0:
+				remove(p, true)
+			default:
+				if hdr.PAXRecords["SCHILY.xattr.trusted.overlay.opaque"] == "y" {
+					remove(p, false)
+				}
+				added[p] = string(contents)
+			}
+		}
+		for p, contents := range added {
+			files[p] = contents
+		}
+	}
+	return files
+}
+
+func TestWhiteouts(t *testing.T) {
+	img := testImage(nil, whiteoutTestLayers...)
+	expected := referenceOverlay(whiteoutTestLayers)
+	var expectedPaths []string
+	for p := range expected {
+		expectedPaths = append(expectedPaths, p)
+	}
+	sort.Strings(expectedPaths)
+
+	// the stdio symlinks are added to every layer
+	withoutDev := func(paths []string) []string {
+		var filtered []string
+		for _, p := range paths {
+			if p != "/dev" && !strings.HasPrefix(p, "/dev/") {
+				filtered = append(filtered, p)
+			}
+		}
+		sort.Strings(filtered)
+		return filtered
+	}
+
+	c, err := convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Squash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var squashedPaths []string
+	for p, hdr := range c.acis[0].rootfs {
+		squashedPaths = append(squashedPaths, p)
+		if contents, ok := expected[p]; ok && hdr.Size != int64(len(contents)) {
+			t.Errorf("expected %s to have %d bytes, got %d", p, len(contents), hdr.Size)
+		}
+		if hdr.Typeflag == tar.TypeChar {
+			t.Errorf("unexpected whiteout %s", p)
+		}
+		if _, ok := hdr.PAXRecords["SCHILY.xattr.trusted.overlay.opaque"]; ok {
+			t.Errorf("unexpected opaque xattr on %s", p)
+		}
+	}
+	if got := withoutDev(squashedPaths); strings.Join(got, " ") != strings.Join(expectedPaths, " ") {
+		t.Errorf("squashed image: expected files %v, got %v", expectedPaths, got)
+	}
+
+	c, err = convert(t, convertOptions{image: img})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pwl := c.manifest().PathWhitelist
+	if got := withoutDev(pwl); strings.Join(got, " ") != strings.Join(expectedPaths, " ") {
+		t.Errorf("path whitelist: expected %v, got %v", expectedPaths, got)
+	}
+}
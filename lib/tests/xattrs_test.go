@@ -0,0 +1,102 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"archive/tar"
+	"strings"
+	"testing"
+	"time"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+)
+
+// capability is the security.capability of a file with cap_net_raw+ep
+const capability = "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+func TestExtendedMetadata(t *testing.T) {
+	longName := strings.Repeat("very-long-directory-name/", 8) + "file"
+	records := map[string]map[string]string{
+		"/bin/ping": {"SCHILY.xattr.security.capability": capability},
+		"/srv/shared": {
+			"SCHILY.xattr.system.posix_acl_access": "\x02\x00\x00\x00\x01\x00\x07\x00",
+			"SCHILY.acl.access":                    "user::rwx,user:1000:rwx,group::r-x,mask::rwx,other::r-x",
+		},
+	}
+	img := testImage(nil,
+		Layer{
+			&tar.Header{
+				Name:       "bin/ping",
+				Mode:       0755,
+				ModTime:    time.Now(),
+				PAXRecords: records["/bin/ping"],
+			}: []byte("ping"),
+			&tar.Header{
+				Name:       "srv/shared/",
+				Typeflag:   tar.TypeDir,
+				Mode:       0775,
+				ModTime:    time.Now(),
+				PAXRecords: records["/srv/shared"],
+			}: nil,
+			&tar.Header{
+				Name:    longName,
+				Mode:    0644,
+				ModTime: time.Now(),
+			}: []byte("long"),
+			&tar.Header{
+				Name:     "bin/ping6",
+				Typeflag: tar.TypeLink,
+				Linkname: "bin/ping",
+				ModTime:  time.Now(),
+			}: nil,
+		},
+		Layer{
+			// ping is removed but its hard link is kept
+			&tar.Header{
+				Name:    "bin/.wh.ping",
+				ModTime: time.Now(),
+			}: nil,
+		},
+	)
+
+	for _, squash := range []bool{false, true} {
+		c, err := convert(t, convertOptions{image: img, config: docker2aci.CommonConfig{Squash: squash}})
+		if err != nil {
+			t.Fatalf("squash %v: unexpected error: %v", squash, err)
+		}
+		rootfs := c.acis[0].rootfs
+		if squash {
+			// the hard link gets the file and its metadata
+			records["/bin/ping6"] = records["/bin/ping"]
+			delete(records, "/bin/ping")
+		}
+
+		for p, expected := range records {
+			hdr, ok := rootfs[p]
+			if !ok {
+				t.Errorf("squash %v: expected %s", squash, p)
+				continue
+			}
+			for k, v := range expected {
+				if hdr.PAXRecords[k] != v {
+					t.Errorf("squash %v: expected PAX record %s=%q on %s, got %q", squash, k, v, p, hdr.PAXRecords[k])
+				}
+			}
+		}
+		if _, ok := rootfs["/"+longName]; !ok {
+			t.Errorf("squash %v: expected %s", squash, longName)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker2aci
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/appc/docker2aci/pkg/progress"
+	appctypes "github.com/appc/spec/schema/types"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+}
+
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// resolveUser sets the numeric user, group and supplementary groups of app
+// from dockerUser, the USER of the image, looking names up in its /etc/passwd
+// and /etc/group like Docker does. When only a user is given, its primary
+// group and the groups listing it as a member are used.
+//
+// Names and uids that can't be found are kept as is, with a warning. Users
+// that can't be found get the group 0 if no group is given, like in Docker.
+func resolveUser(app *appctypes.App, dockerUser string, fs *flattenedRootfs, reporter progress.Reporter) error {
+	if dockerUser == "" {
+		return nil
+	}
+	parts := strings.SplitN(dockerUser, ":", 2)
+	userSpec := parts[0]
+	groupSpec := ""
+	if len(parts) == 2 {
+		groupSpec = parts[1]
+	}
+
+	users, err := readPasswd(fs)
+	if err != nil {
+		return err
+	}
+	var pw *passwdEntry
+	if uid, err := strconv.Atoi(userSpec); err == nil {
+		for i := range users {
+			if users[i].uid == uid {
+				pw = &users[i]
+				break
+			}
+		}
+		if pw == nil {
+			progress.Warnf(reporter, "uid %d not found in %s, keeping it as is", uid, passwdFile)
+		}
+	} else {
+		for i := range users {
+			if users[i].name == userSpec {
+				pw = &users[i]
+				break
+			}
+		}
+		if pw == nil {
+			progress.Warnf(reporter, "user %q not found in %s, keeping it as is", userSpec, passwdFile)
+		}
+	}
+	if pw != nil {
+		app.User = strconv.Itoa(pw.uid)
+	}
+
+	// groups are only needed for group names and supplementary groups
+	var groups []groupEntry
+	if _, err := strconv.Atoi(groupSpec); err != nil || (groupSpec == "" && pw != nil) {
+		if groups, err = readGroup(fs); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case groupSpec != "":
+		if _, err := strconv.Atoi(groupSpec); err == nil {
+			app.Group = groupSpec
+			break
+		}
+		found := false
+		for _, g := range groups {
+			if g.name == groupSpec {
+				app.Group = strconv.Itoa(g.gid)
+				found = true
+				break
+			}
+		}
+		if !found {
+			progress.Warnf(reporter, "group %q not found in %s, keeping it as is", groupSpec, groupFile)
+		}
+	case pw != nil:
+		app.Group = strconv.Itoa(pw.gid)
+		gids := make(map[int]bool)
+		for _, g := range groups {
+			if g.gid == pw.gid || gids[g.gid] {
+				continue
+			}
+			for _, m := range g.members {
+				if m == pw.name {
+					gids[g.gid] = true
+					app.SupplementaryGIDs = append(app.SupplementaryGIDs, g.gid)
+					break
+				}
+			}
+		}
+		sort.Ints(app.SupplementaryGIDs)
+	default:
+		app.Group = "0"
+	}
+
+	return nil
+}
+
+// readPasswd reads the users in the /etc/passwd file of the image, if any.
+func readPasswd(fs *flattenedRootfs) ([]passwdEntry, error) {
+	var users []passwdEntry
+	err := readColonFile(fs, passwdFile, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return
+		}
+		users = append(users, passwdEntry{name: fields[0], uid: uid, gid: gid})
+	})
+	return users, err
+}
+
+// readGroup reads the groups in the /etc/group file of the image, if any.
+func readGroup(fs *flattenedRootfs) ([]groupEntry, error) {
+	var groups []groupEntry
+	err := readColonFile(fs, groupFile, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		g := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			g.members = strings.Split(fields[3], ",")
+		}
+		groups = append(groups, g)
+	})
+	return groups, err
+}
+
+// readColonFile calls parseLine with the fields of each line of a file in the
+// format of /etc/passwd, skipping comments. A missing file has no lines.
+func readColonFile(fs *flattenedRootfs, p string, parseLine func([]string)) error {
+	b, err := fs.readFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", p, err)
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parseLine(strings.Split(line, ":"))
+	}
+	return s.Err()
+}
@@ -15,15 +15,21 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/appc/docker2aci/lib"
 	"github.com/appc/docker2aci/lib/common"
 	"github.com/appc/docker2aci/pkg/log"
+	"github.com/appc/docker2aci/pkg/progress"
 
 	"github.com/appc/spec/aci"
 	"github.com/appc/spec/schema"
@@ -32,21 +38,88 @@ import (
 var (
 	flagNoSquash           bool
 	flagImage              string
+	flagPlatform           string
+	flagAllImages          bool
+	flagImageFilter        string
 	flagDebug              bool
 	flagInsecureSkipVerify bool
 	flagInsecureAllowHTTP  bool
+	flagContentTrust       bool
+	flagTrustServer        string
+	flagTrustDir           string
 	flagCompression        string
+	flagResources          string
+	flagLint               string
+	flagMetadataMapping    string
+	flagNameTemplate       string
+	flagVersionTemplate    string
+	flagLabelTemplate      stringSlice
+	flagPathTemplate       string
+	flagDockerCompat       bool
+	flagStdioSymlink       stringSlice
+	flagExclude            stringSlice
+	flagInclude            stringSlice
+	flagReproducible       bool
+	flagProgress           string
 	flagVersion            bool
+	flagJSON               bool
+	flagRootfs             bool
+	flagConfig             string
+	flagEntrypoint         string
+	flagCmd                string
+	flagEnv                stringSlice
+	flagPort               stringSlice
+	flagWorkdir            string
+	flagUser               string
 )
 
+// stringSlice is a flag that can be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func init() {
 	flag.BoolVar(&flagNoSquash, "nosquash", false, "Don't squash layers and output every layer as ACI")
 	flag.StringVar(&flagImage, "image", "", "When converting a local file, it selects a particular image to convert. Format: IMAGE_NAME[:TAG]")
+	flag.StringVar(&flagPlatform, "platform", "", "When converting an OCI image layout, it selects the image for a particular platform. Format: OS/ARCH[/VARIANT]")
+	flag.BoolVar(&flagAllImages, "all-images", false, "When converting a local file, it converts all its images and tags in one pass")
+	flag.StringVar(&flagImageFilter, "image-filter", "", "With --all-images, it only converts the images matching one of these comma-separated patterns. Format: IMAGE_NAME[:TAG], with shell wildcards")
 	flag.BoolVar(&flagDebug, "debug", false, "Enables debug messages")
 	flag.BoolVar(&flagInsecureSkipVerify, "insecure-skip-verify", false, "Don't verify certificates when fetching images")
 	flag.BoolVar(&flagInsecureAllowHTTP, "insecure-allow-http", false, "Uses unencrypted connections when fetching images")
+	flag.BoolVar(&flagContentTrust, "content-trust", false, "Resolve tags through Docker Content Trust and pull by the trusted digest")
+	flag.StringVar(&flagTrustServer, "trust-server", os.Getenv("DOCKER_CONTENT_TRUST_SERVER"), "Notary server to use with --content-trust; defaults to the registry's")
+	flag.StringVar(&flagTrustDir, "trust-dir", docker2aci.GetDefaultTrustDir(), "Directory containing the cached content trust root metadata")
 	flag.StringVar(&flagCompression, "compression", "gzip", "Type of compression to use; allowed values: gzip, none")
+	flag.StringVar(&flagResources, "resources", "annotate", "What to do with the memory and CPU settings of the image; allowed values: honor (set isolators), annotate (only keep them as annotations), drop")
+	flag.StringVar(&flagLint, "lint", "none", "Whether to check that the converted image can run, like by the lint command; allowed values: none, warn (print the problems found), fail (also fail on errors)")
+	flag.StringVar(&flagMetadataMapping, "metadata-mapping", "", "JSON file of rules renaming, dropping, copying or setting the labels and annotations of the image")
+	flag.StringVar(&flagNameTemplate, "name-template", "", "Template of the names of the generated ACIs, like example.com/apps/{{.Repository}}")
+	flag.StringVar(&flagVersionTemplate, "version-template", "", "Template of the version label of the generated ACIs")
+	flag.Var(&flagLabelTemplate, "label-template", "Template of an extra label of the generated ACIs, can be repeated. Format: NAME=TEMPLATE")
+	flag.StringVar(&flagPathTemplate, "path-template", "", "Template of the paths of the generated ACI files, relative to the output directory")
+	flag.BoolVar(&flagDockerCompat, "docker-compat", false, "Emulate Docker's defaults in the converted image: a default PATH, the working directory and the /etc/hosts, /etc/resolv.conf and /etc/hostname mount targets")
+	flag.Var(&flagStdioSymlink, "stdio-symlink", "With --docker-compat, target of one of the /dev/stdin, /dev/stdout, /dev/stderr and /dev/fd symlinks, can be repeated. Format: LINK=TARGET")
+	flag.Var(&flagExclude, "exclude", "Leave the files matching this pattern, and everything below them, out of the converted image, can be repeated. Format: absolute path with shell wildcards")
+	flag.Var(&flagInclude, "include", "With --exclude, keep the files matching this pattern below an excluded path, can be repeated. Format: absolute path with shell wildcards")
+	flag.BoolVar(&flagReproducible, "reproducible", false, "Generate the same ACI bytes for the same image, with the timestamps clamped to $SOURCE_DATE_EPOCH if set")
+	flag.StringVar(&flagProgress, "progress", "bars", "How to report the conversion progress on stderr; allowed values: bars, json, none")
 	flag.BoolVar(&flagVersion, "version", false, "Print version")
+	flag.BoolVar(&flagRootfs, "rootfs", false, "Convert FILEPATH as a plain rootfs tarball, like the ones generated by docker export, with --image naming the image")
+	flag.StringVar(&flagConfig, "config", "", "With --rootfs, Docker or OCI image config file to use")
+	flag.StringVar(&flagEntrypoint, "entrypoint", "", "With --rootfs, entrypoint of the image, as a JSON array or separated by spaces")
+	flag.StringVar(&flagCmd, "cmd", "", "With --rootfs, command of the image, as a JSON array or separated by spaces")
+	flag.Var(&flagEnv, "env", "With --rootfs, environment variable of the image, can be repeated. Format: NAME=VALUE")
+	flag.Var(&flagPort, "port", "With --rootfs, port exposed by the image, can be repeated. Format: PORT[/PROTOCOL]")
+	flag.StringVar(&flagWorkdir, "workdir", "", "With --rootfs, working directory of the image")
+	flag.StringVar(&flagUser, "user", "", "With --rootfs, user running the image")
 }
 
 func printVersion() {
@@ -54,7 +127,27 @@ func printVersion() {
 	fmt.Println("appc version", docker2aci.AppcVersion)
 }
 
-func runDocker2ACI(arg string) error {
+// stdinPath is the file path designating the standard input.
+const stdinPath = "-"
+
+// daemonPrefix prefixes the images stored in the Docker daemon.
+const daemonPrefix = "docker-daemon:"
+
+// imageSource is the image given on the command line along with the
+// configuration to convert it.
+type imageSource struct {
+	remote       bool
+	daemon       bool
+	dockerURL    string
+	filePath     string
+	rootfs       bool
+	remoteConfig docker2aci.RemoteConfig
+	daemonConfig docker2aci.DaemonConfig
+	rootfsConfig docker2aci.RootfsConfig
+	fileConfig   docker2aci.FileConfig
+}
+
+func getImageSource(arg string) (*imageSource, error) {
 	debug := log.NewNopLogger()
 	info := log.NewStdLogger(os.Stderr)
 
@@ -64,11 +157,10 @@ func runDocker2ACI(arg string) error {
 
 	squash := !flagNoSquash
 
-	var aciLayerPaths []string
 	// try to convert a local file
 	u, err := url.Parse(arg)
 	if err != nil {
-		return fmt.Errorf("error parsing argument: %v", err)
+		return nil, fmt.Errorf("error parsing argument: %v", err)
 	}
 
 	var compression common.Compression
@@ -79,20 +171,145 @@ func runDocker2ACI(arg string) error {
 	case "gzip":
 		compression = common.GzipCompression
 	default:
-		return fmt.Errorf("unknown compression method: %s", flagCompression)
+		return nil, fmt.Errorf("unknown compression method: %s", flagCompression)
+	}
+
+	var resources common.ResourcePolicy
+
+	switch flagResources {
+	case "honor":
+		resources = common.ResourcesHonor
+	case "annotate":
+		resources = common.ResourcesAnnotate
+	case "drop":
+		resources = common.ResourcesDrop
+	default:
+		return nil, fmt.Errorf("unknown resource policy: %s", flagResources)
+	}
+
+	var lint common.LintPolicy
+	switch flagLint {
+	case "none":
+		lint = common.LintNone
+	case "warn":
+		lint = common.LintWarn
+	case "fail":
+		lint = common.LintFail
+	default:
+		return nil, fmt.Errorf("unknown lint policy: %s", flagLint)
+	}
+
+	var mapping *common.MetadataMapping
+	if flagMetadataMapping != "" {
+		mapping, err = common.LoadMetadataMapping(flagMetadataMapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var naming *common.NamingTemplates
+	if flagNameTemplate != "" || flagVersionTemplate != "" || len(flagLabelTemplate) > 0 || flagPathTemplate != "" {
+		naming = &common.NamingTemplates{
+			Name:    flagNameTemplate,
+			Version: flagVersionTemplate,
+			Path:    flagPathTemplate,
+		}
+		for _, l := range flagLabelTemplate {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid label template %q, expected NAME=TEMPLATE", l)
+			}
+			if naming.Labels == nil {
+				naming.Labels = make(map[string]string)
+			}
+			naming.Labels[parts[0]] = parts[1]
+		}
+		if err := naming.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var compat *common.DockerCompat
+	if flagDockerCompat {
+		compat = &common.DockerCompat{}
+		for _, l := range flagStdioSymlink {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid stdio symlink %q, expected LINK=TARGET", l)
+			}
+			if compat.StdioSymlinks == nil {
+				compat.StdioSymlinks = make(map[string]string)
+			}
+			compat.StdioSymlinks[parts[0]] = parts[1]
+		}
+		if err := compat.Validate(); err != nil {
+			return nil, err
+		}
+	} else if len(flagStdioSymlink) > 0 {
+		return nil, fmt.Errorf("--stdio-symlink needs --docker-compat")
+	}
+
+	var filter *common.PathFilter
+	if len(flagExclude) > 0 {
+		filter = &common.PathFilter{Exclude: flagExclude, Include: flagInclude}
+		if err := filter.Validate(); err != nil {
+			return nil, err
+		}
+	} else if len(flagInclude) > 0 {
+		return nil, fmt.Errorf("--include needs --exclude")
+	}
+
+	var sourceDateEpoch time.Time
+	if flagReproducible {
+		if e := os.Getenv("SOURCE_DATE_EPOCH"); e != "" {
+			secs, err := strconv.ParseInt(e, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", e, err)
+			}
+			sourceDateEpoch = time.Unix(secs, 0)
+		}
+	}
+
+	var reporter progress.Reporter
+
+	switch flagProgress {
+	case "bars":
+		reporter = progress.NewBarReporter(os.Stderr)
+	case "json":
+		reporter = progress.NewJSONReporter(os.Stderr)
+	case "none":
+		reporter = progress.NewNopReporter()
+	default:
+		return nil, fmt.Errorf("unknown progress output: %s", flagProgress)
 	}
+	reporter = summaryReporter(reporter)
 
 	cfg := docker2aci.CommonConfig{
-		Squash:      squash,
-		OutputDir:   ".",
-		TmpDir:      os.TempDir(),
-		Compression: compression,
-		Debug:       debug,
-		Info:        info,
+		Squash:          squash,
+		OutputDir:       ".",
+		TmpDir:          os.TempDir(),
+		Compression:     compression,
+		Resources:       resources,
+		MetadataMapping: mapping,
+		Naming:          naming,
+		DockerCompat:    compat,
+		PathFilter:      filter,
+		Lint:            lint,
+		Reproducible:    flagReproducible,
+		SourceDateEpoch: sourceDateEpoch,
+		Debug:           debug,
+		Info:            info,
+		Progress:        reporter,
 	}
 	if u.Scheme == "docker" {
 		if flagImage != "" {
-			return fmt.Errorf("flag --image works only with files.")
+			return nil, fmt.Errorf("flag --image works only with files.")
+		}
+		if flagPlatform != "" {
+			return nil, fmt.Errorf("flag --platform works only with files.")
+		}
+		if flagAllImages || flagRootfs {
+			return nil, fmt.Errorf("flags --all-images and --rootfs work only with files.")
 		}
 		dockerURL := strings.TrimPrefix(arg, "docker://")
 
@@ -101,28 +318,146 @@ func runDocker2ACI(arg string) error {
 		var username, password string
 		username, password, err = docker2aci.GetDockercfgAuth(indexServer)
 		if err != nil {
-			return fmt.Errorf("error reading .dockercfg file: %v", err)
+			return nil, fmt.Errorf("error reading .dockercfg file: %v", err)
 		}
-		remoteConfig := docker2aci.RemoteConfig{
-			CommonConfig: cfg,
-			Username:     username,
-			Password:     password,
-			Insecure: common.InsecureConfig{
-				SkipVerify: flagInsecureSkipVerify,
-				AllowHTTP:  flagInsecureAllowHTTP,
+		return &imageSource{
+			remote:    true,
+			dockerURL: dockerURL,
+			remoteConfig: docker2aci.RemoteConfig{
+				CommonConfig: cfg,
+				Username:     username,
+				Password:     password,
+				Insecure: common.InsecureConfig{
+					SkipVerify: flagInsecureSkipVerify,
+					AllowHTTP:  flagInsecureAllowHTTP,
+				},
+				Trust: common.TrustConfig{
+					Enabled: flagContentTrust,
+					Server:  flagTrustServer,
+					Dir:     flagTrustDir,
+				},
 			},
-		}
+		}, nil
+	}
 
-		aciLayerPaths, err = docker2aci.ConvertRemoteRepo(dockerURL, remoteConfig)
-	} else {
-		fileConfig := docker2aci.FileConfig{
+	if flagContentTrust {
+		return nil, fmt.Errorf("flag --content-trust works only with docker:// images.")
+	}
+	if strings.HasPrefix(arg, daemonPrefix) {
+		if flagImage != "" || flagPlatform != "" || flagAllImages || flagRootfs {
+			return nil, fmt.Errorf("flags --image, --platform, --all-images and --rootfs work only with files.")
+		}
+		return &imageSource{
+			daemon:    true,
+			dockerURL: strings.TrimPrefix(arg, daemonPrefix),
+			daemonConfig: docker2aci.DaemonConfig{
+				CommonConfig: cfg,
+			},
+		}, nil
+	}
+	if flagRootfs {
+		if flagAllImages || arg == stdinPath {
+			return nil, fmt.Errorf("flag --rootfs doesn't work with --all-images or the standard input.")
+		}
+		entrypoint, err := parseCommand(flagEntrypoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --entrypoint: %v", err)
+		}
+		cmd, err := parseCommand(flagCmd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --cmd: %v", err)
+		}
+		return &imageSource{
+			rootfs:   true,
+			filePath: arg,
+			rootfsConfig: docker2aci.RootfsConfig{
+				CommonConfig: cfg,
+				DockerURL:    flagImage,
+				ConfigFile:   flagConfig,
+				Platform:     flagPlatform,
+				Entrypoint:   entrypoint,
+				Cmd:          cmd,
+				Env:          flagEnv,
+				ExposedPorts: flagPort,
+				WorkingDir:   flagWorkdir,
+				User:         flagUser,
+			},
+		}, nil
+	}
+	if flagConfig != "" || flagEntrypoint != "" || flagCmd != "" || len(flagEnv) > 0 || len(flagPort) > 0 || flagWorkdir != "" || flagUser != "" {
+		return nil, fmt.Errorf("flags --config, --entrypoint, --cmd, --env, --port, --workdir and --user work only with --rootfs.")
+	}
+	if flagAllImages && flagImage != "" {
+		return nil, fmt.Errorf("flags --all-images and --image can't be used together.")
+	}
+	if flagImageFilter != "" && !flagAllImages {
+		return nil, fmt.Errorf("flag --image-filter works only with --all-images.")
+	}
+	var imageFilter []string
+	if flagImageFilter != "" {
+		imageFilter = strings.Split(flagImageFilter, ",")
+	}
+	return &imageSource{
+		filePath: arg,
+		fileConfig: docker2aci.FileConfig{
 			CommonConfig: cfg,
 			DockerURL:    flagImage,
+			Platform:     flagPlatform,
+			ImageFilter:  imageFilter,
+		},
+	}, nil
+}
+
+// parseCommand parses a command given as a JSON array or separated by
+// spaces. An empty command is nil.
+func parseCommand(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return strings.Fields(s), nil
+	}
+	var cmd []string
+	if err := json.Unmarshal([]byte(s), &cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func severalImagesError(err error) error {
+	switch serr := err.(type) {
+	case *common.ErrSeveralImages:
+		return fmt.Errorf("%s, use option --image with one of:\n\n%s", serr, strings.Join(serr.Images, "\n"))
+	case *common.ErrSeveralPlatforms:
+		return fmt.Errorf("%s, use option --platform with one of:\n\n%s", serr, strings.Join(serr.Platforms, "\n"))
+	}
+	return err
+}
+
+func runDocker2ACI(arg string) error {
+	src, err := getImageSource(arg)
+	if err != nil {
+		return err
+	}
+	if flagAllImages {
+		return convertAllImages(src)
+	}
+
+	var aciLayerPaths []string
+	if src.remote {
+		aciLayerPaths, err = docker2aci.ConvertRemoteRepo(src.dockerURL, src.remoteConfig)
+	} else if src.daemon {
+		aciLayerPaths, err = docker2aci.ConvertDaemonImage(src.dockerURL, src.daemonConfig)
+	} else if src.rootfs {
+		aciLayerPaths, err = docker2aci.ConvertRootfs(src.filePath, src.rootfsConfig)
+	} else {
+		if src.filePath == stdinPath {
+			aciLayerPaths, err = docker2aci.ConvertSavedReader(os.Stdin, src.fileConfig)
+		} else {
+			aciLayerPaths, err = docker2aci.ConvertSavedFile(src.filePath, src.fileConfig)
 		}
-		aciLayerPaths, err = docker2aci.ConvertSavedFile(arg, fileConfig)
-		if serr, ok := err.(*common.ErrSeveralImages); ok {
-			err = fmt.Errorf("%s, use option --image with one of:\n\n%s", serr, strings.Join(serr.Images, "\n"))
-		}
+		err = severalImagesError(err)
 	}
 	if err != nil {
 		return fmt.Errorf("conversion error: %v", err)
@@ -138,6 +473,7 @@ func runDocker2ACI(arg string) error {
 
 	printConvertedVolumes(*manifest)
 	printConvertedPorts(*manifest)
+	printExcludedFiles()
 
 	fmt.Printf("\nGenerated ACI(s):\n")
 	for _, aciFile := range aciLayerPaths {
@@ -147,6 +483,154 @@ func runDocker2ACI(arg string) error {
 	return nil
 }
 
+func convertAllImages(src *imageSource) error {
+	var acis map[string][]string
+	var err error
+	if src.filePath == stdinPath {
+		acis, err = docker2aci.ConvertAllSavedReader(os.Stdin, src.fileConfig)
+	} else {
+		acis, err = docker2aci.ConvertAllSavedFile(src.filePath, src.fileConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("conversion error: %v", err)
+	}
+
+	printExcludedFiles()
+
+	var images []string
+	for image := range acis {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	for _, image := range images {
+		name := image
+		if name == "" {
+			name = src.filePath
+		}
+		fmt.Printf("\nGenerated ACI(s) for %s:\n", name)
+		for _, aciFile := range acis[image] {
+			fmt.Println(aciFile)
+		}
+	}
+
+	return nil
+}
+
+func runInspect(arg string) error {
+	if flagAllImages {
+		return fmt.Errorf("flag --all-images isn't supported by inspect.")
+	}
+	src, err := getImageSource(arg)
+	if err != nil {
+		return err
+	}
+	if src.daemon {
+		return fmt.Errorf("inspect doesn't support %s images, as it would export the whole image.", daemonPrefix)
+	}
+	if src.rootfs {
+		return fmt.Errorf("inspect doesn't support --rootfs.")
+	}
+
+	var info *docker2aci.ImageInfo
+	if src.remote {
+		info, err = docker2aci.InspectRemoteRepo(src.dockerURL, src.remoteConfig)
+	} else {
+		if src.filePath == stdinPath {
+			info, err = docker2aci.InspectSavedReader(os.Stdin, src.fileConfig)
+		} else {
+			info, err = docker2aci.InspectSavedFile(src.filePath, src.fileConfig)
+		}
+		err = severalImagesError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("inspect error: %v", err)
+	}
+
+	if flagJSON {
+		b, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	b, err := json.MarshalIndent(info.Manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Image manifest:\n%s\n", b)
+
+	printConvertedVolumes(*info.Manifest)
+	printConvertedPorts(*info.Manifest)
+
+	fmt.Printf("\nLayers:\n")
+	for _, l := range info.Layers {
+		size := "unknown"
+		if l.Size >= 0 {
+			size = strconv.FormatInt(l.Size, 10)
+		}
+		fmt.Printf("\tdigest: %q, size: %s, mediaType: %q\n", l.Digest, size, l.MediaType)
+	}
+
+	return nil
+}
+
+// removedBytes counts the bytes of the files excluded from the converted
+// images.
+var removedBytes int64
+
+// summaryReporter returns a Reporter passing the events to r and counting
+// the excluded bytes for the summary.
+func summaryReporter(r progress.Reporter) progress.Reporter {
+	return progress.ReporterFunc(func(e progress.Event) {
+		if e.Type == progress.FilesExcluded {
+			atomic.AddInt64(&removedBytes, e.Removed)
+		}
+		r.Report(e)
+	})
+}
+
+func printExcludedFiles() {
+	if len(flagExclude) == 0 {
+		return
+	}
+	fmt.Printf("\nExcluded files:\n\t%d bytes removed\n", atomic.LoadInt64(&removedBytes))
+}
+
+func runLint(aciPaths []string) error {
+	problems, err := docker2aci.LintACIs(aciPaths)
+	if err != nil {
+		return fmt.Errorf("lint error: %v", err)
+	}
+
+	errors := 0
+	for _, p := range problems {
+		if p.Severity == docker2aci.LintError {
+			errors++
+		}
+	}
+	if flagJSON {
+		if problems == nil {
+			problems = []docker2aci.LintProblem{}
+		}
+		b, err := json.MarshalIndent(problems, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d error(s) found, the image can't run", errors)
+	}
+	return nil
+}
+
 func printConvertedVolumes(manifest schema.ImageManifest) {
 	if manifest.App == nil {
 		return
@@ -189,18 +673,45 @@ func getManifest(aciPath string) (*schema.ImageManifest, error) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "docker2aci [-debug] [-nosquash] [-compression=(gzip|none)] IMAGE\n")
+	fmt.Fprintf(os.Stderr, "docker2aci [-debug] [-nosquash] [-compression=(gzip|none)] [-content-trust] IMAGE\n")
 	fmt.Fprintf(os.Stderr, "  Where IMAGE is\n")
 	fmt.Fprintf(os.Stderr, "    [-image=IMAGE_NAME[:TAG]] FILEPATH\n")
+	fmt.Fprintf(os.Stderr, "    -all-images [-image-filter=PATTERN[,PATTERN...]] FILEPATH\n")
+	fmt.Fprintf(os.Stderr, "  where FILEPATH can be - to read the standard input\n")
+	fmt.Fprintf(os.Stderr, "  or\n")
+	fmt.Fprintf(os.Stderr, "    -rootfs [-image=IMAGE_NAME[:TAG]] [-config=FILE] [-entrypoint=...] [-env=NAME=VALUE...] FILEPATH\n")
 	fmt.Fprintf(os.Stderr, "  or\n")
 	fmt.Fprintf(os.Stderr, "    docker://[REGISTRYURL/]IMAGE_NAME[:TAG]\n")
+	fmt.Fprintf(os.Stderr, "  or\n")
+	fmt.Fprintf(os.Stderr, "    docker-daemon:IMAGE_NAME[:TAG]|IMAGE_ID\n")
+	fmt.Fprintf(os.Stderr, "  to export an image from the Docker daemon at $DOCKER_HOST\n")
+	fmt.Fprintf(os.Stderr, "docker2aci inspect [-json] [FLAGS] IMAGE\n")
+	fmt.Fprintf(os.Stderr, "  Prints the manifest the conversion of IMAGE would generate and its layers\n")
+	fmt.Fprintf(os.Stderr, "  without downloading them\n")
+	fmt.Fprintf(os.Stderr, "docker2aci lint [-json] ACI...\n")
+	fmt.Fprintf(os.Stderr, "  Checks that the converted ACIs, ordered from the base layer to the top one,\n")
+	fmt.Fprintf(os.Stderr, "  can run: the executable, user, group, working directory and mount points\n")
+	fmt.Fprintf(os.Stderr, "  of the app are checked against the rendered rootfs\n")
 	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
 }
 
 func main() {
 	flag.Usage = usage
-	flag.Parse()
+
+	run := runDocker2ACI
+	lint := false
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		flag.BoolVar(&flagJSON, "json", false, "Print the inspected image as JSON")
+		flag.CommandLine.Parse(os.Args[2:])
+		run = runInspect
+	} else if len(os.Args) > 1 && os.Args[1] == "lint" {
+		flag.BoolVar(&flagJSON, "json", false, "Print the problems found as JSON")
+		flag.CommandLine.Parse(os.Args[2:])
+		lint = true
+	} else {
+		flag.Parse()
+	}
 	args := flag.Args()
 
 	if flagVersion {
@@ -208,12 +719,18 @@ func main() {
 		return
 	}
 
-	if len(args) != 1 {
+	if lint && len(args) == 0 || !lint && len(args) != 1 {
 		usage()
 		os.Exit(2)
 	}
 
-	if err := runDocker2ACI(args[0]); err != nil {
+	var err error
+	if lint {
+		err = runLint(args)
+	} else {
+		err = run(args[0])
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,113 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/pkg/progressutil"
+)
+
+type barReporter struct {
+	lock      sync.Mutex
+	out       io.Writer
+	printer   *progressutil.ProgressBarPrinter
+	bars      map[string]*progressutil.ProgressBar
+	remaining int
+	last      time.Time
+}
+
+// NewBarReporter returns a Reporter drawing a progress bar on out for each
+// layer being downloaded, and printing warnings. Other events are ignored.
+func NewBarReporter(out io.Writer) Reporter {
+	return &barReporter{out: out}
+}
+
+func (r *barReporter) Report(e Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch e.Type {
+	case LayerDownloadStarted:
+		if r.printer == nil {
+			r.printer = &progressutil.ProgressBarPrinter{PadToBeEven: true}
+			r.bars = make(map[string]*progressutil.ProgressBar)
+		}
+		bar := r.printer.AddProgressBar()
+		bar.SetPrintBefore("Downloading " + shortLayer(e.Layer))
+		r.bars[e.Layer] = bar
+		r.remaining++
+		r.update(e, 0)
+		r.print()
+	case LayerDownloadProgress:
+		if r.update(e, 0) && time.Since(r.last) >= Interval {
+			r.print()
+		}
+	case LayerDownloadDone:
+		if r.update(e, 1) {
+			r.bars[e.Layer].SetCurrentProgress(1)
+			r.remaining--
+			r.print()
+		}
+		if r.remaining == 0 {
+			// start over with new bars for the next downloads
+			r.printer = nil
+		}
+	case Warning:
+		fmt.Fprintf(r.out, "Warning: %s\n", e.Message)
+	}
+}
+
+// update updates the bar of the event's layer, using progress if the size of
+// the layer is unknown. It returns false if there's no such bar.
+func (r *barReporter) update(e Event, progress float64) bool {
+	bar, ok := r.bars[e.Layer]
+	if !ok || r.printer == nil {
+		return false
+	}
+	total := "?"
+	if e.Total > 0 {
+		total = progressutil.ByteUnitStr(e.Total)
+		progress = float64(e.Current) / float64(e.Total)
+		if progress > 1 {
+			progress = 1
+		}
+	}
+	bar.SetPrintAfter(fmt.Sprintf("%s / %s", progressutil.ByteUnitStr(e.Current), total))
+	bar.SetCurrentProgress(progress)
+	return true
+}
+
+func (r *barReporter) print() {
+	r.last = time.Now()
+	// errors only happen when no bar has been added
+	r.printer.Print(r.out)
+}
+
+// shortLayer shortens a layer digest or ID for display.
+func shortLayer(layer string) string {
+	n := 12
+	if strings.HasPrefix(layer, "sha256:") {
+		n += len("sha256:") - 1
+	}
+	if len(layer) > n {
+		return layer[:n]
+	}
+	return layer
+}
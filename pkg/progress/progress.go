@@ -0,0 +1,157 @@
+// Copyright 2017 The appc Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress defines the events reported while converting images and
+// some reporters for them.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	LayerDownloadStarted  EventType = "layer-download-started"
+	LayerDownloadProgress EventType = "layer-download-progress"
+	LayerDownloadDone     EventType = "layer-download-done"
+	LayerConverted        EventType = "layer-converted"
+	SquashStarted         EventType = "squash-started"
+	SquashDone            EventType = "squash-done"
+	FilesExcluded         EventType = "files-excluded"
+	Warning               EventType = "warning"
+)
+
+// Interval is the minimum time between two LayerDownloadProgress events of
+// the same layer.
+const Interval = 500 * time.Millisecond
+
+// Event is something that happened during a conversion. Only the fields
+// relevant to its type are set.
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	Layer   string    `json:"layer,omitempty"`   // digest or ID of the layer
+	Current int64     `json:"current,omitempty"` // bytes of the layer downloaded so far
+	Total   int64     `json:"total,omitempty"`   // size of the layer, 0 if unknown
+	Path    string    `json:"path,omitempty"`    // path of the generated ACI
+	Removed int64     `json:"removed,omitempty"` // bytes of the files excluded from the layers
+	Message string    `json:"message,omitempty"` // warning message
+}
+
+// Reporter receives the events of a conversion. Report may be called
+// concurrently while layers are downloaded.
+type Reporter interface {
+	Report(Event)
+}
+
+// ReporterFunc is an adapter to use a function as a Reporter.
+type ReporterFunc func(Event)
+
+func (f ReporterFunc) Report(e Event) {
+	f(e)
+}
+
+// Warnf reports a warning.
+func Warnf(r Reporter, format string, args ...interface{}) {
+	r.Report(Event{
+		Type:    Warning,
+		Time:    time.Now(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+type nopReporter struct{}
+
+// NewNopReporter returns a Reporter discarding all events.
+func NewNopReporter() Reporter {
+	return &nopReporter{}
+}
+
+func (r *nopReporter) Report(Event) {
+	// nop
+}
+
+type jsonReporter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewJSONReporter returns a Reporter writing each event to out as a JSON
+// object on its own line.
+func NewJSONReporter(out io.Writer) Reporter {
+	return &jsonReporter{enc: json.NewEncoder(out)}
+}
+
+func (r *jsonReporter) Report(e Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// there's nothing sensible to do if the output is broken
+	r.enc.Encode(e)
+}
+
+// Reader reports the download of a layer as it is read.
+type Reader struct {
+	r        io.Reader
+	reporter Reporter
+	layer    string
+	current  int64
+	total    int64
+	last     time.Time
+}
+
+// NewReader reports that the download of layer, of size total (0 if unknown),
+// started and returns a Reader reporting its progress as r is read. Done must
+// be called once r has been read entirely.
+func NewReader(r io.Reader, reporter Reporter, layer string, total int64) *Reader {
+	pr := &Reader{
+		r:        r,
+		reporter: reporter,
+		layer:    layer,
+		total:    total,
+		last:     time.Now(),
+	}
+	pr.report(LayerDownloadStarted)
+	return pr
+}
+
+func (pr *Reader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.current += int64(n)
+	if now := time.Now(); now.Sub(pr.last) >= Interval {
+		pr.last = now
+		pr.report(LayerDownloadProgress)
+	}
+	return n, err
+}
+
+// Done reports that the download of the layer is complete.
+func (pr *Reader) Done() {
+	pr.report(LayerDownloadDone)
+}
+
+func (pr *Reader) report(t EventType) {
+	pr.reporter.Report(Event{
+		Type:    t,
+		Time:    time.Now(),
+		Layer:   pr.layer,
+		Current: pr.current,
+		Total:   pr.total,
+	})
+}
@@ -15,6 +15,8 @@ if [ ! -h ${DIR}/gopath/src/${REPO_PATH} ]; then
 fi
 
 export GO15VENDOREXPERIMENT=1
+# the sources are built in the GOPATH, with their vendor directory
+export GO111MODULE=off
 export GOPATH=${DIR}/gopath
 REPO_GOPATH="${GOPATH}/src/${REPO_PATH}"
 
@@ -24,6 +26,8 @@ go vet ./pkg/...
 go vet ./lib/...
 go test -v ${REPO_PATH}/lib/tests
 go test -v ${REPO_PATH}/lib/internal
+go test -v ${REPO_PATH}/lib/internal/backend/file
+go test -v ${REPO_PATH}/lib/internal/tuf
 go test -v ${REPO_PATH}/lib/common
 
 DOCKER2ACI=../bin/docker2aci